@@ -17,23 +17,24 @@ package cmd
 
 import (
 	"context"
-	"fmt"
 	"os"
-	"strconv"
+	"time"
 
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
-	yaml "sigs.k8s.io/yaml"
 
-	"github.com/akuity/mta/pkg/argo"
 	"github.com/akuity/mta/pkg/utils"
 	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
-	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/printers"
-	client "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // helmreleaseCmd represents the helmrelease command
@@ -48,7 +49,11 @@ mta helmrelease --name=myhelmrelease --namespace=flux-system | kubectl apply -n
 
 This utilty exports the named HelmRelease and the source Helm repo and
 creates a manifests to stdout, which you can pipe into an apply command
-with kubectl.`,
+with kubectl.
+
+The HelmRelease can also be read from manifests instead of the cluster:
+
+mta helmrelease --name=myhelmrelease --namespace=flux-system --from-file=./apps/myhelmrelease.yaml`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get the Argo CD namespace
 		argoCDNamespace, err := cmd.Flags().GetString("argocd-namespace")
@@ -57,13 +62,13 @@ with kubectl.`,
 		}
 
 		// Get the options from the CLI
-		kubeConfig, err := cmd.Flags().GetString("kubeconfig")
-		if err != nil {
-			log.Fatal(err)
-		}
 		helmReleaseName, _ := cmd.Flags().GetString("name")
 		helmReleaseNamespace, _ := cmd.Flags().GetString("namespace")
 		confirmMigrate, _ := cmd.Flags().GetBool("confirm-migrate")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		adopt, _ := cmd.Flags().GetBool("adopt")
+		dropSecretValues, _ := cmd.Flags().GetBool("drop-secret-values")
+		waitTimeout, _ := cmd.Flags().GetDuration("wait-timeout")
 
 		// Set up the default context
 		ctx := context.TODO()
@@ -71,21 +76,21 @@ with kubectl.`,
 		// Set up the schema because HelmRelease and Repo is a CRD
 		scheme := runtime.NewScheme()
 		helmv2.AddToScheme(scheme)
+		kustomizev1.AddToScheme(scheme)
 		sourcev1.AddToScheme(scheme)
+		sourcev1beta2.AddToScheme(scheme)
 		argov1alpha1.AddToScheme(scheme)
+		corev1.AddToScheme(scheme)
+		rbacv1.AddToScheme(scheme)
+		notificationv1beta2.AddToScheme(scheme)
 
-		// create rest config using the kubeconfig file.
-		restConfig, err := utils.NewRestConfig(kubeConfig)
+		// Create a new client for the cluster, or for the manifests if we're working offline
+		k, offline, err := newClient(cmd, scheme)
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		// Create a new client based on the restconfig and scheme
-		k, err := client.New(restConfig, client.Options{
-			Scheme: scheme,
-		})
-		if err != nil {
-			log.Fatal(err)
+		if offline && confirmMigrate && !dryRun {
+			log.Fatal("--confirm-migrate needs a cluster and can't be used with --from-file or --from-dir, use --dry-run to see the plan")
 		}
 
 		//Get the helmrelease based on type, report if there's an error
@@ -94,100 +99,76 @@ with kubectl.`,
 		if err != nil {
 			log.Fatal(err)
 		}
-		helmRepoNamespace := GetHelmRepoNamespace(helmRelease)
-
-		// Get the helmrepo based on type, report if error
-		helmRepo := &sourcev1.HelmRepository{}
-		err = k.Get(ctx, types.NamespacedName{Namespace: helmRepoNamespace, Name: helmRelease.Spec.Chart.Spec.SourceRef.Name}, helmRepo)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Get the helmchart based on type, report if error
-		helmChartName := fmt.Sprintf("%s-%s", helmReleaseNamespace, helmReleaseName)
 
-		helmChart := &sourcev1.HelmChart{}
-		err = k.Get(ctx, types.NamespacedName{Namespace: helmRepoNamespace, Name: helmChartName}, helmChart)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Get the Values from the HelmRelease
-		yaml, err := yaml.Marshal(helmRelease.Spec.Values)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		helmAppNamePrefix := helmRelease.Spec.TargetNamespace
-		if helmAppNamePrefix == "" {
-			helmAppNamePrefix = helmReleaseNamespace
-		}
-		// Generate the Argo CD Helm Application
-		helmApp := argo.ArgoCdHelmApplication{
-			//Name:                 helmRelease.Name,
-			Name:                 helmAppNamePrefix + "-" + helmRelease.Name,
-			Namespace:            argoCDNamespace,
-			DestinationNamespace: helmRelease.Spec.TargetNamespace,
-			DestinationServer:    "https://kubernetes.default.svc",
-			Project:              "default",
-			HelmChart:            helmRelease.Spec.Chart.Spec.Chart,
-			HelmRepo:             helmRepo.Spec.URL,
-			HelmTargetRevision:   helmRelease.Spec.Chart.Spec.Version,
-			HelmValues:           string(yaml),
-			HelmCreateNamespace:  strconv.FormatBool(helmRelease.Spec.Install.CreateNamespace),
-		}
-
-		helmArgoCdApp, err := argo.GenArgoCdHelmApplication(helmApp)
-		if err != nil {
-			log.Fatal(err)
-		}
+		// Migrate with the options from the CLI
+		opts := utils.MigrationOptions{Adopt: adopt, WaitTimeout: waitTimeout, DryRun: dryRun, Offline: offline, Naming: naming(), ClusterNames: clusterNames(), DropSecretValues: dropSecretValues}
 
 		// Do the migration automatically if that is set, if not print to stdout
-		if confirmMigrate {
-			log.Info("Migrating HelmRelease \"" + helmRelease.Name + "\" to Argo CD via an Application")
-			// Suspend helm reconciliation
-			if err := utils.SuspendFluxObject(k, ctx, helmRelease); err != nil {
+		if confirmMigrate || dryRun {
+			// In a dry-run, record the changes in a plan instead of making them. Otherwise keep a
+			// journal so the migration can be rolled back later.
+			var plan *utils.PlanClient
+			if dryRun {
+				plan = utils.NewPlanClient(k)
+				k = plan
+			} else if opts.Stores, err = journalStores(cmd, k, ctx); err != nil {
 				log.Fatal(err)
 			}
 
-			// suspend helm repo reconciliation
-			if err := utils.SuspendFluxObject(k, ctx, helmRepo); err != nil {
+			log.Info("Migrating HelmRelease \"" + helmRelease.Name + "\" to Argo CD via an Application")
+			if err := utils.MigrateHelmReleaseToApplication(k, ctx, argoCDNamespace, *helmRelease, opts); err != nil {
 				log.Fatal(err)
 			}
 
-			// suspend helm chart
-			if err := utils.SuspendFluxObject(k, ctx, helmChart); err != nil {
-				log.Fatal(err)
+			// Show what would have been done
+			if plan != nil {
+				if err := plan.Print(os.Stdout); err != nil {
+					log.Fatal(err)
+				}
 			}
 
-			// Finally, create the Argo CD Application
-			if err := utils.CreateK8SObjects(k, ctx, helmArgoCdApp); err != nil {
+		} else {
+			// Generate the Application, with its repository Secret
+			migration, err := utils.GetHelmReleaseMigration(k, ctx, argoCDNamespace, helmRelease, opts)
+			if err != nil {
 				log.Fatal(err)
 			}
 
-			// Delete the HelmRelease
-			if err := utils.DeleteK8SObjects(k, ctx, helmRelease); err != nil {
-				log.Fatal(err)
-			}
+			// Set the printer type to YAML
+			printr := printers.NewTypeSetter(k.Scheme()).ToPrinter(&printers.YAMLPrinter{})
 
-			// Delete the HelmRepo
-			if err := utils.DeleteK8SObjects(k, ctx, helmRepo); err != nil {
-				log.Fatal(err)
+			// Print the repository Secret to Stdout
+			if migration.Secret != nil {
+				if err := printr.PrintObj(migration.Secret, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+
+				// The CA and known hosts go in Argo CD's ConfigMaps, which already have things in them
+				utils.WarnRepositoryConfig(migration.RepoURL, migration.Credentials)
 			}
 
-			// Delete the chart
-			if err := utils.DeleteK8SObjects(k, ctx, helmChart); err != nil {
-				log.Fatal(err)
+			// Print the Secret of the cluster it deploys to
+			if migration.ClusterSecret != nil {
+				if err := printr.PrintObj(migration.ClusterSecret, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
 			}
 
-		} else {
-			// Set the printer type to YAML
-			printr := printers.NewTypeSetter(k.Scheme()).ToPrinter(&printers.YAMLPrinter{})
+			// Print the tenant's project, the Application is assigned to it
+			if migration.Project != nil {
+				if err := printr.PrintObj(migration.Project, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+			}
 
 			// print the AppSet YAML to Strdout
-			if err := printr.PrintObj(helmArgoCdApp, os.Stdout); err != nil {
+			if err := printr.PrintObj(migration.App, os.Stdout); err != nil {
 				log.Fatal(err)
 			}
+
+			// The Flux alerts go in Argo CD Notifications' ConfigMap and Secret, which already
+			// have things in them
+			utils.WarnNotifications(migration.Notifications)
 		}
 
 	},
@@ -207,4 +188,7 @@ func init() {
 	rootCmd.MarkPersistentFlagRequired("name")
 
 	helmreleaseCmd.Flags().Bool("confirm-migrate", false, "Automatically Migrate the HelmRelease to an ApplicationSet")
+	helmreleaseCmd.Flags().Bool("adopt", false, "Hand the live resources over to Argo CD without Flux uninstalling them, and only delete the HelmRelease once the Application is Synced and Healthy")
+	helmreleaseCmd.Flags().Duration("wait-timeout", 10*time.Minute, "How long to wait for the Application to be Synced and Healthy before deleting the Flux objects, 0 skips the wait")
+	helmreleaseCmd.Flags().Bool("dry-run", false, "Print the plan of changes --confirm-migrate would make, checked with a server-side dry-run, without making them")
 }
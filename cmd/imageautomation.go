@@ -0,0 +1,170 @@
+/*
+Copyright © 2022 Christian Hernandez christian@chernand.io
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/akuity/mta/pkg/utils"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+// imageAutomationCmd represents the imageautomation command
+var imageAutomationCmd = &cobra.Command{
+	Use:     "imageautomation",
+	Aliases: []string{"ia", "imageautomations"},
+	Short:   "Exports an ImageUpdateAutomation into Argo CD Image Updater annotations",
+	Long: `This is a migration tool that helps you move your Flux image automation to
+Argo CD Image Updater. Example:
+
+mta imageautomation --name=flux-system --namespace=flux-system
+
+The ImagePolicies next to the ImageUpdateAutomation are translated into Image
+Updater annotations on what the Kustomizations it updates are migrated to, and
+the new tags are committed to the branch Flux pushes to. Kustomizations that
+are migrated while the ImageUpdateAutomation is there get the annotations too.
+
+With --confirm-migrate the Applications and ApplicationSets the Kustomizations
+were already migrated to are annotated, and the ImageUpdateAutomation is deleted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get the Argo CD namespace
+		argoCDNamespace, err := cmd.Flags().GetString("argocd-namespace")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Get the options from the CLI
+		automationName, _ := cmd.Flags().GetString("name")
+		automationNamespace, _ := cmd.Flags().GetString("namespace")
+		confirmMigrate, _ := cmd.Flags().GetBool("confirm-migrate")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		outputMode, _ := cmd.Flags().GetString("output-mode")
+
+		// Set up the default context
+		ctx := context.TODO()
+
+		// Set up the scheme of components we need
+		scheme := runtime.NewScheme()
+		autov1beta1.AddToScheme(scheme)
+		imagev1beta2.AddToScheme(scheme)
+		kustomizev1.AddToScheme(scheme)
+		helmv2.AddToScheme(scheme)
+		sourcev1.AddToScheme(scheme)
+		corev1.AddToScheme(scheme)
+		rbacv1.AddToScheme(scheme)
+		argov1alpha1.AddToScheme(scheme)
+
+		// Create a new client for the cluster, or for the manifests if we're working offline
+		k, offline, err := newClient(cmd, scheme)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if offline && confirmMigrate {
+			log.Fatal("--confirm-migrate needs the Applications in the cluster and can't be used with --from-file or --from-dir")
+		}
+
+		// Get the ImageUpdateAutomation
+		automation := &autov1beta1.ImageUpdateAutomation{}
+		err = k.Get(ctx, types.NamespacedName{Namespace: automationNamespace, Name: automationName}, automation)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		opts := utils.MigrationOptions{OutputMode: outputMode, Naming: naming(), ClusterNames: clusterNames(), Offline: offline}
+
+		// Annotate what the Kustomizations were migrated to if that is set, if not print to stdout
+		if confirmMigrate || dryRun {
+			// In a dry-run, record the changes in a plan instead of making them
+			var plan *utils.PlanClient
+			if dryRun {
+				plan = utils.NewPlanClient(k)
+				k = plan
+			} else {
+				if opts.Stores, err = journalStores(cmd, k, ctx); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			log.Info("Migrating ImageUpdateAutomation \"" + automation.Name + "\" to Argo CD Image Updater")
+			if err := utils.MigrateImageAutomation(k, ctx, argoCDNamespace, automation, opts); err != nil {
+				log.Fatal(err)
+			}
+
+			// Show what would have been done
+			if plan != nil {
+				if err := plan.Print(os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+		} else {
+			// Set the printer type to YAML
+			printr := printers.NewTypeSetter(k.Scheme()).ToPrinter(&printers.YAMLPrinter{})
+
+			// Get the Kustomizations the automation updates
+			kustomizationList := &kustomizev1.KustomizationList{}
+			if err := k.List(ctx, kustomizationList); err != nil {
+				log.Fatal(err)
+			}
+
+			// Print what each of them is migrated to, with the annotations
+			found := false
+			for i := range kustomizationList.Items {
+				kustomization := &kustomizationList.Items[i]
+				if !utils.Automates(automation, kustomization) {
+					continue
+				}
+				found = true
+
+				migration, err := utils.GetKustomizationMigration(k, ctx, argoCDNamespace, kustomization, nil, opts)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := printr.PrintObj(migration.App, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+
+				// How Image Updater commits goes in its ConfigMap, which already has things in it
+				utils.WarnImageUpdaterConfig(migration.ImageUpdaterConfig)
+			}
+			if !found {
+				log.Fatal("ImageUpdateAutomation \"" + automation.Name + "\" doesn't update any Kustomization")
+			}
+		}
+
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(imageAutomationCmd)
+
+	imageAutomationCmd.Flags().Bool("confirm-migrate", false, "Annotate the Applications and ApplicationSets the Kustomizations were migrated to, and delete the ImageUpdateAutomation")
+	imageAutomationCmd.Flags().Bool("dry-run", false, "Print the plan of changes --confirm-migrate would make, checked with a server-side dry-run, without making them")
+	imageAutomationCmd.Flags().String("output-mode", utils.OutputModeApplicationSet, "What the Kustomizations are migrated to, an \"applicationset\" or an \"application\"")
+}
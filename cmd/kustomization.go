@@ -18,20 +18,25 @@ package cmd
 import (
 	"context"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/akuity/mta/pkg/argo"
 	"github.com/akuity/mta/pkg/utils"
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/printers"
-	client "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // kustomizationCmd represents the kustomization command
@@ -46,7 +51,11 @@ mta kustomization --name=mykustomization --namespace=flux-system | kubectl apply
 
 This utilty exports the named Kustomization and the source Git repo and
 creates a manifests to stdout, which you can pipe into an apply command
-with kubectl.`,
+with kubectl.
+
+The Kustomization can also be read from manifests instead of the cluster:
+
+mta kustomization --name=mykustomization --namespace=flux-system --from-dir=./clusters/production`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get excluded-dirs from the cli
 		exd, err := cmd.Flags().GetStringSlice("exclude-dirs")
@@ -61,13 +70,15 @@ with kubectl.`,
 		}
 
 		// Get the options from the CLI
-		kubeConfig, err := cmd.Flags().GetString("kubeconfig")
-		if err != nil {
-			log.Fatal(err)
-		}
 		kustomizationName, _ := cmd.Flags().GetString("name")
 		kustomizationNamespace, _ := cmd.Flags().GetString("namespace")
 		confirmMigrate, _ := cmd.Flags().GetBool("confirm-migrate")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		adopt, _ := cmd.Flags().GetBool("adopt")
+		waitTimeout, _ := cmd.Flags().GetDuration("wait-timeout")
+		pluginImage, _ := cmd.Flags().GetString("plugin-image")
+		sopsPluginImage, _ := cmd.Flags().GetString("sops-plugin-image")
+		outputMode, _ := cmd.Flags().GetString("output-mode")
 
 		// Set up the default context
 		ctx := context.TODO()
@@ -75,22 +86,23 @@ with kubectl.`,
 		// Set up the scheme of components we need
 		scheme := runtime.NewScheme()
 		kustomizev1.AddToScheme(scheme)
+		helmv2.AddToScheme(scheme)
 		sourcev1.AddToScheme(scheme)
 		corev1.AddToScheme(scheme)
+		appsv1.AddToScheme(scheme)
+		rbacv1.AddToScheme(scheme)
 		argov1alpha1.AddToScheme(scheme)
+		imagev1beta2.AddToScheme(scheme)
+		autov1beta1.AddToScheme(scheme)
+		notificationv1beta2.AddToScheme(scheme)
 
-		// create rest config using the kubeconfig file.
-		restConfig, err := utils.NewRestConfig(kubeConfig)
+		// Create a new client for the cluster, or for the manifests if we're working offline
+		k, offline, err := newClient(cmd, scheme)
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		// Create a new client based on the restconfig and scheme
-		k, err := client.New(restConfig, client.Options{
-			Scheme: scheme,
-		})
-		if err != nil {
-			log.Fatal(err)
+		if offline && confirmMigrate && !dryRun {
+			log.Fatal("--confirm-migrate needs a cluster and can't be used with --from-file or --from-dir, use --dry-run to see the plan")
 		}
 
 		// get the kustomization based on the type, report if there's an error
@@ -100,114 +112,110 @@ with kubectl.`,
 			log.Fatal(err)
 		}
 
-		gitRepoNamespace := getGitRepoNamespace(kustomization)
-		gitRepoName := kustomization.Spec.SourceRef.Name
+		// Migrate with the options from the CLI
+		opts := utils.MigrationOptions{Adopt: adopt, WaitTimeout: waitTimeout, DryRun: dryRun, Offline: offline, PluginImage: pluginImage, SOPSPluginImage: sopsPluginImage, OutputMode: outputMode, Naming: naming(), ClusterNames: clusterNames()}
+		plugin := utils.NewEnvsubstPlugin(argoCDNamespace, pluginImage)
+		sopsPlugin := utils.NewSOPSPlugin(argoCDNamespace, sopsPluginImage)
 
-		// get the gitsource
-		gitSource := &sourcev1.GitRepository{}
-		err = k.Get(ctx, types.NamespacedName{Namespace: gitRepoNamespace, Name: gitRepoName}, gitSource)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		//Get the secret holding the info we need
-		var sshPrivateKey string
-		if gitSource.Spec.SecretRef != nil && gitSource.Spec.SecretRef.Name != "" {
-			secret := &corev1.Secret{}
-			err = k.Get(ctx, types.NamespacedName{Namespace: kustomizationNamespace, Name: gitSource.Spec.SecretRef.Name}, secret)
-			if err != nil {
+		// Do the migration automatically if that is set, if not print to stdout
+		if confirmMigrate || dryRun {
+			// In a dry-run, record the changes in a plan instead of making them. Otherwise keep a
+			// journal so the migration can be rolled back later.
+			var plan *utils.PlanClient
+			if dryRun {
+				plan = utils.NewPlanClient(k)
+				k = plan
+			} else if opts.Stores, err = journalStores(cmd, k, ctx); err != nil {
 				log.Fatal(err)
 			}
 
-			sshPrivateKey = string(secret.Data["identity"])
-		} else {
-			log.Info("Warning: SecretRef is not defined in the GitRepository spec. Proceeding without the SSH private key.")
-			sshPrivateKey = "" // Leave the SSHPrivateKey empty if the secret is not available
-		}
-
-		//	Argo CD ApplicationSet is sensitive about how you give it paths in the Git Dir generator. We need to figure some things out
-		var sourcePath string
-		var sourcePathExclude string
+			log.Info("Migrating Kustomization \"" + kustomization.Name + "\" to Argo CD")
+			if err := utils.MigrateKustomizationToApplicationSet(k, ctx, argoCDNamespace, *kustomization, exd, opts); err != nil {
+				log.Fatal(err)
+			}
 
-		spl := strings.SplitAfter(kustomization.Spec.Path, "./")
+			// Show what would have been done
+			if plan != nil {
+				if err := plan.Print(os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+			}
 
-		if len(spl[1]) == 0 {
-			sourcePath = `*`
-			sourcePathExclude = "flux-system"
 		} else {
-			sourcePath = spl[1] + "/*"
-			sourcePathExclude = spl[1] + "/flux-system"
-		}
-
-		// Add sourcePathExclude to the excludedDirs
-		exd = append(exd, sourcePathExclude)
-
-		// Generate the ApplicationSet manifest based on the struct
-		applicationSet := argo.GitDirApplicationSet{
-			Namespace:               argoCDNamespace,
-			GitRepoURL:              gitSource.Spec.URL,
-			GitRepoRevision:         gitSource.Spec.Reference.Branch,
-			GitIncludeDir:           sourcePath,
-			GitExcludeDir:           exd,
-			AppName:                 "{{path.basename}}",
-			AppProject:              "default",
-			AppRepoURL:              gitSource.Spec.URL,
-			AppTargetRevision:       gitSource.Spec.Reference.Branch,
-			AppPath:                 "{{path}}",
-			AppDestinationServer:    "https://kubernetes.default.svc",
-			AppDestinationNamespace: kustomization.Spec.TargetNamespace,
-			SSHPrivateKey:           sshPrivateKey,
-			GitOpsRepo:              gitSource.Spec.URL,
-		}
-
-		appset, err := argo.GenGitDirAppSet(applicationSet)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Generate the ApplicationSet Secret and set the GVK
-		appsetSecret := utils.GenK8SSecret(applicationSet)
-
-		// Do the migration automatically if that is set, if not print to stdout
-		if confirmMigrate {
-			// Suspend kustomization reconcilation
-			if err := utils.SuspendFluxObject(k, ctx, kustomization); err != nil {
+			// Generate the Application or ApplicationSet, with its repository Secret
+			migration, err := utils.GetKustomizationMigration(k, ctx, argoCDNamespace, kustomization, exd, opts)
+			if err != nil {
 				log.Fatal(err)
 			}
 
-			// Suspend the GitRepo reconcilation
-			if err := utils.SuspendFluxObject(k, ctx, gitSource); err != nil {
+			// Print the Application or ApplicationSet and Secret to stdout
+			// Set the printer type to YAML
+			printr := printers.NewTypeSetter(k.Scheme()).ToPrinter(&printers.YAMLPrinter{})
+
+			// Print the repository secret to Stdout
+			if err := printr.PrintObj(migration.Secret, os.Stdout); err != nil {
 				log.Fatal(err)
 			}
 
-			// Finally, create the ApplicationSet with the ApplicationSet Secret
-			log.Info("Migrating Kustomization \"" + kustomization.Name + "\" to ArgoCD via an ApplicationSet")
-			if err := utils.CreateK8SObjects(k, ctx, appsetSecret, appset); err != nil {
-				log.Fatal(err)
+			// Print the Secret of the cluster it deploys to
+			if migration.ClusterSecret != nil {
+				if err := printr.PrintObj(migration.ClusterSecret, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
 			}
 
-			// If the migration is successful, delete the Kustomization and GitRepo
-			if err := utils.DeleteK8SObjects(k, ctx, kustomization); err != nil {
-				log.Fatal(err)
+			// Print the tenant's project, the Applications are assigned to it
+			if migration.Project != nil {
+				if err := printr.PrintObj(migration.Project, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
 			}
 
-			if err := utils.DeleteK8SObjects(k, ctx, gitSource); err != nil {
+			// print the Application or AppSet YAML to Strdout
+			if err := printr.PrintObj(migration.App, os.Stdout); err != nil {
 				log.Fatal(err)
 			}
 
-		} else {
-			// Print the ApplicationSet and Secret to stdout
-			// Set the printer type to YAML
-			printr := printers.NewTypeSetter(k.Scheme()).ToPrinter(&printers.YAMLPrinter{})
-
-			// Print the AppSet secret to Stdout
-			if err := printr.PrintObj(appsetSecret, os.Stdout); err != nil {
-				log.Fatal(err)
+			// The CA and known hosts go in Argo CD's ConfigMaps, which already have things in them
+			utils.WarnRepositoryConfig(migration.RepoURL, migration.Credentials)
+
+			// So does how Image Updater commits
+			utils.WarnImageUpdaterConfig(migration.ImageUpdaterConfig)
+
+			// And the Flux alerts Argo CD Notifications sends
+			utils.WarnNotifications(migration.Notifications)
+
+			// Print the plugin's ConfigMap, the sidecar is patched into the repo server
+			if migration.Plugin {
+				cm, err := argo.GenPluginConfigMap(plugin)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := printr.PrintObj(cm, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+				if err := utils.WarnPlugin(plugin); err != nil {
+					log.Fatal(err)
+				}
 			}
 
-			// print the AppSet YAML to Strdout
-			if err := printr.PrintObj(appset, os.Stdout); err != nil {
-				log.Fatal(err)
+			// Print the keys the Applications are decrypted with, and the plugin that decrypts
+			if migration.Decryption != nil {
+				if len(migration.Decryption.Keys) > 0 {
+					if err := printr.PrintObj(utils.GenSOPSKeysSecret(argoCDNamespace, migration.Decryption.Keys), os.Stdout); err != nil {
+						log.Fatal(err)
+					}
+				}
+				cm, err := argo.GenPluginConfigMap(sopsPlugin)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if err := printr.PrintObj(cm, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+				if err := utils.WarnPlugin(sopsPlugin); err != nil {
+					log.Fatal(err)
+				}
 			}
 
 		}
@@ -229,5 +237,11 @@ func init() {
 	rootCmd.MarkPersistentFlagRequired("name")
 
 	kustomizationCmd.Flags().Bool("confirm-migrate", false, "Automatically Migrate the Kustomization to an ApplicationSet")
+	kustomizationCmd.Flags().Bool("adopt", false, "Hand the live resources over to Argo CD without Flux pruning them, and only delete the Kustomization once the Applications are Synced and Healthy")
+	kustomizationCmd.Flags().Duration("wait-timeout", 10*time.Minute, "How long to wait for the Applications to be Synced and Healthy before deleting the Flux objects, 0 skips the wait")
+	kustomizationCmd.Flags().Bool("dry-run", false, "Print the plan of changes --confirm-migrate would make, checked with a server-side dry-run, without making them")
+	kustomizationCmd.Flags().String("output-mode", utils.OutputModeApplicationSet, "Migrate the Kustomization to an \"applicationset\" with an Application for each directory under its path, or to a single \"application\" with the same path. flux-system is always migrated to an ApplicationSet")
+	kustomizationCmd.Flags().String("plugin-image", utils.DefaultPluginImage, "Image with the kubectl and flux CLIs, for the plugin that renders Kustomizations with postBuild substitutions")
+	kustomizationCmd.Flags().String("sops-plugin-image", "", "Image with sh and the sops, gpg, kubectl and flux CLIs, for the plugin that decrypts Kustomizations with spec.decryption. Required to migrate them")
 	kustomizationCmd.Flags().StringSlice("exclude-dirs", []string{}, "Additional Directories (besides flux-system) to exclude from the GitDir generator. Can be single or comma separated")
 }
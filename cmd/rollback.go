@@ -0,0 +1,119 @@
+/*
+Copyright © 2022 Christian Hernandez christian@chernand.io
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/akuity/mta/pkg/utils"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Undoes a migration using its journal",
+	Long: `Every confirmed migration writes a journal with the original Flux objects, the
+Argo CD objects that were created and the actions that were taken. This command
+uses the journal to undo the migration: the Argo CD Applications and ApplicationSets
+that mta created are deleted, and so are the repository Secrets, AppProjects,
+cluster Secrets and ConfigMaps it created once no other migration or Application
+uses them. The changes made to objects that were already there are reverted, and
+the Flux Kustomizations, GitRepositories, HelmReleases, HelmRepositories and
+HelmCharts are recreated and resumed. Example:
+
+mta rollback --journal 20231121-142501-3fa9c2
+
+The journal ID is logged at the end of the migration. Journals are read from
+--journal-dir first, then from the ConfigMaps in the Argo CD namespace.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get the options from the CLI
+		journalID, _ := cmd.Flags().GetString("journal")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		// Set up the default context
+		ctx := context.TODO()
+
+		// The journal objects are read as unstructured, the scheme needs the journal ConfigMaps, the
+		// Applications that may use shared objects and the repo server the undo patches may revert
+		scheme := runtime.NewScheme()
+		corev1.AddToScheme(scheme)
+		appsv1.AddToScheme(scheme)
+		argov1alpha1.AddToScheme(scheme)
+
+		// Create a new client for the cluster
+		k, offline, err := newClient(cmd, scheme)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if offline && !dryRun {
+			log.Fatal("rollback needs a cluster and can't be used with --from-file or --from-dir, use --dry-run to see the plan")
+		}
+
+		// Find the journal
+		stores, err := journalStores(cmd, k, ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		journal, err := utils.LoadJournal(journalID, stores...)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// In a dry-run, record the changes in a plan instead of making them
+		var plan *utils.PlanClient
+		if dryRun {
+			plan = utils.NewPlanClient(k)
+			k = plan
+		}
+
+		log.Info("Rolling back migration " + journal.ID + " from " + journal.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+		rollbackErr := utils.RollbackJournal(k, ctx, journal, stores...)
+
+		// Show what would have been done
+		if plan != nil {
+			if err := plan.Print(os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		// Keep the rollback actions in the journal, whether it worked or not
+		for _, s := range stores {
+			if err := s.Save(journal); err != nil {
+				log.Warn("Could not update the migration journal: ", err)
+			}
+		}
+		if rollbackErr != nil {
+			log.Fatal(rollbackErr)
+		}
+		log.Info("Migration " + journal.ID + " rolled back")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().String("journal", "", "ID of the migration journal to roll back")
+	rollbackCmd.Flags().Bool("dry-run", false, "Print the plan of changes the rollback would make, checked with a server-side dry-run, without making them")
+	rollbackCmd.MarkFlagRequired("journal")
+}
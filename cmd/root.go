@@ -17,11 +17,16 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/akuity/mta/pkg/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/runtime"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // set up the global config file
@@ -45,7 +50,11 @@ HelmRelease example:
 
 This utilty exports the named Kustomization or HelmRelease and the source Git repo or Helm repo and
 creates a manifests to stdout, which you can pipe into an apply command
-with kubectl.`,
+with kubectl.
+
+Flux objects can also be read from manifests instead of a cluster:
+
+	mta kustomization --name=mykustomization --from-dir=./clusters/production`,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -70,12 +79,120 @@ func init() {
 	rootCmd.PersistentFlags().String("name", "", "Name of Kustomization or HelmRelease to export")
 	rootCmd.PersistentFlags().String("namespace", "flux-system", "Namespace of where the Kustomization or HelmRelease is")
 	rootCmd.PersistentFlags().String("argocd-namespace", "argocd", "Namespace where Argo CD is installed")
+	rootCmd.PersistentFlags().String("journal-dir", "", "Directory migration journals are written to (default is $HOME/.mta/journals)")
+	rootCmd.PersistentFlags().Bool("journal-configmap", true, "Also keep migration journals in a ConfigMap in the Argo CD namespace")
+	rootCmd.PersistentFlags().StringSlice("from-file", []string{}, "Read Flux objects from these manifest files instead of the cluster. Can be single or comma separated")
+	rootCmd.PersistentFlags().StringSlice("from-dir", []string{}, "Read Flux objects from the manifests in these directories (recursively) instead of the cluster. Can be single or comma separated")
+	rootCmd.PersistentFlags().String("helmrelease-name-template", utils.DefaultHelmReleaseAppNameTemplate, "Template for the names of the Applications HelmReleases are migrated to, the Helm releases keep their names")
+	rootCmd.PersistentFlags().String("kustomization-name-template", utils.DefaultKustomizationNameTemplate, "Template for the names of the Applications and ApplicationSets Kustomizations are migrated to")
+	rootCmd.PersistentFlags().String("secret-name-template", utils.DefaultRepositorySecretNameTemplate, "Template for the names of the repository Secrets, migrations from the same repository share the Secret")
+	rootCmd.PersistentFlags().Bool("drop-secret-values", false, "Migrate HelmReleases that get values from Secrets without those values, instead of refusing to. Argo CD keeps the values of an Application in plain text")
+	rootCmd.PersistentFlags().StringToString("cluster-names", map[string]string{}, "Map the kubeConfig Secrets of Flux objects to clusters already registered with Argo CD, as namespace/secret=cluster or secret=cluster. Others get a cluster Secret from the kubeconfig")
+
+	// The name templates can also be set in the config file, under naming
+	viper.BindPFlag("naming.helmrelease", rootCmd.PersistentFlags().Lookup("helmrelease-name-template"))
+	viper.BindPFlag("naming.kustomization", rootCmd.PersistentFlags().Lookup("kustomization-name-template"))
+	viper.BindPFlag("naming.secret", rootCmd.PersistentFlags().Lookup("secret-name-template"))
+
+	// And the cluster names, under clusters
+	viper.BindPFlag("clusters", rootCmd.PersistentFlags().Lookup("cluster-names"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	//rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// newClient returns a client for the cluster in the kubeconfig or, when --from-file or --from-dir
+// are set, an in-memory client holding the objects in those manifests. The second return value
+// reports whether the client is offline.
+func newClient(cmd *cobra.Command, scheme *runtime.Scheme) (client.Client, bool, error) {
+	files, err := cmd.Flags().GetStringSlice("from-file")
+	if err != nil {
+		return nil, false, err
+	}
+	dirs, err := cmd.Flags().GetStringSlice("from-dir")
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Read the manifests if we were given any
+	if len(files) > 0 || len(dirs) > 0 {
+		objs, err := utils.LoadManifests(files, dirs)
+		if err != nil {
+			return nil, true, err
+		}
+		return utils.NewOfflineClient(scheme, objs...), true, nil
+	}
+
+	// Get the Kubeconfig to use
+	kubeConfig, err := cmd.Flags().GetString("kubeconfig")
+	if err != nil {
+		return nil, false, err
+	}
+
+	// create rest config using the kubeconfig file.
+	restConfig, err := utils.NewRestConfig(kubeConfig)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Create a new client based on the restconfig and scheme
+	k, err := client.New(restConfig, client.Options{
+		Scheme: scheme,
+	})
+	return k, false, err
+}
+
+// journalStores returns where migration journals are kept, based on the --journal-dir and
+// --journal-configmap flags
+func journalStores(cmd *cobra.Command, k client.Client, ctx context.Context) ([]utils.JournalStore, error) {
+	var stores []utils.JournalStore
+
+	// Local journals, in the home directory unless told otherwise
+	dir, err := cmd.Flags().GetString("journal-dir")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".mta", "journals")
+	}
+	stores = append(stores, utils.FileJournalStore{Dir: dir})
+
+	// Journals kept in the cluster, next to Argo CD
+	inCluster, err := cmd.Flags().GetBool("journal-configmap")
+	if err != nil {
+		return nil, err
+	}
+	if inCluster {
+		argoCDNamespace, err := cmd.Flags().GetString("argocd-namespace")
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, utils.ConfigMapJournalStore{Client: k, Ctx: ctx, Namespace: argoCDNamespace})
+	}
+
+	return stores, nil
+}
+
+// naming returns the templates migrated objects are named with, from the flags or the config file
+func naming() utils.Naming {
+	return utils.Naming{
+		HelmRelease:      viper.GetString("naming.helmrelease"),
+		Kustomization:    viper.GetString("naming.kustomization"),
+		RepositorySecret: viper.GetString("naming.secret"),
+	}
+}
+
+// clusterNames returns the Argo CD clusters kubeConfig Secrets are mapped to, from the flag or the
+// config file
+func clusterNames() map[string]string {
+	return viper.GetStringMapString("clusters")
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -17,22 +17,37 @@ package cmd
 
 import (
 	"context"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/akuity/mta/pkg/argo"
 	"github.com/akuity/mta/pkg/utils"
 	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	fluxlog "github.com/fluxcd/flux2/pkg/log"
 	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	kustomizev1beta2 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/manifoldco/promptui"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
-	client "sigs.k8s.io/controller-runtime/pkg/client"
+	"k8s.io/cli-runtime/pkg/printers"
 )
 
 // scanCmd represents the scan command
@@ -52,12 +67,6 @@ displays the results.
 		// Set up the default context
 		ctx := context.TODO()
 
-		// Get the Kubeconfig to use
-		kubeConfig, err := cmd.Flags().GetString("kubeconfig")
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		// Get automigrate option from the cli
 		autoMigrate, err := cmd.Flags().GetBool("auto-migrate")
 		if err != nil {
@@ -69,6 +78,55 @@ displays the results.
 			log.Fatal(err)
 		}
 
+		// Get the force option from the cli, it migrates what isn't safe to
+		force, _ := cmd.Flags().GetBool("force")
+
+		// Get whether HelmReleases lose their values from Secrets, instead of failing the migration
+		dropSecretValues, _ := cmd.Flags().GetBool("drop-secret-values")
+
+		// Get the dry-run option from the cli
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Get the adoption options from the cli
+		adopt, _ := cmd.Flags().GetBool("adopt")
+		waitTimeout, _ := cmd.Flags().GetDuration("wait-timeout")
+		pluginImage, _ := cmd.Flags().GetString("plugin-image")
+		sopsPluginImage, _ := cmd.Flags().GetString("sops-plugin-image")
+		outputMode, _ := cmd.Flags().GetString("output-mode")
+
+		// Get the format of the inventory from the cli, the table is the default
+		output, _ := cmd.Flags().GetString("output")
+
+		// Get the app-of-apps options from the cli
+		appOfApps := utils.AppOfApps{}
+		appOfApps.Name, _ = cmd.Flags().GetString("app-of-apps")
+		appOfApps.Path, _ = cmd.Flags().GetString("app-of-apps-path")
+		appOfApps.RepoURL, _ = cmd.Flags().GetString("app-of-apps-repo")
+		appOfApps.Revision, _ = cmd.Flags().GetString("app-of-apps-revision")
+
+		// Get the filters from the cli, --namespace is only a filter when it's given, scan looks
+		// in all namespaces by default
+		namespace := ""
+		allNamespaces, _ := cmd.Flags().GetBool("all-namespaces")
+		if cmd.Flags().Changed("namespace") {
+			if allNamespaces {
+				log.Fatal("--namespace and --all-namespaces can't be used together")
+			}
+			namespace, _ = cmd.Flags().GetString("namespace")
+		}
+		selector, _ := cmd.Flags().GetString("selector")
+		fieldSelector, _ := cmd.Flags().GetString("field-selector")
+		include, _ := cmd.Flags().GetStringSlice("include")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		kinds, _ := cmd.Flags().GetStringSlice("kind")
+		filter, err := utils.NewScanFilter(namespace, selector, fieldSelector, include, exclude, kinds)
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		// Get the Argo CD namespace in case of auto-migrate
 		argoCDNamespace, err := cmd.Flags().GetString("argocd-namespace")
 		if err != nil {
@@ -80,40 +138,108 @@ displays the results.
 		kustomizev1.AddToScheme(kScheme)
 		sourcev1.AddToScheme(kScheme)
 		helmv2.AddToScheme(kScheme)
-		sourcev1.AddToScheme(kScheme)
+		sourcev1beta2.AddToScheme(kScheme)
 		corev1.AddToScheme(kScheme)
 		argov1alpha1.AddToScheme(kScheme)
 
-		// create rest config using the kubeconfig file.
-		restConfig, err := utils.NewRestConfig(kubeConfig)
-		if err != nil {
-			log.Fatal(err)
-		}
+		// The Flux uninstall silently skips anything the scheme doesn't know about, so add
+		// everything it removes
+		appsv1.AddToScheme(kScheme)
+		rbacv1.AddToScheme(kScheme)
+		networkingv1.AddToScheme(kScheme)
+		apiextensionsv1.AddToScheme(kScheme)
+		kustomizev1beta2.AddToScheme(kScheme)
+		notificationv1beta2.AddToScheme(kScheme)
+		imagev1beta2.AddToScheme(kScheme)
+		autov1beta1.AddToScheme(kScheme)
 
-		// Create a new client based on the restconfig and scheme
-		k, err := client.New(restConfig, client.Options{
-			Scheme: kScheme,
-		})
+		// Create a new client for the cluster, or for the manifests if we're working offline
+		k, offline, err := newClient(cmd, kScheme)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if offline && autoMigrate && !dryRun {
+			log.Fatal("--auto-migrate needs a cluster and can't be used with --from-file or --from-dir, use --dry-run to see the plan")
+		}
+		if dryRun && !autoMigrate {
+			log.Fatal("--dry-run only applies to --auto-migrate")
+		}
+		if force && !autoMigrate {
+			log.Fatal("--force only applies to --auto-migrate")
+		}
+		if adopt && !autoMigrate {
+			log.Fatal("--adopt only applies to --auto-migrate")
+		}
+		if appOfApps.Name != "" && autoMigrate {
+			log.Fatal("--app-of-apps generates the Applications to commit to the repository and can't be used with --auto-migrate")
+		}
+		if output != "" && (autoMigrate || appOfApps.Name != "") {
+			log.Fatal("--output only applies to the inventory, not to --auto-migrate or --app-of-apps")
+		}
 
-		// Get all Helm Releases in the cluster
+		// Get all Helm Releases in the cluster that get through the filters
 		helmReleaseList := &helmv2.HelmReleaseList{}
-		if err = k.List(ctx, helmReleaseList); err != nil {
+		if err = k.List(ctx, helmReleaseList, filter.ListOptions()...); err != nil {
 			log.Fatal(err)
 		}
+		helmReleaseList.Items = filter.HelmReleases(helmReleaseList.Items)
 
-		// Get all Kustomizations in the cluster
+		// Get all Kustomizations in the cluster that get through the filters
 		kustomizationList := &kustomizev1.KustomizationList{}
-		if err = k.List(ctx, kustomizationList); err != nil {
+		if err = k.List(ctx, kustomizationList, filter.ListOptions()...); err != nil {
 			log.Fatal(err)
 		}
+		kustomizationList.Items = filter.Kustomizations(kustomizationList.Items)
+
+		// Get all image automations, Kustomizations whose images they update are migrated to
+		// Argo CD Image Updater
+		automationList := &autov1beta1.ImageUpdateAutomationList{}
+		if err = k.List(ctx, automationList, filter.ListOptions()...); err != nil && !meta.IsNoMatchError(err) {
+			log.Fatal(err)
+		}
+		automationList.Items = filter.ImageUpdateAutomations(automationList.Items)
+
+		// Order them by their dependsOn, Argo CD syncs the lower sync waves first
+		graph := utils.NewDependencyGraph(kustomizationList.Items, helmReleaseList.Items)
+		waves, wavesErr := graph.Waves()
 
 		// Automigrate if the flag is set, otherwise just display the table
 		if autoMigrate {
-			// Prompt user to confirm migration
-			if !confirmMigrate {
+			// Without an order, dependencies could be migrated after what depends on them
+			if wavesErr != nil {
+				log.Fatal(wavesErr)
+			}
+
+			// Only migrate what's safe to, unless we're told to migrate everything
+			assessOpts := utils.MigrationOptions{DryRun: dryRun, PluginImage: pluginImage, SOPSPluginImage: sopsPluginImage, OutputMode: outputMode, Naming: naming(), SyncWaves: waves, ClusterNames: clusterNames(), Offline: offline, DropSecretValues: dropSecretValues}
+			notSafe := []string{}
+			for _, i := range utils.NewScanItems(k, ctx, argoCDNamespace, kustomizationList.Items, helmReleaseList.Items, exd, assessOpts, graph) {
+				if i.Readiness != utils.ReadinessSafe {
+					notSafe = append(notSafe, i.Kind+" "+i.Namespace+"/"+i.Name+" ("+utils.ReadinessTitle(i.Readiness)+"): "+strings.Join(i.Blockers, "; "))
+				}
+			}
+			for _, n := range notSafe {
+				if force {
+					log.Warn("Migrating anyway " + n)
+					continue
+				}
+				log.Error("Not safe to auto-migrate " + n)
+			}
+			if len(notSafe) > 0 && !force {
+				log.Fatal("Not everything is safe to auto-migrate, see mta scan -o wide, or use --force to migrate anyway")
+			}
+
+			// In a dry-run, record the changes in a plan instead of making them
+			var plan *utils.PlanClient
+			if dryRun {
+				plan = utils.NewPlanClient(k)
+				k = plan
+			}
+
+			// Prompt user to confirm migration, there's nothing to confirm in a dry-run
+			if dryRun {
+				log.Info("Auto-migration dry-run, nothing will be changed")
+			} else if !confirmMigrate {
 				prompt := promptui.Prompt{
 					Label:     "Are you sure you want to migrate to Argo CD and uninstall Flux?",
 					IsConfirm: true,
@@ -130,65 +256,295 @@ displays the results.
 				log.Info("Auto-migration confirmed")
 			}
 
-			// Check if Argo CD is installed/running
-			if !argo.IsArgoRunning(k, argoCDNamespace) {
+			// Check if Argo CD is installed/running, we can't tell from the manifests
+			if !offline && !argo.IsArgoRunning(k, argoCDNamespace) {
 				log.Fatal("Argo CD is not installed or running")
 			}
 
-			// Migrate Kustomizations
-			for _, kl := range kustomizationList.Items {
-				log.Info("Migrating Kustomization ", kl.Name)
-				if err := utils.MigrateKustomizationToApplicationSet(k, ctx, argoCDNamespace, kl, exd); err != nil {
+			// Keep a journal of each migration so it can be rolled back later, unless it's a dry-run
+			opts := utils.MigrationOptions{Adopt: adopt, WaitTimeout: waitTimeout, DryRun: dryRun, PluginImage: pluginImage, SOPSPluginImage: sopsPluginImage, OutputMode: outputMode, Naming: naming(), SyncWaves: waves, ClusterNames: clusterNames(), Offline: offline, DropSecretValues: dropSecretValues}
+			if !dryRun {
+				if opts.Stores, err = journalStores(cmd, k, ctx); err != nil {
 					log.Fatal(err)
 				}
 			}
 
-			// Migrate HelmReleases
+			// Migrate the Kustomizations and HelmReleases, dependencies first
+			kustomizations := map[utils.ObjectRef]kustomizev1.Kustomization{}
+			for _, kl := range kustomizationList.Items {
+				kustomizations[utils.ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: kl.Namespace, Name: kl.Name}] = kl
+			}
+			helmReleases := map[utils.ObjectRef]helmv2.HelmRelease{}
 			for _, hl := range helmReleaseList.Items {
-				log.Info("Migrating HelmRelease ", hl.Name)
-				if err := utils.MigrateHelmReleaseToApplication(k, ctx, argoCDNamespace, hl); err != nil {
+				helmReleases[utils.ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: hl.Namespace, Name: hl.Name}] = hl
+			}
+			for _, ref := range graph.Ordered(waves) {
+				switch ref.Kind {
+				case kustomizev1.KustomizationKind:
+					log.Info("Migrating Kustomization ", ref.Name)
+					if err := utils.MigrateKustomizationToApplicationSet(k, ctx, argoCDNamespace, kustomizations[ref], exd, opts); err != nil {
+						log.Fatal(err)
+					}
+				case helmv2.HelmReleaseKind:
+					log.Info("Migrating HelmRelease ", ref.Name)
+					if err := utils.MigrateHelmReleaseToApplication(k, ctx, argoCDNamespace, helmReleases[ref], opts); err != nil {
+						log.Fatal(err)
+					}
+				}
+			}
+
+			// Once we're done, we can uninstall Flux, unless the filters left some of it to migrate
+			// later
+			if filter.IsEmpty() {
+				log.Info("Uninstalling Flux")
+				if err := utils.FluxCleanUp(k, ctx, fluxlog.NopLogger{}, "flux-system", dryRun); err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				log.Info("Only what the filters picked was migrated, so Flux is left installed for the rest. Run mta scan --auto-migrate without filters to migrate what's left and uninstall it")
+			}
+
+			// Show what would have been done
+			if plan != nil {
+				if err := plan.Print(os.Stdout); err != nil {
 					log.Fatal(err)
 				}
 			}
+		} else if appOfApps.Name != "" {
+			if wavesErr != nil {
+				log.Fatal(wavesErr)
+			}
 
-			// Once we're done, we can uninstall Flux
-			log.Info("Uninstalling Flux")
-			if err := utils.FluxCleanUp(k, ctx, fluxlog.NopLogger{}, "flux-system"); err != nil {
+			// Generate the children with their sync waves, and the parent that syncs them
+			opts := utils.MigrationOptions{PluginImage: pluginImage, SOPSPluginImage: sopsPluginImage, OutputMode: outputMode, Naming: naming(), SyncWaves: waves, ClusterNames: clusterNames(), Offline: offline, DropSecretValues: dropSecretValues}
+			migration, err := utils.NewAppOfAppsMigration(k, ctx, argoCDNamespace, kustomizationList.Items, helmReleaseList.Items, exd, opts, appOfApps)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := writeAppOfApps(k.Scheme(), appOfApps.Path, migration, utils.NewEnvsubstPlugin(argoCDNamespace, pluginImage), utils.NewSOPSPlugin(argoCDNamespace, sopsPluginImage)); err != nil {
 				log.Fatal(err)
 			}
+
 		} else {
+			if wavesErr != nil {
+				log.Warn(wavesErr)
+			}
+
+			// The sync wave and dependencies of an object, with the ones that are missing or in
+			// another namespace pointed out
+			dependencies := func(ref utils.ObjectRef) (string, string) {
+				deps := []string{}
+				for _, d := range graph.DependsOn(ref) {
+					deps = append(deps, d.String())
+				}
+				wave := ""
+				if wavesErr == nil {
+					wave = strconv.Itoa(waves[ref])
+				}
+				return wave, strings.Join(deps, ", ")
+			}
 
-			// Set up table
-			t := table.NewWriter()
-			t.SetOutputMirror(os.Stdout)
-			t.AppendHeader(table.Row{"Kind", "Name", "Namespace", "Status"})
+			// Judge how ready each object is to be migrated, for the inventory and the table
+			opts := utils.MigrationOptions{PluginImage: pluginImage, SOPSPluginImage: sopsPluginImage, OutputMode: outputMode, Naming: naming(), SyncWaves: waves, ClusterNames: clusterNames(), Offline: offline, DropSecretValues: dropSecretValues}
+			items := utils.NewScanItems(k, ctx, argoCDNamespace, kustomizationList.Items, helmReleaseList.Items, exd, opts, graph)
 
-			// Add all Helm Releases to the table
-			for _, hr := range helmReleaseList.Items {
-				t.AppendRow(table.Row{hr.Kind, hr.Name, hr.Namespace, utils.TruncMsg(hr.Status.Conditions[0].Message)})
+			// Write the inventory with the source and blockers of each object if it's asked for
+			if output != "" {
+				if output != utils.ScanOutputWide {
+					if err := utils.WriteScanItems(os.Stdout, output, items); err != nil {
+						log.Fatal(err)
+					}
+					return
+				}
+
+				// The wide table has everything, with the full status messages, by readiness
+				t := table.NewWriter()
+				t.SetOutputMirror(os.Stdout)
+				t.AppendHeader(table.Row{"Kind", "Name", "Namespace", "Source", "URL", "Revision", "Target Namespace", "Suspended", "State", "Ready", "Status", "Last Applied", "Wave", "Depends On", "Readiness", "Score", "Blockers"})
+				for n, i := range items {
+					if n > 0 && items[n-1].Readiness != i.Readiness {
+						t.AppendSeparator()
+					}
+					t.AppendRow(table.Row{i.Kind, i.Name, i.Namespace, i.SourceKind + "/" + i.SourceNamespace + "/" + i.SourceName, i.SourceURL, i.SourceRevision, i.TargetNamespace, i.Suspended, i.Status, i.Ready, i.ReadyMessage, i.LastAppliedRevision, i.Wave, strings.Join(i.DependsOn, ", "), utils.ReadinessTitle(i.Readiness), i.Score, strings.Join(i.Blockers, "\n")})
+				}
+				t.SetStyle(table.StyleLight)
+				t.Render()
+				return
 			}
 
-			// Add a separotor to the table
-			t.AppendSeparator()
+			// Show the status of everything
+			renderStatusTable(os.Stdout, helmReleaseList.Items, kustomizationList.Items, automationList.Items, dependencies)
 
-			// Add all Kustomizations to the table
-			for _, k := range kustomizationList.Items {
-				t.AppendRow(table.Row{k.Kind, k.Name, k.Namespace, utils.TruncMsg(k.Status.Conditions[0].Message)})
+			// Show how ready they are to be migrated, by group
+			r := table.NewWriter()
+			r.SetOutputMirror(os.Stdout)
+			r.AppendHeader(table.Row{"Readiness", "Score", "Kind", "Name", "Namespace", "Blockers"})
+			for n, i := range items {
+				if n > 0 && items[n-1].Readiness != i.Readiness {
+					r.AppendSeparator()
+				}
+				blockers := []string{}
+				for _, b := range i.Blockers {
+					blockers = append(blockers, utils.TruncMsg(b))
+				}
+				r.AppendRow(table.Row{utils.ReadinessTitle(i.Readiness), i.Score, i.Kind, i.Name, i.Namespace, strings.Join(blockers, "\n")})
 			}
+			r.SetStyle(table.StyleLight)
+			r.Render()
 
-			//Render the table to the console
-			t.SetStyle(table.StyleLight)
-			t.Render()
+			// Tell which Applications get Image Updater annotations
+			for i := range automationList.Items {
+				a := &automationList.Items[i]
+				updated := []string{}
+				for j := range kustomizationList.Items {
+					if utils.Automates(a, &kustomizationList.Items[j]) {
+						updated = append(updated, kustomizationList.Items[j].Namespace+"/"+kustomizationList.Items[j].Name)
+					}
+				}
+				if a.Spec.Suspend || len(updated) == 0 {
+					log.Warn("ImageUpdateAutomation " + a.Namespace + "/" + a.Name + " is suspended or updates no Kustomization, it isn't carried over")
+					continue
+				}
+				log.Info("ImageUpdateAutomation " + a.Namespace + "/" + a.Name + " updates the images of Kustomizations " + strings.Join(updated, ", ") + ", they're migrated with Argo CD Image Updater annotations")
+			}
 
 		}
 
 	},
 }
 
+// renderStatusTable writes the table of the HelmReleases, Kustomizations and image automations
+// with their status, sync wave and dependencies. The status is the state FluxStatus gives, like in
+// the other outputs: an object Flux hasn't reconciled yet, like one read from manifests, is Unknown.
+func renderStatusTable(w io.Writer, helmReleases []helmv2.HelmRelease, kustomizations []kustomizev1.Kustomization, automations []autov1beta1.ImageUpdateAutomation, dependencies func(utils.ObjectRef) (string, string)) {
+	// Set up table
+	t := table.NewWriter()
+	t.SetOutputMirror(w)
+	t.AppendHeader(table.Row{"Kind", "Name", "Namespace", "Status", "Wave", "Depends On"})
+
+	// Add all Helm Releases to the table
+	for _, hr := range helmReleases {
+		wave, deps := dependencies(utils.ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: hr.Namespace, Name: hr.Name})
+		t.AppendRow(table.Row{helmv2.HelmReleaseKind, hr.Name, hr.Namespace, utils.FluxStatusMsg(hr.Status.Conditions, hr.Spec.Suspend), wave, deps})
+	}
+
+	// Add a separotor to the table
+	t.AppendSeparator()
+
+	// Add all Kustomizations to the table
+	for _, k := range kustomizations {
+		wave, deps := dependencies(utils.ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: k.Namespace, Name: k.Name})
+		t.AppendRow(table.Row{kustomizev1.KustomizationKind, k.Name, k.Namespace, utils.FluxStatusMsg(k.Status.Conditions, k.Spec.Suspend), wave, deps})
+	}
+
+	// Add the image automations, Argo CD Image Updater takes them over
+	if len(automations) > 0 {
+		t.AppendSeparator()
+	}
+	for _, a := range automations {
+		t.AppendRow(table.Row{autov1beta1.ImageUpdateAutomationKind, a.Name, a.Namespace, utils.FluxStatusMsg(a.Status.Conditions, a.Spec.Suspend), "", ""})
+	}
+
+	//Render the table to the console
+	t.SetStyle(table.StyleLight)
+	t.Render()
+}
+
+// writeAppOfApps writes the children of an app-of-apps to dir, one file each, and prints the
+// repository Secrets and the parent Application to stdout. The Secrets don't belong in the
+// repository.
+func writeAppOfApps(scheme *runtime.Scheme, dir string, m utils.AppOfAppsMigration, plugin argo.ConfigManagementPlugin, sopsPlugin argo.ConfigManagementPlugin) error {
+	printr := printers.NewTypeSetter(scheme).ToPrinter(&printers.YAMLPrinter{})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, child := range m.Children {
+		kind := child.GetObjectKind().GroupVersionKind().Kind
+		f, err := os.Create(filepath.Join(dir, strings.ToLower(kind)+"-"+child.GetName()+".yaml"))
+		if err != nil {
+			return err
+		}
+		err = printr.PrintObj(child, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Wrote " + strconv.Itoa(len(m.Children)) + " Applications and ApplicationSets to " + dir + ", commit them to " + m.Parent.Spec.Source.RepoURL + " before applying the parent Application")
+
+	for _, s := range m.Secrets {
+		if err := printr.PrintObj(s, os.Stdout); err != nil {
+			return err
+		}
+	}
+	for repoURL, creds := range m.Repositories {
+		utils.WarnRepositoryConfig(repoURL, creds)
+	}
+	utils.WarnImageUpdaterConfig(m.ImageUpdaterConfig)
+	utils.WarnNotifications(m.Notifications)
+
+	// Print the plugin's ConfigMap, the sidecar is patched into the repo server
+	if m.Plugin {
+		cm, err := argo.GenPluginConfigMap(plugin)
+		if err != nil {
+			return err
+		}
+		if err := printr.PrintObj(cm, os.Stdout); err != nil {
+			return err
+		}
+		if err := utils.WarnPlugin(plugin); err != nil {
+			return err
+		}
+	}
+
+	// Print the keys the children are decrypted with, and the plugin that decrypts
+	if m.Decryption != nil {
+		if len(m.Decryption.Keys) > 0 {
+			if err := printr.PrintObj(utils.GenSOPSKeysSecret(sopsPlugin.Namespace, m.Decryption.Keys), os.Stdout); err != nil {
+				return err
+			}
+		}
+		cm, err := argo.GenPluginConfigMap(sopsPlugin)
+		if err != nil {
+			return err
+		}
+		if err := printr.PrintObj(cm, os.Stdout); err != nil {
+			return err
+		}
+		if err := utils.WarnPlugin(sopsPlugin); err != nil {
+			return err
+		}
+	}
+
+	// Argo CD only waits for a wave of Applications to be healthy with the Application health check
+	log.Info("The parent Application only waits for each sync wave to be healthy with the health check for Applications in argocd-cm, see https://argo-cd.readthedocs.io/en/stable/operator-manual/health/#argocd-app")
+
+	return printr.PrintObj(m.Parent, os.Stdout)
+}
+
 func init() {
 	rootCmd.AddCommand(scanCmd)
 
 	scanCmd.Flags().Bool("auto-migrate", false, "Migrate HelmReleases and Kustomizations to Argo CD and uninstalls Flux")
 	scanCmd.Flags().Bool("confirm", false, "Confirm migraton to Argo CD and uninstalls Flux")
+	scanCmd.Flags().Bool("dry-run", false, "Print the plan of changes --auto-migrate would make, checked with a server-side dry-run, without making them")
+	scanCmd.Flags().Bool("force", false, "Auto-migrate the HelmReleases and Kustomizations that need manual work or are unsupported too, instead of refusing to")
+	scanCmd.Flags().Bool("adopt", false, "Hand the live resources over to Argo CD without Flux removing them, and only delete the Flux objects once the Applications are Synced and Healthy")
+	scanCmd.Flags().Duration("wait-timeout", 10*time.Minute, "How long to wait for the Applications to be Synced and Healthy before deleting the Flux objects, 0 skips the wait")
+	scanCmd.Flags().String("output-mode", utils.OutputModeApplicationSet, "Migrate each Kustomization to an \"applicationset\" with an Application for each directory under its path, or to a single \"application\" with the same path. flux-system is always migrated to an ApplicationSet")
+	scanCmd.Flags().String("plugin-image", utils.DefaultPluginImage, "Image with the kubectl and flux CLIs, for the plugin that renders Kustomizations with postBuild substitutions")
+	scanCmd.Flags().String("sops-plugin-image", "", "Image with sh and the sops, gpg, kubectl and flux CLIs, for the plugin that decrypts Kustomizations with spec.decryption. Required to migrate them")
+	scanCmd.Flags().String("app-of-apps", "", "Generate a parent Application with this name that syncs the migrated Applications in the order of their dependsOn, instead of showing the table")
+	scanCmd.Flags().String("app-of-apps-path", "argocd-apps", "Directory the Applications synced by --app-of-apps are written to, relative to the root of a checkout of the repository. Commit them to the repository before applying the parent")
+	scanCmd.Flags().String("app-of-apps-repo", "", "Repository the Applications synced by --app-of-apps are committed to (default is the repository of the flux-system Kustomization)")
+	scanCmd.Flags().String("app-of-apps-revision", "", "Revision of --app-of-apps-repo the parent Application syncs (default is HEAD)")
+	scanCmd.Flags().StringP("output", "o", "", "Output format of the inventory, \"wide\" for a table with the source and blockers of each object, or \"json\", \"yaml\" or \"csv\" (default is the table)")
+	scanCmd.Flags().BoolP("all-namespaces", "A", false, "Scan every namespace, which is the default unless --namespace is given")
+	scanCmd.Flags().StringP("selector", "l", "", "Only scan and migrate the objects with these labels, like -l team=payments,env!=prod")
+	scanCmd.Flags().String("field-selector", "", "Only scan and migrate the objects with these fields, like --field-selector spec.suspend=false. Supports metadata.name, metadata.namespace, spec.suspend, spec.sourceRef.kind, spec.sourceRef.name and spec.targetNamespace")
+	scanCmd.Flags().StringSlice("include", []string{}, "Only scan and migrate the objects whose name, or namespace/name for globs with a slash, matches one of these globs. Can be single or comma separated")
+	scanCmd.Flags().StringSlice("exclude", []string{}, "Don't scan or migrate the objects whose name, or namespace/name for globs with a slash, matches one of these globs. Can be single or comma separated")
+	scanCmd.Flags().StringSlice("kind", []string{}, "Only scan and migrate these kinds: Kustomization, HelmRelease or ImageUpdateAutomation. Can be single or comma separated")
 	scanCmd.Flags().StringSlice("exclude-dirs", []string{}, "Additional Directories (besides flux-system) to exclude from the GitDir generator. Can be single or comma separated")
 }
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/akuity/mta/pkg/utils"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRenderStatusTable(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kustomizev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+
+	noDependencies := func(utils.ObjectRef) (string, string) { return "0", "" }
+
+	tests := []struct {
+		name           string
+		status         map[string]interface{}
+		suspend        bool
+		expectedStatus string
+	}{
+		{
+			name:           "when it has no status",
+			expectedStatus: "Unknown: Not reconciled yet",
+		},
+		{
+			name:           "when it has no conditions",
+			status:         map[string]interface{}{"observedGeneration": int64(1), "conditions": []interface{}{}},
+			expectedStatus: "Unknown: Not reconciled yet",
+		},
+		{
+			name: "when Ready comes after another condition",
+			status: map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": "Released", "status": "True", "reason": "InstallSucceeded", "message": "Helm install succeeded", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+				map[string]interface{}{"type": "Ready", "status": "True", "reason": "ReconciliationSucceeded", "message": "Release reconciliation succeeded", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+			}},
+			expectedStatus: "Ready: Release reconciliation succeeded",
+		},
+		{
+			name: "when it's stalled",
+			status: map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "False", "reason": "ArtifactFailed", "message": "chart pull error", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+				map[string]interface{}{"type": "Stalled", "status": "True", "reason": "ArtifactFailed", "message": "chart not found", "lastTransitionTime": "2024-01-01T00:00:00Z"},
+			}},
+			expectedStatus: "Stalled: chart not found",
+		},
+		{
+			name:           "when it's suspended",
+			suspend:        true,
+			expectedStatus: "Suspended: Reconciliation is suspended",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := &unstructured.Unstructured{}
+			hr.SetAPIVersion(helmv2.GroupVersion.String())
+			hr.SetKind(helmv2.HelmReleaseKind)
+			hr.SetNamespace("flux-system")
+			hr.SetName("podinfo")
+			unstructured.SetNestedField(hr.Object, tt.suspend, "spec", "suspend")
+			if tt.status != nil {
+				unstructured.SetNestedMap(hr.Object, tt.status, "status")
+			}
+			c := utils.NewOfflineClient(scheme, hr)
+
+			helmReleaseList := &helmv2.HelmReleaseList{}
+			if err := c.List(context.TODO(), helmReleaseList); err != nil {
+				t.Fatal(err)
+			}
+
+			out := &bytes.Buffer{}
+			renderStatusTable(out, helmReleaseList.Items, nil, nil, noDependencies)
+			assert.Equal(t, strings.Contains(out.String(), tt.expectedStatus), true, out.String())
+		})
+	}
+}
@@ -35,14 +35,21 @@ require (
 	github.com/argoproj/argo-cd/v2 v2.7.2
 	github.com/fluxcd/flux2 v0.41.2
 	github.com/fluxcd/helm-controller/api v0.33.0
+	github.com/fluxcd/image-automation-controller/api v0.31.0
+	github.com/fluxcd/image-reflector-controller/api v0.26.1
 	github.com/fluxcd/kustomize-controller/api v1.1.1
+	github.com/fluxcd/notification-controller/api v0.33.0
+	github.com/fluxcd/pkg/apis/kustomize v1.2.0
+	github.com/fluxcd/pkg/apis/meta v1.2.0
 	github.com/fluxcd/source-controller/api v1.1.2
 	github.com/jedib0t/go-pretty/v6 v6.4.2
+	github.com/magiconair/properties v1.8.6
 	github.com/manifoldco/promptui v0.9.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/spf13/cobra v1.7.0
 	github.com/spf13/viper v1.14.0
 	k8s.io/api v0.28.3
+	k8s.io/apiextensions-apiserver v0.28.4
 	k8s.io/apimachinery v0.28.4
 	k8s.io/cli-runtime v0.26.2
 	k8s.io/client-go v0.28.3
@@ -57,9 +64,6 @@ require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/cloudflare/circl v1.3.2 // indirect
 	github.com/cyphar/filepath-securejoin v0.2.3 // indirect
-	github.com/fluxcd/image-automation-controller/api v0.31.0 // indirect
-	github.com/fluxcd/image-reflector-controller/api v0.26.1 // indirect
-	github.com/fluxcd/notification-controller/api v0.33.0 // indirect
 	github.com/go-redis/cache/v9 v9.0.0 // indirect
 	github.com/google/go-github/v45 v45.2.0 // indirect
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
@@ -96,8 +100,6 @@ require (
 	github.com/exponent-io/jsonpath v0.0.0-20210407135951-1de76d718b3f // indirect
 	github.com/fatih/camelcase v1.0.0 // indirect
 	github.com/fluxcd/pkg/apis/acl v0.1.0 // indirect
-	github.com/fluxcd/pkg/apis/kustomize v1.2.0 // indirect
-	github.com/fluxcd/pkg/apis/meta v1.2.0 // indirect
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/fvbommel/sortorder v1.0.1 // indirect
 	github.com/ghodss/yaml v1.0.0 // indirect
@@ -132,7 +134,6 @@ require (
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
 	github.com/klauspost/compress v1.15.11 // indirect
 	github.com/liggitt/tabwriter v0.0.0-20181228230101-89fcab3d43de // indirect
-	github.com/magiconair/properties v1.8.6 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
@@ -178,7 +179,6 @@ require (
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/apiextensions-apiserver v0.28.4 // indirect
 	k8s.io/apiserver v0.28.3 // indirect
 	k8s.io/component-base v0.28.3 // indirect
 	k8s.io/component-helpers v0.24.2 // indirect
@@ -2,6 +2,8 @@ package argo
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	apiv1 "k8s.io/api/core/v1"
@@ -13,6 +15,7 @@ import (
 // ArgoCdGitApplicationSet is a struct that holds the ArgoCD Git ApplicationSet
 // TODO: Make a Generic "ApplicationSet" struct that can be used generically (i.e. specify your generator)
 type GitDirApplicationSet struct {
+	Name                    string
 	Namespace               string
 	GitRepoURL              string
 	GitRepoRevision         string
@@ -25,8 +28,32 @@ type GitDirApplicationSet struct {
 	AppPath                 string
 	AppDestinationServer    string
 	AppDestinationNamespace string
-	SSHPrivateKey           string
+	AppRendering            Rendering
+	Credentials             RepositoryCredentials
 	GitOpsRepo              string
+	// SecretName is the name of the repository Secret for GitOpsRepo
+	SecretName string
+}
+
+// Rendering is how the manifests of an Application are rendered: by the config management plugin
+// PluginName, with the variables in PluginEnv, or else with the Kustomize options, if any
+type Rendering struct {
+	PluginName string
+	PluginEnv  map[string]string
+	Kustomize  *v1alpha1.ApplicationSourceKustomize
+}
+
+// AppSpec is what an Application is generated from, on its own or as the template of an
+// ApplicationSet
+type AppSpec struct {
+	Project              string
+	RepoURL              string
+	TargetRevision       string
+	Path                 string
+	DestinationServer    string
+	DestinationNamespace string
+	Prune                bool
+	Rendering            Rendering
 }
 
 // ArgoCdApplication is a struct that holds the ArgoCD Application
@@ -38,9 +65,13 @@ type ArgoCdHelmApplication struct {
 	Project              string
 	HelmChart            string
 	HelmRepo             string
-	HelmTargetRevision   string
-	HelmValues           string
-	HelmCreateNamespace  string
+	// HelmChartPath is the path of the chart when HelmRepo is a Git repo, HelmChart isn't used then
+	HelmChartPath      string
+	HelmTargetRevision string
+	// HelmReleaseName is the name Helm installs the chart as, so it's the release Flux installed
+	HelmReleaseName     string
+	HelmValues          string
+	HelmCreateNamespace string
 }
 
 // GenArgoCdApplication generates an ArgoCD Application
@@ -61,10 +92,12 @@ func GenArgoCdHelmApplication(app ArgoCdHelmApplication) (*v1alpha1.Application,
 		Project: app.Project,
 		Source: &v1alpha1.ApplicationSource{
 			Chart:          app.HelmChart,
-			RepoURL:        app.HelmRepo,
+			Path:           app.HelmChartPath,
+			RepoURL:        TrimOCIScheme(app.HelmRepo),
 			TargetRevision: app.HelmTargetRevision,
 			Helm: &v1alpha1.ApplicationSourceHelm{
-				Values: app.HelmValues,
+				ReleaseName: app.HelmReleaseName,
+				Values:      app.HelmValues,
 			},
 		},
 		Destination: v1alpha1.ApplicationDestination{
@@ -83,13 +116,7 @@ func GenArgoCdHelmApplication(app ArgoCdHelmApplication) (*v1alpha1.Application,
 
 // GenGitDirApplicationSet generates an ArgoCD Git Directory ApplicationSet that
 func GenGitDirAppSet(appSet GitDirApplicationSet) (*v1alpha1.ApplicationSet, error) {
-	// Some Defaults
-	// TODO: Make these configurable
 	var TargetNamespace string
-	asName := "mta-migration"
-	asSyncOptions := v1alpha1.SyncOptions{"CreateNamespace=true", "Validate=false"}
-	asSPAutomated := v1alpha1.SyncPolicyAutomated{Prune: true, SelfHeal: true}
-	asRetry := v1alpha1.RetryStrategy{Limit: 5, Backoff: &v1alpha1.Backoff{Duration: "5s", Factor: func(i int64) *int64 { return &i }(2), MaxDuration: "3m"}}
 
 	// Set the Target Namespace to "default" if it's not set
 	if appSet.AppDestinationNamespace == "" {
@@ -104,7 +131,7 @@ func GenGitDirAppSet(appSet GitDirApplicationSet) (*v1alpha1.ApplicationSet, err
 	// Set GVK scheme
 	as.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("ApplicationSet"))
 
-	as.SetName(asName)
+	as.SetName(appSet.Name)
 	as.SetNamespace(appSet.Namespace)
 	//
 	as.Spec.Generators = []v1alpha1.ApplicationSetGenerator{
@@ -128,34 +155,93 @@ func GenGitDirAppSet(appSet GitDirApplicationSet) (*v1alpha1.ApplicationSet, err
 	// Reset the Git Template spec because we aren't using it
 	as.Spec.Generators[0].Git.Template.Reset()
 
+	// The templates are Go templates, so names can be made valid with the Sprig functions
+	as.Spec.GoTemplate = true
+
 	// Set up the Application template Spec
 	as.Spec.Template = v1alpha1.ApplicationSetTemplate{
 		ApplicationSetTemplateMeta: v1alpha1.ApplicationSetTemplateMeta{
 			Name: appSet.AppName,
 		},
-		Spec: v1alpha1.ApplicationSpec{
-			Project: appSet.AppProject,
-			SyncPolicy: &v1alpha1.SyncPolicy{
-				SyncOptions: asSyncOptions,
-				Automated:   &asSPAutomated,
-				Retry:       &asRetry,
-			},
-			Source: &v1alpha1.ApplicationSource{
-				RepoURL:        appSet.AppRepoURL,
-				TargetRevision: appSet.AppTargetRevision,
-				Path:           appSet.AppPath,
-			},
-			Destination: v1alpha1.ApplicationDestination{
-				Server:    appSet.AppDestinationServer,
-				Namespace: TargetNamespace,
-			},
-		},
+		Spec: GenApplicationSpec(AppSpec{
+			Project:              appSet.AppProject,
+			RepoURL:              appSet.AppRepoURL,
+			TargetRevision:       appSet.AppTargetRevision,
+			Path:                 appSet.AppPath,
+			DestinationServer:    appSet.AppDestinationServer,
+			DestinationNamespace: TargetNamespace,
+			Prune:                true,
+			Rendering:            appSet.AppRendering,
+		}),
+	}
+
+	// The plugin's variables are passed as they are, not executed as templates
+	if p := as.Spec.Template.Spec.Source.Plugin; p != nil {
+		for _, e := range p.Env {
+			e.Value = strings.ReplaceAll(e.Value, "{{", `{{"{{"}}`)
+		}
 	}
 
 	// Return ApplicationSet
 	return as, nil
 }
 
+// GenApplicationSpec generates the spec of an Application, shared by Applications and the
+// templates of ApplicationSets
+func GenApplicationSpec(app AppSpec) v1alpha1.ApplicationSpec {
+	// Some Defaults
+	// TODO: Make these configurable
+	syncOptions := v1alpha1.SyncOptions{"CreateNamespace=true", "Validate=false"}
+	automated := v1alpha1.SyncPolicyAutomated{Prune: app.Prune, SelfHeal: true}
+	retry := v1alpha1.RetryStrategy{Limit: 5, Backoff: &v1alpha1.Backoff{Duration: "5s", Factor: func(i int64) *int64 { return &i }(2), MaxDuration: "3m"}}
+
+	spec := v1alpha1.ApplicationSpec{
+		Project: app.Project,
+		SyncPolicy: &v1alpha1.SyncPolicy{
+			SyncOptions: syncOptions,
+			Automated:   &automated,
+			Retry:       &retry,
+		},
+		Source: &v1alpha1.ApplicationSource{
+			RepoURL:        app.RepoURL,
+			TargetRevision: app.TargetRevision,
+			Path:           app.Path,
+			Kustomize:      app.Rendering.Kustomize,
+		},
+		Destination: v1alpha1.ApplicationDestination{
+			Server:    app.DestinationServer,
+			Namespace: app.DestinationNamespace,
+		},
+	}
+
+	// Render with a plugin instead, the variables are passed in a stable order
+	if app.Rendering.PluginName != "" {
+		spec.Source.Kustomize = nil
+		spec.Source.Plugin = &v1alpha1.ApplicationSourcePlugin{Name: app.Rendering.PluginName}
+		names := make([]string, 0, len(app.Rendering.PluginEnv))
+		for n := range app.Rendering.PluginEnv {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			spec.Source.Plugin.Env = append(spec.Source.Plugin.Env, &v1alpha1.EnvEntry{Name: n, Value: app.Rendering.PluginEnv[n]})
+		}
+	}
+
+	return spec
+}
+
+// GenApplication generates an Argo CD Application
+func GenApplication(name string, namespace string, app AppSpec) *v1alpha1.Application {
+	a := &v1alpha1.Application{}
+	a.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("Application"))
+	a.SetName(name)
+	a.SetNamespace(namespace)
+	a.Spec = GenApplicationSpec(app)
+
+	return a
+}
+
 // IsArgoRunning checks if ArgoCD is running. Best effort as it just checks to see if the namespace exists
 func IsArgoRunning(client client.Client, ns string) bool {
 	// If we can't fine the namespace, return false
@@ -0,0 +1,27 @@
+package argo
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestGenGitDirAppSet(t *testing.T) {
+	as, err := GenGitDirAppSet(GitDirApplicationSet{
+		Name:          "flux-system-apps-ks",
+		Namespace:     "argocd",
+		GitIncludeDir: "apps/*",
+		AppName:       "{{.path.basename}}",
+		AppPath:       "{{.path.path}}",
+		AppRendering:  Rendering{PluginName: "flux-envsubst", PluginEnv: map[string]string{"GREETING": "{{hello}}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, as.Spec.GoTemplate, true)
+	assert.Equal(t, as.Spec.Template.Name, "{{.path.basename}}")
+	assert.Equal(t, as.Spec.Template.Spec.Source.Path, "{{.path.path}}")
+
+	// Variables come out of the template as they went in
+	assert.Equal(t, as.Spec.Template.Spec.Source.Plugin.Env[0].Value, `{{"{{"}}hello}}`)
+}
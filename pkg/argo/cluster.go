@@ -0,0 +1,62 @@
+package argo
+
+import (
+	"encoding/json"
+
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// InClusterServer is the cluster Argo CD runs in
+	InClusterServer = "https://kubernetes.default.svc"
+	// secretTypeLabel tells Argo CD what a Secret configures
+	secretTypeLabel = "argocd.argoproj.io/secret-type"
+)
+
+// GenClusterSecret generates the Secret that registers a cluster with Argo CD
+func GenClusterSecret(name string, namespace string, clusterName string, server string, config v1alpha1.ClusterConfig) (*apiv1.Secret, error) {
+	c, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{secretTypeLabel: "cluster"},
+		},
+		Type: apiv1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"name":   clusterName,
+			"server": server,
+			"config": string(c),
+		},
+	}
+	s.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("Secret"))
+
+	return s, nil
+}
+
+// SetDestination points an Application, or the Applications an ApplicationSet generates, at a
+// cluster, by its server or, if it's set, by its name in Argo CD
+func SetDestination(obj client.Object, server string, name string) {
+	var d *v1alpha1.ApplicationDestination
+	switch a := obj.(type) {
+	case *v1alpha1.Application:
+		d = &a.Spec.Destination
+	case *v1alpha1.ApplicationSet:
+		d = &a.Spec.Template.Spec.Destination
+	default:
+		return
+	}
+
+	// Argo CD wants one or the other
+	d.Server, d.Name = server, ""
+	if name != "" {
+		d.Server, d.Name = "", name
+	}
+}
@@ -0,0 +1,115 @@
+package argo
+
+import (
+	"sort"
+	"strings"
+
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ImageUpdaterConfigMap is the ConfigMap Argo CD Image Updater is configured with
+	ImageUpdaterConfigMap = "argocd-image-updater-config"
+	// imageUpdaterAnnotation prefixes the annotations Image Updater reads from Applications
+	imageUpdaterAnnotation = "argocd-image-updater.argoproj.io/"
+)
+
+// ImageUpdate is an image Argo CD Image Updater keeps up to date in an Application
+type ImageUpdate struct {
+	// Alias names the image in the other annotations, it's alphanumeric
+	Alias string
+	Image string
+	// Constraint is the semver range for the semver strategy
+	Constraint string
+	// Strategy is how the newest tag is picked: semver, latest, name or digest
+	Strategy string
+	// AllowTags is a regexp: match of the tags that can be picked
+	AllowTags string
+	// PullSecret is where the registry credentials are, as pullsecret:<namespace>/<name>
+	PullSecret string
+}
+
+// ImageUpdater is how Argo CD Image Updater updates the images of an Application and writes the
+// new tags back to Git
+type ImageUpdater struct {
+	Images []ImageUpdate
+	// GitBranch is the branch to commit to, or <base>:<target> to push to another branch
+	GitBranch string
+	// WriteBackTarget is where the new tags are written, like kustomization
+	WriteBackTarget string
+}
+
+// Annotations returns the annotations of an Application that configure Image Updater
+func (u ImageUpdater) Annotations() map[string]string {
+	images := []string{}
+	annotations := map[string]string{
+		imageUpdaterAnnotation + "write-back-method": "git",
+	}
+	for _, i := range u.Images {
+		image := i.Alias + "=" + i.Image
+		if i.Constraint != "" {
+			image += ":" + i.Constraint
+		}
+		images = append(images, image)
+
+		annotations[imageUpdaterAnnotation+i.Alias+".update-strategy"] = i.Strategy
+		if i.AllowTags != "" {
+			annotations[imageUpdaterAnnotation+i.Alias+".allow-tags"] = i.AllowTags
+		}
+		if i.PullSecret != "" {
+			annotations[imageUpdaterAnnotation+i.Alias+".pull-secret"] = i.PullSecret
+		}
+	}
+	sort.Strings(images)
+	annotations[imageUpdaterAnnotation+"image-list"] = strings.Join(images, ", ")
+	if u.GitBranch != "" {
+		annotations[imageUpdaterAnnotation+"git-branch"] = u.GitBranch
+	}
+	if u.WriteBackTarget != "" {
+		annotations[imageUpdaterAnnotation+"write-back-target"] = u.WriteBackTarget
+	}
+
+	return annotations
+}
+
+// SetImageUpdater annotates an Application, or the Applications an ApplicationSet generates, so
+// Image Updater updates their images
+func SetImageUpdater(obj client.Object, u ImageUpdater) {
+	setAnnotations(obj, u.Annotations())
+}
+
+// GenImageUpdaterConfigData returns the settings of the ImageUpdaterConfigMap Image Updater commits
+// with, only the ones that are set
+func GenImageUpdaterConfigData(user string, email string, messageTemplate string) map[string]string {
+	data := map[string]string{}
+	if user != "" {
+		data["git.user"] = user
+	}
+	if email != "" {
+		data["git.email"] = email
+	}
+	if messageTemplate != "" {
+		data["git.commit-message-template"] = messageTemplate
+	}
+	return data
+}
+
+// setAnnotations adds annotations to an Application, or to an ApplicationSet and the Applications
+// it generates
+func setAnnotations(obj client.Object, added map[string]string) {
+	merge := func(annotations map[string]string) map[string]string {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for k, v := range added {
+			annotations[k] = v
+		}
+		return annotations
+	}
+
+	obj.SetAnnotations(merge(obj.GetAnnotations()))
+	if as, ok := obj.(*v1alpha1.ApplicationSet); ok {
+		as.Spec.Template.Annotations = merge(as.Spec.Template.Annotations)
+	}
+}
@@ -0,0 +1,205 @@
+package argo
+
+import (
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	yaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// NotificationsConfigMap and NotificationsSecret configure Argo CD Notifications
+	NotificationsConfigMap = "argocd-notifications-cm"
+	NotificationsSecret    = "argocd-notifications-secret"
+	// subscribeAnnotation prefixes the annotations that subscribe to the triggers of an Application
+	subscribeAnnotation = "notifications.argoproj.io/subscribe."
+)
+
+// The triggers alerts are sent on, like the ones in the Argo CD Notifications catalog
+const (
+	TriggerSyncFailed        = "on-sync-failed"
+	TriggerHealthDegraded    = "on-health-degraded"
+	TriggerSyncStatusUnknown = "on-sync-status-unknown"
+	TriggerDeployed          = "on-deployed"
+)
+
+// Webhook formats, what the body of a webhook looks like
+const (
+	// WebhookGeneric posts an event like the Flux generic provider does
+	WebhookGeneric = "generic"
+	// WebhookSlack posts to a Slack incoming webhook
+	WebhookSlack = "slack"
+	// WebhookGitHub sets a commit status with the GitHub API
+	WebhookGitHub = "github"
+)
+
+// notificationTrigger is when a trigger fires and what it sends
+type notificationTrigger struct {
+	When    string
+	OncePer string
+	// Template is the name of the template the trigger sends, with Message
+	Template string
+	Message  string
+	// Severity and Reason are sent to generic webhooks, State is the GitHub commit status
+	Severity string
+	Reason   string
+	State    string
+}
+
+// notificationTriggers are the triggers Flux alerts are migrated to
+var notificationTriggers = map[string]notificationTrigger{
+	TriggerSyncFailed: {
+		When:     "app.status.operationState != nil and app.status.operationState.phase in ['Error', 'Failed']",
+		Template: "app-sync-failed",
+		Message:  "Application {{.app.metadata.name}} sync failed at {{.app.status.operationState.finishedAt}}: {{.app.status.operationState.message}}",
+		Severity: "error",
+		Reason:   "SyncFailed",
+		State:    "failure",
+	},
+	TriggerHealthDegraded: {
+		When:     "app.status.health.status == 'Degraded'",
+		Template: "app-health-degraded",
+		Message:  "Application {{.app.metadata.name}} has degraded.",
+		Severity: "error",
+		Reason:   "HealthDegraded",
+		State:    "failure",
+	},
+	TriggerSyncStatusUnknown: {
+		When:     "app.status.sync.status == 'Unknown'",
+		Template: "app-sync-status-unknown",
+		Message:  "Application {{.app.metadata.name}} sync status is 'Unknown'.",
+		Severity: "error",
+		Reason:   "SyncStatusUnknown",
+		State:    "error",
+	},
+	TriggerDeployed: {
+		When:     "app.status.operationState != nil and app.status.operationState.phase in ['Succeeded'] and app.status.health.status == 'Healthy'",
+		OncePer:  "app.status.operationState.syncResult.revision",
+		Template: "app-deployed",
+		Message:  "Application {{.app.metadata.name}} is now running revision {{.app.status.sync.revision}}.",
+		Severity: "info",
+		Reason:   "Deployed",
+		State:    "success",
+	},
+}
+
+// NotificationService is a service Argo CD Notifications sends to
+type NotificationService struct {
+	// Type is slack, teams or webhook, and Name what the service is subscribed to as
+	Type string
+	Name string
+	// Config is the configuration of the service, secrets are $key references to the
+	// NotificationsSecret
+	Config map[string]interface{}
+	// WebhookFormat is the body webhooks post, and WebhookPath the path they post to
+	WebhookFormat string
+	WebhookPath   string
+}
+
+// Subscription subscribes a recipient of a service to a trigger of an Application. Webhooks don't
+// have recipients.
+type Subscription struct {
+	Trigger   string
+	Service   string
+	Recipient string
+}
+
+// GenNotificationsConfigData returns the settings of the NotificationsConfigMap for services: the
+// services, the triggers and the templates, with a body for each webhook
+func GenNotificationsConfigData(services []NotificationService) (map[string]string, error) {
+	data := map[string]string{}
+	for _, s := range services {
+		config, err := yaml.Marshal(s.Config)
+		if err != nil {
+			return nil, err
+		}
+		data["service."+s.Type+"."+s.Name] = string(config)
+	}
+
+	for name, t := range notificationTriggers {
+		trigger := map[string]interface{}{
+			"when": t.When,
+			"send": []string{t.Template},
+		}
+		if t.OncePer != "" {
+			trigger["oncePer"] = t.OncePer
+		}
+		triggers, err := yaml.Marshal([]interface{}{trigger})
+		if err != nil {
+			return nil, err
+		}
+		data["trigger."+name] = string(triggers)
+
+		// Slack and Teams send the message, webhooks their body
+		template := map[string]interface{}{"message": t.Message}
+		webhooks := map[string]interface{}{}
+		for _, s := range services {
+			if s.Type != "webhook" {
+				continue
+			}
+			webhook := map[string]interface{}{
+				"method": "POST",
+				"body":   webhookBody(s.WebhookFormat, t),
+			}
+			if s.WebhookPath != "" {
+				webhook["path"] = s.WebhookPath
+			}
+			webhooks[s.Name] = webhook
+		}
+		if len(webhooks) > 0 {
+			template["webhook"] = webhooks
+		}
+		templates, err := yaml.Marshal(template)
+		if err != nil {
+			return nil, err
+		}
+		data["template."+t.Template] = string(templates)
+	}
+
+	// If we're here, it should have gone okay...
+	return data, nil
+}
+
+// webhookBody returns the body a webhook posts for a trigger
+func webhookBody(format string, t notificationTrigger) string {
+	switch format {
+	case WebhookSlack:
+		return `{"text": "` + t.Message + `"}`
+	case WebhookGitHub:
+		return `{"state": "` + t.State + `", "context": "argocd/{{.app.metadata.name}}", "description": "` + t.Reason + `"}`
+	}
+	return `{"involvedObject": {"kind": "Application", "namespace": "{{.app.metadata.namespace}}", "name": "{{.app.metadata.name}}"}, "severity": "` + t.Severity + `", "reason": "` + t.Reason + `", "message": "` + t.Message + `", "reportingController": "argocd-notifications"}`
+}
+
+// SetSubscriptions subscribes an Application, or the Applications an ApplicationSet generates, to
+// triggers. Recipients of the same trigger and service are kept together.
+func SetSubscriptions(obj client.Object, subs []Subscription) {
+	recipients := map[string]map[string]bool{}
+	for _, s := range subs {
+		key := subscribeAnnotation + s.Trigger + "." + s.Service
+		if recipients[key] == nil {
+			recipients[key] = map[string]bool{}
+		}
+		if s.Recipient != "" {
+			recipients[key][s.Recipient] = true
+		}
+	}
+
+	annotations := map[string]string{}
+	for key, set := range recipients {
+		// Keep the recipients that are already there
+		for _, r := range strings.Split(obj.GetAnnotations()[key], ";") {
+			if r != "" {
+				set[r] = true
+			}
+		}
+		rs := make([]string, 0, len(set))
+		for r := range set {
+			rs = append(rs, r)
+		}
+		sort.Strings(rs)
+		annotations[key] = strings.Join(rs, ";")
+	}
+	setAnnotations(obj, annotations)
+}
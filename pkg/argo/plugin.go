@@ -0,0 +1,146 @@
+package argo
+
+import (
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	yaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// RepoServerDeployment is the Deployment config management plugins run next to, as sidecars
+	RepoServerDeployment = "argocd-repo-server"
+	// pluginConfigKey is the key of the plugin's ConfigMap the plugin is configured under
+	pluginConfigKey = "plugin.yaml"
+	// pluginUser is the user Argo CD runs plugin sidecars as
+	pluginUser = int64(999)
+)
+
+// ConfigManagementPlugin is a config management plugin, run as a sidecar of the repo server
+type ConfigManagementPlugin struct {
+	Name      string
+	Namespace string
+	// Image has to have a shell and whatever Init and Generate run
+	Image string
+	// Init and Generate are shell scripts, run in the Application's directory
+	Init     string
+	Generate string
+	// Env, Volumes and VolumeMounts are added to the sidecar
+	Env          []apiv1.EnvVar
+	Volumes      []apiv1.Volume
+	VolumeMounts []apiv1.VolumeMount
+}
+
+// ConfigMapName returns the name of the ConfigMap the plugin is configured in
+func (p ConfigManagementPlugin) ConfigMapName() string {
+	return "cmp-" + p.Name
+}
+
+// GenPluginConfigMap generates the ConfigMap the plugin is configured in, mounted in the sidecar
+func GenPluginConfigMap(p ConfigManagementPlugin) (*apiv1.ConfigMap, error) {
+	command := func(script string) map[string]interface{} {
+		return map[string]interface{}{
+			"command": []string{"sh", "-c"},
+			"args":    []string{script},
+		}
+	}
+
+	spec := map[string]interface{}{
+		"generate": command(p.Generate),
+	}
+	if p.Init != "" {
+		spec["init"] = command(p.Init)
+	}
+
+	config, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ConfigManagementPlugin",
+		"metadata":   map[string]interface{}{"name": p.Name},
+		"spec":       spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.ConfigMapName(),
+			Namespace: p.Namespace,
+		},
+		Data: map[string]string{pluginConfigKey: string(config)},
+	}
+	cm.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("ConfigMap"))
+
+	return cm, nil
+}
+
+// GenRepoServerPatch generates the strategic merge patch that adds the plugin's sidecar to the repo
+// server, and the patch that takes it out again
+func GenRepoServerPatch(p ConfigManagementPlugin) ([]byte, []byte, error) {
+	runAsNonRoot := true
+	runAsUser := pluginUser
+
+	configVolume := p.ConfigMapName()
+	tmpVolume := p.ConfigMapName() + "-tmp"
+
+	// The repo server shares the cmp-server binary and the plugin socket directory through the
+	// var-files and plugins volumes
+	sidecar := apiv1.Container{
+		Name:    p.Name,
+		Image:   p.Image,
+		Command: []string{"/var/run/argocd/argocd-cmp-server"},
+		Env:     p.Env,
+		SecurityContext: &apiv1.SecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &runAsUser,
+		},
+		VolumeMounts: append([]apiv1.VolumeMount{
+			{Name: "var-files", MountPath: "/var/run/argocd"},
+			{Name: "plugins", MountPath: "/home/argocd/cmp-server/plugins"},
+			{Name: configVolume, MountPath: "/home/argocd/cmp-server/config/" + pluginConfigKey, SubPath: pluginConfigKey},
+			{Name: tmpVolume, MountPath: "/tmp"},
+		}, p.VolumeMounts...),
+	}
+	volumes := append([]apiv1.Volume{
+		{Name: configVolume, VolumeSource: apiv1.VolumeSource{ConfigMap: &apiv1.ConfigMapVolumeSource{LocalObjectReference: apiv1.LocalObjectReference{Name: p.ConfigMapName()}}}},
+		{Name: tmpVolume, VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}}},
+	}, p.Volumes...)
+
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+		"containers": []apiv1.Container{sidecar},
+		"volumes":    volumes,
+	}}}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Strategic merge deletes list items by their name
+	remove := func(name string) map[string]string {
+		return map[string]string{"name": name, "$patch": "delete"}
+	}
+	removeVolumes := []map[string]string{}
+	for _, v := range volumes {
+		removeVolumes = append(removeVolumes, remove(v.Name))
+	}
+	undo, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+		"containers": []map[string]string{remove(p.Name)},
+		"volumes":    removeVolumes,
+	}}}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return patch, undo, nil
+}
+
+// HasSidecar tells if the repo server already runs the plugin
+func HasSidecar(d *appsv1.Deployment, p ConfigManagementPlugin) bool {
+	for _, c := range d.Spec.Template.Spec.Containers {
+		if c.Name == p.Name {
+			return true
+		}
+	}
+	return false
+}
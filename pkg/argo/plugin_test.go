@@ -0,0 +1,30 @@
+package argo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestGenRepoServerPatch(t *testing.T) {
+	p := ConfigManagementPlugin{Name: "flux-envsubst", Namespace: "argocd", Image: "ghcr.io/fluxcd/flux-cli:v2.1.2", Generate: "flux envsubst"}
+
+	patch, undo, err := GenRepoServerPatch(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The patch adds the sidecar with its ConfigMap mounted
+	d := &appsv1.Deployment{}
+	if err := json.Unmarshal(patch, d); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, HasSidecar(d, p), true)
+	assert.Equal(t, d.Spec.Template.Spec.Volumes[0].ConfigMap.Name, "cmp-flux-envsubst")
+	assert.Equal(t, d.Spec.Template.Spec.Containers[0].VolumeMounts[2].MountPath, "/home/argocd/cmp-server/config/plugin.yaml")
+
+	// The undo deletes the sidecar and its volumes by name
+	assert.Equal(t, string(undo), `{"spec":{"template":{"spec":{"containers":[{"$patch":"delete","name":"flux-envsubst"}],"volumes":[{"$patch":"delete","name":"cmp-flux-envsubst"},{"$patch":"delete","name":"cmp-flux-envsubst-tmp"}]}}}}`)
+}
@@ -0,0 +1,47 @@
+package argo
+
+import (
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AppProject is what a project is generated from
+type AppProject struct {
+	Name        string
+	Namespace   string
+	Description string
+	// SourceRepos are the repositories the project's Applications can come from
+	SourceRepos []string
+	// Destinations are the clusters and namespaces the project's Applications can deploy to
+	Destinations []v1alpha1.ApplicationDestination
+	// ClusterResources are the only cluster-scoped kinds the project's Applications can deploy, none
+	// if it's empty
+	ClusterResources []metav1.GroupKind
+}
+
+// GenAppProject generates an Argo CD AppProject
+func GenAppProject(p AppProject) *v1alpha1.AppProject {
+	project := &v1alpha1.AppProject{}
+	project.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("AppProject"))
+	project.SetName(p.Name)
+	project.SetNamespace(p.Namespace)
+	project.Spec = v1alpha1.AppProjectSpec{
+		Description:              p.Description,
+		SourceRepos:              p.SourceRepos,
+		Destinations:             p.Destinations,
+		ClusterResourceWhitelist: p.ClusterResources,
+	}
+
+	return project
+}
+
+// SetProject assigns an Application, or the Applications an ApplicationSet generates, to a project
+func SetProject(obj client.Object, project string) {
+	switch a := obj.(type) {
+	case *v1alpha1.Application:
+		a.Spec.Project = project
+	case *v1alpha1.ApplicationSet:
+		a.Spec.Template.Spec.Project = project
+	}
+}
@@ -0,0 +1,247 @@
+package argo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// TLSCertsConfigMap holds the CA certificates Argo CD trusts, keyed by repository host
+	TLSCertsConfigMap = "argocd-tls-certs-cm"
+	// SSHKnownHostsConfigMap holds the SSH known hosts Argo CD trusts
+	SSHKnownHostsConfigMap = "argocd-ssh-known-hosts-cm"
+	// sshKnownHostsKey is the key of SSHKnownHostsConfigMap the known hosts are kept under
+	sshKnownHostsKey = "ssh_known_hosts"
+)
+
+// OCIScheme is how Flux marks OCI registry URLs, Argo CD wants them without it
+const OCIScheme = "oci://"
+
+// bearerTokenUsername is used with a Flux bearer token, Argo CD only does basic auth for Git
+const bearerTokenUsername = "git"
+
+// RepositoryCredentials are the credentials Argo CD uses for a repository
+type RepositoryCredentials struct {
+	SSHPrivateKey     string
+	Username          string
+	Password          string
+	TLSClientCertData string
+	TLSClientCertKey  string
+	Insecure          bool
+	// EnableOCI is set for Helm charts in an OCI registry
+	EnableOCI bool
+	// CAData and KnownHosts aren't part of the repository Secret, they go in Argo CD's ConfigMaps
+	CAData     string
+	KnownHosts string
+}
+
+// NewRepositoryCredentials converts the Secret of a Flux source into Argo CD repository
+// credentials. It understands every Flux format: SSH (identity, known_hosts), basic auth
+// (username, password), bearerToken and TLS (tls.crt, tls.key, ca.crt, or the older certFile,
+// keyFile and caFile). A nil Secret, for public repositories, has no credentials.
+func NewRepositoryCredentials(s *apiv1.Secret) RepositoryCredentials {
+	r := RepositoryCredentials{}
+	if s == nil {
+		return r
+	}
+
+	// Flux uses Data, but StringData is what's in the manifests before it's applied
+	get := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := s.Data[k]; ok {
+				return string(v)
+			}
+			if v, ok := s.StringData[k]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	r.SSHPrivateKey = get("identity")
+	r.KnownHosts = get("known_hosts")
+	r.TLSClientCertData = get("tls.crt", "certFile")
+	r.TLSClientCertKey = get("tls.key", "keyFile")
+	r.CAData = get("ca.crt", "caFile")
+
+	// With an SSH key, the password is the key's passphrase
+	if r.SSHPrivateKey != "" {
+		if get("password") != "" {
+			log.Warn("Secret \"" + s.Name + "\" has an SSH key with a passphrase, Argo CD only supports SSH keys without one")
+		}
+		return r
+	}
+
+	r.Username = get("username")
+	r.Password = get("password")
+
+	// This version of Argo CD has no bearer token for Git repositories
+	if token := get("bearerToken"); token != "" && r.Password == "" {
+		log.Warn("Secret \"" + s.Name + "\" has a bearer token, which Argo CD doesn't support for Git repositories. It's used as the password for the user \"" + bearerTokenUsername + "\", which works for providers that accept tokens with basic auth, like GitHub and GitLab")
+		r.Username = bearerTokenUsername
+		r.Password = token
+	}
+
+	return r
+}
+
+// NewRegistryCredentials converts the Secret of a Flux OCI HelmRepository into Argo CD
+// repository credentials for the registry at registryURL. The Secret is either a
+// kubernetes.io/dockerconfigjson Secret, where the entry for the registry host is used, or has a
+// username and password. A nil Secret, for public registries, only enables OCI.
+func NewRegistryCredentials(s *apiv1.Secret, registryURL string) RepositoryCredentials {
+	r := NewRepositoryCredentials(s)
+	r.EnableOCI = true
+	if s == nil {
+		return r
+	}
+
+	config, ok := s.Data[apiv1.DockerConfigJsonKey]
+	if !ok {
+		if v, found := s.StringData[apiv1.DockerConfigJsonKey]; found {
+			config, ok = []byte(v), true
+		}
+	}
+	if !ok {
+		return r
+	}
+
+	username, password, err := dockerConfigAuth(config, registryHost(registryURL))
+	if err != nil {
+		log.Warn("Secret \"" + s.Name + "\" has no usable credentials for " + registryHost(registryURL) + ": " + err.Error())
+		return r
+	}
+	r.Username = username
+	r.Password = password
+	return r
+}
+
+// dockerConfigAuth returns the username and password for host from a Docker config.json
+func dockerConfigAuth(config []byte, host string) (string, string, error) {
+	dc := struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(config, &dc); err != nil {
+		return "", "", err
+	}
+
+	for registry, auth := range dc.Auths {
+		if !sameRegistry(registryHost(registry), host) {
+			continue
+		}
+		if auth.Username != "" || auth.Password != "" {
+			return auth.Username, auth.Password, nil
+		}
+
+		// auth is the base64 encoded "username:password"
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", err
+		}
+		username, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return "", "", errors.New("the auth field isn't username:password")
+		}
+		return username, password, nil
+	}
+	return "", "", errors.New("the Docker config has no entry for the registry")
+}
+
+// registryHost returns the host, and port, of a registry URL or Docker config key
+func registryHost(registryURL string) string {
+	host := strings.TrimPrefix(TrimOCIScheme(registryURL), "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
+
+// sameRegistry tells if two registry hosts are the same, Docker Hub goes by a few names
+func sameRegistry(a string, b string) bool {
+	dockerHub := func(h string) string {
+		switch h {
+		case "index.docker.io", "registry-1.docker.io":
+			return "docker.io"
+		}
+		return h
+	}
+	return dockerHub(a) == dockerHub(b)
+}
+
+// TrimOCIScheme returns an OCI registry URL the way Argo CD wants it, without the oci:// scheme
+func TrimOCIScheme(repoURL string) string {
+	return strings.TrimPrefix(repoURL, OCIScheme)
+}
+
+// SecretData returns the fields of the Argo CD repository Secret for repoURL
+func (r RepositoryCredentials) SecretData(repoType string, repoURL string) map[string]string {
+	data := map[string]string{
+		"type": repoType,
+		"url":  repoURL,
+	}
+
+	set := func(key string, value string) {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	set("sshPrivateKey", r.SSHPrivateKey)
+	set("username", r.Username)
+	set("password", r.Password)
+	set("tlsClientCertData", r.TLSClientCertData)
+	set("tlsClientCertKey", r.TLSClientCertKey)
+	if r.Insecure {
+		data["insecure"] = "true"
+	}
+	if r.EnableOCI {
+		data["enableOCI"] = "true"
+	}
+
+	return data
+}
+
+// ConfigMapData returns what has to be added to Argo CD's ConfigMaps for repoURL, by ConfigMap
+// name: the CA certificate, keyed by the repository host, and the SSH known hosts
+func (r RepositoryCredentials) ConfigMapData(repoURL string) map[string]map[string]string {
+	cms := map[string]map[string]string{}
+
+	if host := repositoryHost(repoURL); r.CAData != "" && host != "" {
+		cms[TLSCertsConfigMap] = map[string]string{host: r.CAData}
+	}
+	if r.KnownHosts != "" {
+		cms[SSHKnownHostsConfigMap] = map[string]string{sshKnownHostsKey: r.KnownHosts}
+	}
+
+	return cms
+}
+
+// repositoryHost returns the host of a repository URL, including the scp-like SSH URLs Git uses
+func repositoryHost(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+
+	// git@github.com:org/repo.git
+	if i := strings.Index(repoURL, ":"); i > 0 {
+		host := repoURL[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		return host
+	}
+
+	// ghcr.io/org/charts, OCI registries without the scheme
+	if host, _, _ := strings.Cut(repoURL, "/"); strings.Contains(host, ".") {
+		return host
+	}
+	return ""
+}
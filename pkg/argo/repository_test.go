@@ -0,0 +1,141 @@
+package argo
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestNewRepositoryCredentials(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           map[string]string
+		expectedData   map[string]string
+		expectedConfig map[string]map[string]string
+	}{
+		{
+			name: "when the secret has an SSH key",
+			data: map[string]string{"identity": "KEY", "identity.pub": "PUB", "known_hosts": "github.com ssh-ed25519 AAAA"},
+			expectedData: map[string]string{
+				"type": "git", "url": "ssh://git@github.com/example/fleet", "sshPrivateKey": "KEY",
+			},
+			expectedConfig: map[string]map[string]string{
+				SSHKnownHostsConfigMap: {"ssh_known_hosts": "github.com ssh-ed25519 AAAA"},
+			},
+		},
+		{
+			name: "when the secret has basic auth",
+			data: map[string]string{"username": "flux", "password": "s3cr3t"},
+			expectedData: map[string]string{
+				"type": "git", "url": "ssh://git@github.com/example/fleet", "username": "flux", "password": "s3cr3t",
+			},
+			expectedConfig: map[string]map[string]string{},
+		},
+		{
+			name: "when the secret has a bearer token",
+			data: map[string]string{"bearerToken": "t0ken"},
+			expectedData: map[string]string{
+				"type": "git", "url": "ssh://git@github.com/example/fleet", "username": "git", "password": "t0ken",
+			},
+			expectedConfig: map[string]map[string]string{},
+		},
+		{
+			name: "when the secret has TLS certificates",
+			data: map[string]string{"tls.crt": "CERT", "tls.key": "CERTKEY", "ca.crt": "CA"},
+			expectedData: map[string]string{
+				"type": "git", "url": "ssh://git@github.com/example/fleet", "tlsClientCertData": "CERT", "tlsClientCertKey": "CERTKEY",
+			},
+			expectedConfig: map[string]map[string]string{
+				TLSCertsConfigMap: {"github.com": "CA"},
+			},
+		},
+		{
+			name: "when the secret has deprecated TLS keys",
+			data: map[string]string{"certFile": "CERT", "keyFile": "CERTKEY", "caFile": "CA"},
+			expectedData: map[string]string{
+				"type": "git", "url": "ssh://git@github.com/example/fleet", "tlsClientCertData": "CERT", "tlsClientCertKey": "CERTKEY",
+			},
+			expectedConfig: map[string]map[string]string{
+				TLSCertsConfigMap: {"github.com": "CA"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &apiv1.Secret{Data: map[string][]byte{}}
+			for k, v := range tt.data {
+				s.Data[k] = []byte(v)
+			}
+
+			creds := NewRepositoryCredentials(s)
+			assert.Equal(t, creds.SecretData("git", "ssh://git@github.com/example/fleet"), tt.expectedData)
+			assert.Equal(t, creds.ConfigMapData("ssh://git@github.com/example/fleet"), tt.expectedConfig)
+		})
+	}
+}
+
+func TestRepositoryHost(t *testing.T) {
+	assert.Equal(t, repositoryHost("https://gitlab.example.com:8443/org/repo.git"), "gitlab.example.com")
+	assert.Equal(t, repositoryHost("ssh://git@github.com/org/repo"), "github.com")
+	assert.Equal(t, repositoryHost("git@github.com:org/repo.git"), "github.com")
+	assert.Equal(t, repositoryHost("oci://ghcr.io/org/charts"), "ghcr.io")
+	assert.Equal(t, repositoryHost("ghcr.io/org/charts"), "ghcr.io")
+}
+
+func TestNewRegistryCredentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		secret       *apiv1.Secret
+		expectedData map[string]string
+	}{
+		{
+			name: "when the registry is public",
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true",
+			},
+		},
+		{
+			name: "when the secret has basic auth",
+			secret: &apiv1.Secret{Data: map[string][]byte{
+				"username": []byte("flux"), "password": []byte("s3cr3t"),
+			}},
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true", "username": "flux", "password": "s3cr3t",
+			},
+		},
+		{
+			name: "when the secret is a docker config with a username and password",
+			secret: &apiv1.Secret{Data: map[string][]byte{
+				apiv1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"username":"other","password":"other"},"https://ghcr.io":{"username":"flux","password":"s3cr3t"}}}`),
+			}},
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true", "username": "flux", "password": "s3cr3t",
+			},
+		},
+		{
+			name: "when the secret is a docker config with an auth string",
+			secret: &apiv1.Secret{Data: map[string][]byte{
+				apiv1.DockerConfigJsonKey: []byte(`{"auths":{"ghcr.io":{"auth":"Zmx1eDpzM2NyM3Q="}}}`),
+			}},
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true", "username": "flux", "password": "s3cr3t",
+			},
+		},
+		{
+			name: "when the docker config has no entry for the registry",
+			secret: &apiv1.Secret{Data: map[string][]byte{
+				apiv1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"username":"other","password":"other"}}}`),
+			}},
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := NewRegistryCredentials(tt.secret, "oci://ghcr.io/example/charts")
+			assert.Equal(t, creds.SecretData("helm", TrimOCIScheme("oci://ghcr.io/example/charts")), tt.expectedData)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package argo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How often the Applications are checked while waiting for them
+var waitInterval = 5 * time.Second
+
+// ApplicationStatus is where an Application got to while we were waiting for it
+type ApplicationStatus struct {
+	Namespace string
+	Name      string
+	Sync      string
+	Health    string
+	Message   string
+}
+
+// NewApplicationStatus returns the status of app
+func NewApplicationStatus(app *v1alpha1.Application) ApplicationStatus {
+	s := ApplicationStatus{
+		Namespace: app.Namespace,
+		Name:      app.Name,
+		Sync:      string(app.Status.Sync.Status),
+		Health:    string(app.Status.Health.Status),
+		Message:   app.Status.Health.Message,
+	}
+
+	// A failed sync or an error condition says more than the health does
+	if op := app.Status.OperationState; op != nil && op.Phase.Failed() && op.Message != "" {
+		s.Message = op.Message
+	}
+	for _, c := range app.Status.Conditions {
+		if c.IsError() {
+			s.Message = c.Message
+			break
+		}
+	}
+
+	// Argo CD hasn't looked at it yet
+	if s.Sync == "" {
+		s.Sync = "Unknown"
+	}
+	if s.Health == "" {
+		s.Health = "Unknown"
+	}
+	return s
+}
+
+// VerificationError is returned when Applications aren't Synced and Healthy before the timeout
+type VerificationError struct {
+	// Object is what we were waiting for, an Application or an ApplicationSet
+	Object  string
+	Timeout time.Duration
+	// Pending are the Applications that didn't make it, it's empty if there were no Applications at all
+	Pending []ApplicationStatus
+	// Generated is set when the ApplicationSet controller was done and there were no Applications to
+	// wait for, with why
+	Generated string
+}
+
+// Error reports each Application that isn't Synced and Healthy on its own line
+func (e *VerificationError) Error() string {
+	if e.Generated != "" {
+		return fmt.Sprintf("%s generated no Applications: %s", e.Object, e.Generated)
+	}
+	if len(e.Pending) == 0 {
+		return fmt.Sprintf("%s has no Applications after %s, check that the ApplicationSet controller is running", e.Object, e.Timeout)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is not Synced and Healthy after %s:", e.Object, e.Timeout)
+	for _, p := range e.Pending {
+		fmt.Fprintf(&b, "\n  Application %s/%s is %s and %s", p.Namespace, p.Name, p.Sync, p.Health)
+		if p.Message != "" {
+			fmt.Fprintf(&b, ": %s", p.Message)
+		}
+	}
+	return b.String()
+}
+
+// IsApplicationReady returns true when an Application is Synced and Healthy
+func IsApplicationReady(app *v1alpha1.Application) bool {
+	return app.Status.Sync.Status == v1alpha1.SyncStatusCodeSynced && app.Status.Health.Status == "Healthy"
+}
+
+// WaitForApplication waits until the Application is Synced and Healthy. When the timeout is up,
+// it returns a *VerificationError.
+func WaitForApplication(c client.Client, ctx context.Context, ns string, name string, timeout time.Duration) error {
+	app := &v1alpha1.Application{}
+	err := wait.PollImmediate(waitInterval, timeout, func() (bool, error) {
+		if err := c.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, app); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		return IsApplicationReady(app), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		app.Namespace, app.Name = ns, name
+		return &VerificationError{
+			Object:  "Application " + ns + "/" + name,
+			Timeout: timeout,
+			Pending: []ApplicationStatus{NewApplicationStatus(app)},
+		}
+	}
+	return err
+}
+
+// WaitForApplicationSet waits until all the Applications generated by the ApplicationSet are
+// Synced and Healthy. When the timeout is up, or when the ApplicationSet controller generated no
+// Applications at all, it returns a *VerificationError.
+func WaitForApplicationSet(c client.Client, ctx context.Context, ns string, name string, timeout time.Duration) error {
+	var pending []ApplicationStatus
+	generated := ""
+	err := wait.PollImmediate(waitInterval, timeout, func() (bool, error) {
+		apps, err := ApplicationSetApplications(c, ctx, ns, name)
+		if err != nil {
+			return false, err
+		}
+
+		// Nothing will ever be Synced and Healthy, don't wait for the timeout
+		if len(apps) == 0 {
+			appset := &v1alpha1.ApplicationSet{}
+			if err := c.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, appset); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+			generated = applicationSetGenerated(appset)
+			return generated != "", nil
+		}
+
+		pending = nil
+		for i := range apps {
+			if !IsApplicationReady(&apps[i]) {
+				pending = append(pending, NewApplicationStatus(&apps[i]))
+			}
+		}
+		return len(apps) > 0 && len(pending) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout || generated != "" {
+		return &VerificationError{
+			Object:    "ApplicationSet " + ns + "/" + name,
+			Timeout:   timeout,
+			Pending:   pending,
+			Generated: generated,
+		}
+	}
+	return err
+}
+
+// applicationSetGenerated returns why the ApplicationSet controller is done with an ApplicationSet,
+// from its conditions. It's empty while it's still generating the Applications.
+func applicationSetGenerated(appset *v1alpha1.ApplicationSet) string {
+	for _, c := range appset.Status.Conditions {
+		if c.Status != v1alpha1.ApplicationSetConditionStatusTrue {
+			continue
+		}
+		switch c.Type {
+		case v1alpha1.ApplicationSetConditionErrorOccurred:
+			if c.Message != "" {
+				return c.Message
+			}
+			return c.Reason
+		case v1alpha1.ApplicationSetConditionResourcesUpToDate:
+			return "its generators matched nothing, check that the paths are in the repo"
+		}
+	}
+	return ""
+}
+
+// ApplicationSetApplications returns the Applications generated by an ApplicationSet, the ones it owns
+func ApplicationSetApplications(c client.Client, ctx context.Context, ns string, name string) ([]v1alpha1.Application, error) {
+	apps := &v1alpha1.ApplicationList{}
+	if err := c.List(ctx, apps, client.InNamespace(ns)); err != nil {
+		return nil, err
+	}
+
+	var owned []v1alpha1.Application
+	for _, a := range apps.Items {
+		for _, o := range a.OwnerReferences {
+			if o.Kind == "ApplicationSet" && o.Name == name {
+				owned = append(owned, a)
+				break
+			}
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,32 @@
+package argo
+
+import (
+	"strconv"
+
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SyncWaveAnnotation orders the resources Argo CD syncs, lower waves first
+const SyncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+
+// SetSyncWave sets the sync wave of an Application, or of an ApplicationSet and the Applications it
+// generates
+func SetSyncWave(obj client.Object, wave int) {
+	setAnnotations(obj, map[string]string{SyncWaveAnnotation: strconv.Itoa(wave)})
+}
+
+// GenAppOfApps generates the parent Application of the Applications and ApplicationSets in path,
+// which syncs them in the order of their sync waves. It doesn't prune, so a migrated Application
+// taken out of path is left alone.
+func GenAppOfApps(name string, namespace string, repoURL string, revision string, path string) *v1alpha1.Application {
+	return GenApplication(name, namespace, AppSpec{
+		Project:              "default",
+		RepoURL:              repoURL,
+		TargetRevision:       revision,
+		Path:                 path,
+		DestinationServer:    InClusterServer,
+		DestinationNamespace: namespace,
+	})
+}
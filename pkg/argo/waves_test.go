@@ -0,0 +1,21 @@
+package argo
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestSetSyncWave(t *testing.T) {
+	app := &v1alpha1.Application{}
+	app.SetAnnotations(map[string]string{"team": "platform"})
+	SetSyncWave(app, 2)
+	assert.Equal(t, app.GetAnnotations(), map[string]string{"team": "platform", SyncWaveAnnotation: "2"})
+
+	// The Applications an ApplicationSet generates carry the wave too
+	appSet := &v1alpha1.ApplicationSet{}
+	SetSyncWave(appSet, 1)
+	assert.Equal(t, appSet.GetAnnotations()[SyncWaveAnnotation], "1")
+	assert.Equal(t, appSet.Spec.Template.Annotations[SyncWaveAnnotation], "1")
+}
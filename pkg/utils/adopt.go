@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ArgoCDInstanceLabel is the label Argo CD tracks resources with by default
+	ArgoCDInstanceLabel = "app.kubernetes.io/instance"
+	// ArgoCDTrackingIDAnnotation is the annotation Argo CD tracks resources with when it's set to annotation tracking
+	ArgoCDTrackingIDAnnotation = "argocd.argoproj.io/tracking-id"
+	// FluxPruneAnnotation stops kustomize-controller from garbage collecting a resource when set to "disabled"
+	FluxPruneAnnotation = "kustomize.toolkit.fluxcd.io/prune"
+)
+
+// helmRelease is the part of a Helm release, as kept in its storage Secret, that we need
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Manifest  string `json:"manifest"`
+}
+
+// gzipMagic is how a gzipped Helm release starts
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// HelmReleaseObjects returns the live resources of a HelmRelease, read from the manifest of the
+// deployed Helm release in the storage namespace
+func HelmReleaseObjects(c client.Client, ctx context.Context, h *helmv2.HelmRelease) ([]*unstructured.Unstructured, error) {
+	// Helm keeps a Secret for each release revision, the deployed one is labeled as such
+	secrets := &apiv1.SecretList{}
+	err := c.List(ctx, secrets, client.InNamespace(h.GetStorageNamespace()), client.MatchingLabels{
+		"owner":  "helm",
+		"name":   h.GetReleaseName(),
+		"status": "deployed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets.Items) == 0 {
+		return nil, fmt.Errorf("no deployed Helm release %q found in namespace %s", h.GetReleaseName(), h.GetStorageNamespace())
+	}
+
+	// Use the latest revision
+	sort.Slice(secrets.Items, func(i, j int) bool {
+		vi, _ := strconv.Atoi(secrets.Items[i].Labels["version"])
+		vj, _ := strconv.Atoi(secrets.Items[j].Labels["version"])
+		return vi > vj
+	})
+	rel, err := decodeHelmRelease(secrets.Items[0].Data["release"])
+	if err != nil {
+		return nil, fmt.Errorf("decoding Helm release Secret %s: %w", secrets.Items[0].Name, err)
+	}
+
+	objs, err := DecodeManifests(strings.NewReader(rel.Manifest))
+	if err != nil {
+		return nil, err
+	}
+
+	// Namespaced resources without a namespace are installed in the release namespace
+	for _, o := range objs {
+		if o.GetNamespace() != "" {
+			continue
+		}
+		if namespaced, err := c.IsObjectNamespaced(o); err != nil || namespaced {
+			o.SetNamespace(rel.Namespace)
+		}
+	}
+
+	return objs, nil
+}
+
+// decodeHelmRelease decodes the release stored by Helm: base64 encoded, usually gzipped, JSON
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(b, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if b, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	rel := &helmRelease{}
+	return rel, json.Unmarshal(b, rel)
+}
+
+// KustomizationObjects returns the live resources of a Kustomization from its inventory. Only
+// the kind, namespace and name are set.
+func KustomizationObjects(k *kustomizev1.Kustomization) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	if k.Status.Inventory == nil {
+		return objs, nil
+	}
+
+	for _, e := range k.Status.Inventory.Entries {
+		ns, name, group, kind, err := parseInventoryID(e.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		o := &unstructured.Unstructured{}
+		o.SetGroupVersionKind(schema.GroupVersionKind{Group: group, Version: e.Version, Kind: kind})
+		o.SetNamespace(ns)
+		o.SetName(name)
+		objs = append(objs, o)
+	}
+
+	return objs, nil
+}
+
+// parseInventoryID splits a Flux inventory ID, '<namespace>_<name>_<group>_<kind>'. Colons in
+// names (RBAC) are stored as double underscores.
+func parseInventoryID(id string) (ns string, name string, group string, kind string, err error) {
+	first := strings.Index(id, "_")
+	last := strings.LastIndex(id, "_")
+	if first < 0 || first == last {
+		return "", "", "", "", fmt.Errorf("invalid inventory entry %q", id)
+	}
+	ns, kind = id[:first], id[last+1:]
+
+	rest := id[first+1 : last]
+	sep := strings.LastIndex(rest, "_")
+	if sep < 0 {
+		return "", "", "", "", fmt.Errorf("invalid inventory entry %q", id)
+	}
+	name, group = strings.ReplaceAll(rest[:sep], "__", ":"), rest[sep+1:]
+
+	return ns, name, group, kind, nil
+}
+
+// TrackWithArgoCD labels and annotates live resources the way Argo CD tracks the resources of
+// appName, so the first sync of the Application patches them instead of treating them as new.
+// Resources that are gone are skipped. Rolling back puts back the label and annotation they had.
+func (t *MigrationTransaction) TrackWithArgoCD(appName string, objs ...*unstructured.Unstructured) error {
+	for _, o := range objs {
+		err := t.client.Get(t.ctx, client.ObjectKeyFromObject(o), o)
+		if apierrors.IsNotFound(err) {
+			log.Warn("Not adopting ", describeObject(t.client, o), ", it wasn't found")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		gvk := o.GroupVersionKind()
+		trackingID := fmt.Sprintf("%s:%s/%s:%s/%s", appName, gvk.Group, gvk.Kind, o.GetNamespace(), o.GetName())
+
+		patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q},"annotations":{%q:%q}}}`,
+			ArgoCDInstanceLabel, appName, ArgoCDTrackingIDAnnotation, trackingID)
+		undo, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"labels":      map[string]any{ArgoCDInstanceLabel: previousValue(o.GetLabels(), ArgoCDInstanceLabel)},
+				"annotations": map[string]any{ArgoCDTrackingIDAnnotation: previousValue(o.GetAnnotations(), ArgoCDTrackingIDAnnotation)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := t.Patch("track", o, client.RawPatch(types.MergePatchType, []byte(patch)), client.RawPatch(types.MergePatchType, undo)); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// previousValue is what a merge patch sets key back to, nil removes it if it wasn't there
+func previousValue(m map[string]string, key string) any {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	return nil
+}
+
+// DisablePrune stops kustomize-controller from garbage collecting the resources of a
+// Kustomization when it's deleted: spec.prune is turned off and every resource in the
+// inventory is annotated with kustomize.toolkit.fluxcd.io/prune: disabled.
+func (t *MigrationTransaction) DisablePrune(k *kustomizev1.Kustomization) error {
+	objs, err := KustomizationObjects(k)
+	if err != nil {
+		return err
+	}
+
+	undo := fmt.Sprintf(`{"spec":{"prune":%t}}`, k.Spec.Prune)
+	if err := t.Patch("disable-prune", k, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"prune":false}}`)), client.RawPatch(types.MergePatchType, []byte(undo))); err != nil {
+		return err
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"disabled"}}}`, FluxPruneAnnotation)
+	undo = fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, FluxPruneAnnotation)
+	for _, o := range objs {
+		err := t.client.Get(t.ctx, client.ObjectKeyFromObject(o), o)
+		if apierrors.IsNotFound(err) {
+			log.Warn("Not protecting ", describeObject(t.client, o), " from pruning, it wasn't found")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Leave alone the ones that were already protected, rolling back shouldn't change them
+		if o.GetAnnotations()[FluxPruneAnnotation] == "disabled" {
+			continue
+		}
+		if err := t.Patch("disable-prune", o, client.RawPatch(types.MergePatchType, []byte(patch)), client.RawPatch(types.MergePatchType, []byte(undo))); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// RemoveFinalizers removes the finalizers from Flux objects so deleting them can't trigger a
+// garbage collection or an uninstall. Flux skips both for suspended objects, this makes sure
+// of it. Flux adds the finalizers back if the object is resumed, so there's nothing to undo.
+func (t *MigrationTransaction) RemoveFinalizers(obj ...client.Object) error {
+	for _, o := range obj {
+		if err := t.Patch("remove-finalizers", o, client.RawPatch(types.MergePatchType, []byte(`{"metadata":{"finalizers":null}}`)), nil); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// AdoptHelmRelease labels the live resources of a HelmRelease for appName, see TrackWithArgoCD. A
+// release that was never deployed has nothing to adopt.
+func AdoptHelmRelease(tx *MigrationTransaction, c client.Client, ctx context.Context, h *helmv2.HelmRelease, appName string) error {
+	objs, err := HelmReleaseObjects(c, ctx, h)
+	if err != nil {
+		log.Warn("Not labeling the resources of HelmRelease \"", h.Name, "\" for Argo CD: ", err)
+		return nil
+	}
+	return tx.TrackWithArgoCD(appName, objs...)
+}
+
+// AdoptKustomization labels the resources in the inventory of a Kustomization for appName, see
+// TrackWithArgoCD
+func AdoptKustomization(tx *MigrationTransaction, k *kustomizev1.Kustomization, appName string) error {
+	objs, err := KustomizationObjects(k)
+	if err != nil {
+		return err
+	}
+	return tx.TrackWithArgoCD(appName, objs...)
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseInventoryID(t *testing.T) {
+	tests := []struct {
+		id    string
+		ns    string
+		name  string
+		group string
+		kind  string
+	}{
+		{"podinfo_podinfo_apps_Deployment", "podinfo", "podinfo", "apps", "Deployment"},
+		{"podinfo_podinfo__Service", "podinfo", "podinfo", "", "Service"},
+		{"_podinfo__Namespace", "", "podinfo", "", "Namespace"},
+		{"_system__controller_rbac.authorization.k8s.io_ClusterRole", "", "system:controller", "rbac.authorization.k8s.io", "ClusterRole"},
+	}
+
+	for _, tt := range tests {
+		ns, name, group, kind, err := parseInventoryID(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, ns, tt.ns)
+		assert.Equal(t, name, tt.name)
+		assert.Equal(t, group, tt.group)
+		assert.Equal(t, kind, tt.kind)
+	}
+
+	_, _, _, _, err := parseInventoryID("podinfo")
+	assert.Equal(t, err != nil, true)
+}
+
+func TestHelmReleaseObjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	release := `{"name":"podinfo","namespace":"podinfo","manifest":"---\napiVersion: v1\nkind: Service\nmetadata:\n  name: podinfo\n---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: extra\n"}`
+	secret := helmReleaseSecret("flux-system", "podinfo", release)
+
+	c := NewOfflineClient(scheme, secret)
+	h := &helmv2.HelmRelease{}
+	h.SetNamespace("flux-system")
+	h.SetName("podinfo")
+
+	objs, err := HelmReleaseObjects(c, context.TODO(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(objs), 2)
+
+	// The Service goes in the release namespace, the Namespace doesn't have one
+	assert.Equal(t, objs[0].GetKind(), "Service")
+	assert.Equal(t, objs[0].GetNamespace(), "podinfo")
+	assert.Equal(t, objs[1].GetKind(), "Namespace")
+	assert.Equal(t, objs[1].GetNamespace(), "")
+}
+
+// helmReleaseSecret returns the Secret Helm stores a deployed release in. Helm stores the release
+// gzipped and base64 encoded, in a Secret that's base64 encoded again.
+func helmReleaseSecret(namespace string, name string, release string) *unstructured.Unstructured {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(release))
+	w.Close()
+
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(namespace)
+	secret.SetName("sh.helm.release.v1." + name + ".v1")
+	secret.SetLabels(map[string]string{"owner": "helm", "name": name, "status": "deployed", "version": "1"})
+	unstructured.SetNestedField(secret.Object, base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString(gz.Bytes()))), "data", "release")
+	return secret
+}
+
+func TestTrackWithArgoCD(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	// The chart already labeled it with the release name
+	svc := &unstructured.Unstructured{}
+	svc.SetAPIVersion("v1")
+	svc.SetKind("Service")
+	svc.SetNamespace("podinfo")
+	svc.SetName("podinfo")
+	svc.SetLabels(map[string]string{ArgoCDInstanceLabel: "podinfo"})
+
+	c := NewOfflineClient(scheme, svc)
+	ctx := context.TODO()
+	key := types.NamespacedName{Namespace: "podinfo", Name: "podinfo"}
+
+	tx := NewMigrationTransaction(c, ctx)
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion("v1")
+	o.SetKind("Service")
+	o.SetNamespace("podinfo")
+	o.SetName("podinfo")
+	if err := tx.TrackWithArgoCD("flux-system-podinfo", o); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &apiv1.Service{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.Labels[ArgoCDInstanceLabel], "flux-system-podinfo")
+	assert.Equal(t, got.Annotations[ArgoCDTrackingIDAnnotation], "flux-system-podinfo:/Service:podinfo/podinfo")
+
+	// Rolling back puts the label back and drops the annotation
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	got = &apiv1.Service{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.Labels[ArgoCDInstanceLabel], "podinfo")
+	_, ok := got.Annotations[ArgoCDTrackingIDAnnotation]
+	assert.Equal(t, ok, false)
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AppOfApps is the parent Application the migrated Applications and ApplicationSets are synced by,
+// in the order of their sync waves
+type AppOfApps struct {
+	Name string
+	// RepoURL, Revision and Path are where the children are committed. The repository of the
+	// bootstrap flux-system Kustomization is used if RepoURL is empty.
+	RepoURL  string
+	Revision string
+	Path     string
+}
+
+// AppOfAppsMigration is what Kustomizations and HelmReleases are migrated to with an app-of-apps
+type AppOfAppsMigration struct {
+	Parent *v1alpha1.Application
+	// Children are the Applications and ApplicationSets with their sync waves, and the AppProjects
+	// of the tenants, to be committed to the repository
+	Children []client.Object
+	// Secrets are the repository and cluster Secrets, they're applied and kept out of the repository
+	Secrets []*apiv1.Secret
+	// Repositories are the credentials of each repository, for their CA and known hosts
+	Repositories map[string]argo.RepositoryCredentials
+	// Plugin is set when some children are rendered by the envsubst plugin
+	Plugin bool
+	// Decryption is set when some children are decrypted by the sops plugin, with all their keys
+	Decryption *SOPSDecryption
+	// ImageUpdaterConfig are the settings of Image Updater's ConfigMap, when some children have
+	// their images updated
+	ImageUpdaterConfig map[string]string
+	// Notifications configure Argo CD Notifications, when some children have Flux alerts
+	Notifications *NotificationsConfig
+}
+
+// NewAppOfAppsMigration generates the Argo CD objects the Kustomizations and HelmReleases are
+// migrated to, in that order, annotated with the sync waves in opts, and the parent Application
+// that syncs them
+func NewAppOfAppsMigration(c client.Client, ctx context.Context, ans string, ks []kustomizev1.Kustomization, hs []helmv2.HelmRelease, exd []string, opts MigrationOptions, parent AppOfApps) (AppOfAppsMigration, error) {
+	m := AppOfAppsMigration{Repositories: map[string]argo.RepositoryCredentials{}, ImageUpdaterConfig: map[string]string{}, Notifications: &NotificationsConfig{}}
+	projects := map[string]bool{}
+	addProject := func(p *v1alpha1.AppProject) {
+		// Tenants' projects are synced before their Applications
+		if p != nil && !projects[p.Name] {
+			projects[p.Name] = true
+			argo.SetSyncWave(p, -1)
+			m.Children = append(m.Children, p)
+		}
+	}
+	secrets := map[string]bool{}
+	addSecret := func(s *apiv1.Secret) {
+		// Migrations from the same repository share the Secret
+		if s != nil && !secrets[s.Name] {
+			secrets[s.Name] = true
+			m.Secrets = append(m.Secrets, s)
+		}
+	}
+
+	for i := range ks {
+		k := &ks[i]
+		km, err := GetKustomizationMigration(c, ctx, ans, k, exd, opts)
+		if err != nil {
+			return AppOfAppsMigration{}, err
+		}
+		opts.setSyncWave(ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: k.Namespace, Name: k.Name}, km.App)
+		addProject(km.Project)
+		m.Children = append(m.Children, km.App)
+		addSecret(km.Secret)
+		addSecret(km.ClusterSecret)
+		m.Repositories[km.RepoURL] = km.Credentials
+		m.Plugin = m.Plugin || km.Plugin
+		if km.Decryption != nil {
+			if m.Decryption == nil {
+				m.Decryption = &SOPSDecryption{Keys: map[string]string{}}
+			}
+			for key, v := range km.Decryption.Keys {
+				m.Decryption.Keys[key] = v
+			}
+		}
+		for key, v := range km.ImageUpdaterConfig {
+			m.ImageUpdaterConfig[key] = v
+		}
+		m.Notifications.Merge(km.Notifications)
+
+		// The parent goes in the repository Flux was bootstrapped from
+		if parent.RepoURL == "" && k.Name == bootstrapKustomization {
+			revision, err := GitRepositoryRevision(ctx, km.Source)
+			if err != nil {
+				return AppOfAppsMigration{}, err
+			}
+			parent.RepoURL, parent.Revision = km.RepoURL, revision
+		}
+	}
+
+	for i := range hs {
+		h := &hs[i]
+		hm, err := GetHelmReleaseMigration(c, ctx, ans, h, opts)
+		if err != nil {
+			return AppOfAppsMigration{}, err
+		}
+		opts.setSyncWave(ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: h.Namespace, Name: h.Name}, hm.App)
+		addProject(hm.Project)
+		m.Children = append(m.Children, hm.App)
+		addSecret(hm.Secret)
+		addSecret(hm.ClusterSecret)
+		m.Notifications.Merge(hm.Notifications)
+		if hm.Secret != nil {
+			m.Repositories[hm.RepoURL] = hm.Credentials
+		}
+	}
+
+	if parent.RepoURL == "" {
+		return AppOfAppsMigration{}, fmt.Errorf("there's no %s Kustomization to take the repository of the app-of-apps %q from, give the repository instead", bootstrapKustomization, parent.Name)
+	}
+	if parent.Revision == "" {
+		parent.Revision = "HEAD"
+	}
+	m.Parent = argo.GenAppOfApps(parent.Name, ans, parent.RepoURL, parent.Revision, parent.Path)
+
+	// If we're here, it should have gone okay...
+	return m, nil
+}
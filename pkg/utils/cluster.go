@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// kubeConfigKeys are the keys Flux reads a kubeconfig from when secretRef has no key, in order
+var kubeConfigKeys = []string{"value", "value.yaml"}
+
+// ClusterDestination is the cluster a Flux object deploys to, in Argo CD
+type ClusterDestination struct {
+	// Server is the URL of the cluster, or Name its name in Argo CD when it's already registered
+	Server string
+	Name   string
+	// Secret registers the cluster with Argo CD, nil for the cluster Argo CD runs in and clusters
+	// that are already registered
+	Secret *apiv1.Secret
+}
+
+// ResolveDestination returns the cluster a Kustomization or HelmRelease deploys to. Flux objects
+// with a spec.kubeConfig deploy to a remote cluster: it's the Argo CD cluster clusterNames maps the
+// kubeconfig Secret to, by "namespace/name" or "name", or else a new cluster with the server and
+// credentials of the kubeconfig.
+func ResolveDestination(c client.Client, ctx context.Context, ans string, obj client.Object, clusterNames map[string]string) (ClusterDestination, error) {
+	kubeConfig := kubeConfigOf(obj)
+	if kubeConfig == nil {
+		return ClusterDestination{Server: argo.InClusterServer}, nil
+	}
+
+	// The cluster may already be registered with Argo CD
+	ref := kubeConfig.SecretRef
+	for _, key := range []string{obj.GetNamespace() + "/" + ref.Name, ref.Name} {
+		if name, ok := clusterNames[key]; ok {
+			return ClusterDestination{Name: name}, nil
+		}
+	}
+
+	// Get the kubeconfig Flux deploys with
+	secret := &apiv1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name}, secret); err != nil {
+		return ClusterDestination{}, fmt.Errorf("getting the kubeconfig of %s %s/%s, register the cluster with Argo CD and map Secret %q to it instead: %w", kindOf(c, obj), obj.GetNamespace(), obj.GetName(), ref.Name, err)
+	}
+	keys := kubeConfigKeys
+	if ref.Key != "" {
+		keys = []string{ref.Key}
+	}
+	var kubeconfig []byte
+	data := secretData(secret)
+	for _, key := range keys {
+		if v, ok := data[key]; ok {
+			kubeconfig = []byte(v)
+			break
+		}
+	}
+	if kubeconfig == nil {
+		return ClusterDestination{}, fmt.Errorf("Secret %s/%s has no kubeconfig in %v", secret.Namespace, secret.Name, keys)
+	}
+
+	server, config, err := NewClusterConfig(ctx, kubeconfig)
+	if err != nil {
+		return ClusterDestination{}, fmt.Errorf("reading the kubeconfig in Secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+
+	// Every Flux object that deploys to the same server shares the cluster
+	clusterSecret, err := argo.GenClusterSecret("mta-cluster-"+shortHash(server), ans, SanitizeName(secret.Namespace+"-"+secret.Name), server, config)
+	if err != nil {
+		return ClusterDestination{}, err
+	}
+
+	// If we're here, it should have gone okay...
+	return ClusterDestination{Server: server, Secret: clusterSecret}, nil
+}
+
+// NewClusterConfig returns the server of the current context of a kubeconfig, and how Argo CD
+// connects to it: with a bearer token, basic auth, a client certificate or an exec plugin. The
+// kubeconfig has to be self-contained, Argo CD can't read files referenced in it.
+func NewClusterConfig(ctx context.Context, kubeconfig []byte) (string, v1alpha1.ClusterConfig, error) {
+	cfg, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return "", v1alpha1.ClusterConfig{}, err
+	}
+
+	// Use the current context, or the only one there is
+	contextName := cfg.CurrentContext
+	if contextName == "" && len(cfg.Contexts) == 1 {
+		for n := range cfg.Contexts {
+			contextName = n
+		}
+	}
+	kubeContext, ok := cfg.Contexts[contextName]
+	if !ok {
+		return "", v1alpha1.ClusterConfig{}, fmt.Errorf("the kubeconfig has no current context")
+	}
+	cluster, ok := cfg.Clusters[kubeContext.Cluster]
+	if !ok {
+		return "", v1alpha1.ClusterConfig{}, fmt.Errorf("the kubeconfig has no cluster %q", kubeContext.Cluster)
+	}
+	if cluster.CertificateAuthority != "" {
+		return "", v1alpha1.ClusterConfig{}, fmt.Errorf("the CA of cluster %q is in a file, %s. Put it in certificate-authority-data instead", kubeContext.Cluster, cluster.CertificateAuthority)
+	}
+
+	config := v1alpha1.ClusterConfig{
+		TLSClientConfig: v1alpha1.TLSClientConfig{
+			Insecure:   cluster.InsecureSkipTLSVerify,
+			ServerName: cluster.TLSServerName,
+			CAData:     cluster.CertificateAuthorityData,
+		},
+	}
+
+	user, ok := cfg.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		LoggerFrom(ctx).Warn("The kubeconfig of cluster " + cluster.Server + " has no user, Argo CD connects to it anonymously")
+		return cluster.Server, config, nil
+	}
+	if user.TokenFile != "" || user.ClientCertificate != "" || user.ClientKey != "" {
+		return "", v1alpha1.ClusterConfig{}, fmt.Errorf("the credentials of user %q are in files. Put them in the kubeconfig instead", kubeContext.AuthInfo)
+	}
+	if user.AuthProvider != nil {
+		return "", v1alpha1.ClusterConfig{}, fmt.Errorf("user %q uses the %s auth provider, which Argo CD doesn't support. Use a token or an exec plugin instead", kubeContext.AuthInfo, user.AuthProvider.Name)
+	}
+
+	config.BearerToken = user.Token
+	config.Username = user.Username
+	config.Password = user.Password
+	config.CertData = user.ClientCertificateData
+	config.KeyData = user.ClientKeyData
+	if user.Exec != nil {
+		config.ExecProviderConfig = &v1alpha1.ExecProviderConfig{
+			Command:     user.Exec.Command,
+			Args:        user.Exec.Args,
+			APIVersion:  user.Exec.APIVersion,
+			InstallHint: user.Exec.InstallHint,
+		}
+		for _, e := range user.Exec.Env {
+			if config.ExecProviderConfig.Env == nil {
+				config.ExecProviderConfig.Env = map[string]string{}
+			}
+			config.ExecProviderConfig.Env[e.Name] = e.Value
+		}
+		LoggerFrom(ctx).Warn("Cluster " + cluster.Server + " is connected to with the " + user.Exec.Command + " exec plugin, add it to the Argo CD application controller and server images")
+	}
+
+	// If we're here, it should have gone okay...
+	return cluster.Server, config, nil
+}
+
+// kubeConfigOf returns the kubeconfig a Kustomization or HelmRelease deploys with, nil for the
+// cluster Flux runs in
+func kubeConfigOf(obj client.Object) *meta.KubeConfigReference {
+	switch o := obj.(type) {
+	case *kustomizev1.Kustomization:
+		return o.Spec.KubeConfig
+	case *helmv2.HelmRelease:
+		return o.Spec.KubeConfig
+	}
+	return nil
+}
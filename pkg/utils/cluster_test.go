@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+)
+
+// kubeconfig returns a kubeconfig for https://remote.example.com with the user's fields
+func kubeconfig(user string) string {
+	return `
+apiVersion: v1
+kind: Config
+current-context: remote
+contexts:
+- name: remote
+  context: {cluster: remote, user: remote}
+clusters:
+- name: remote
+  cluster: {server: "https://remote.example.com", certificate-authority-data: Y2E=}
+users:
+- name: remote
+  user: ` + user + `
+`
+}
+
+func TestNewClusterConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           string
+		expectedConfig v1alpha1.ClusterConfig
+		expectedError  string
+	}{
+		{
+			name: "Bearer token",
+			user: `{token: secret}`,
+			expectedConfig: v1alpha1.ClusterConfig{
+				BearerToken:     "secret",
+				TLSClientConfig: v1alpha1.TLSClientConfig{CAData: []byte("ca")},
+			},
+		},
+		{
+			name: "Client certificate",
+			user: `{client-certificate-data: Y2VydA==, client-key-data: a2V5}`,
+			expectedConfig: v1alpha1.ClusterConfig{
+				TLSClientConfig: v1alpha1.TLSClientConfig{CAData: []byte("ca"), CertData: []byte("cert"), KeyData: []byte("key")},
+			},
+		},
+		{
+			name: "Exec plugin",
+			user: `{exec: {apiVersion: client.authentication.k8s.io/v1beta1, command: aws, args: [eks, get-token], env: [{name: AWS_REGION, value: eu-west-1}]}}`,
+			expectedConfig: v1alpha1.ClusterConfig{
+				TLSClientConfig: v1alpha1.TLSClientConfig{CAData: []byte("ca")},
+				ExecProviderConfig: &v1alpha1.ExecProviderConfig{
+					Command:    "aws",
+					Args:       []string{"eks", "get-token"},
+					Env:        map[string]string{"AWS_REGION": "eu-west-1"},
+					APIVersion: "client.authentication.k8s.io/v1beta1",
+				},
+			},
+		},
+		{
+			name:          "Auth provider",
+			user:          `{auth-provider: {name: gcp}}`,
+			expectedError: `user "remote" uses the gcp auth provider, which Argo CD doesn't support. Use a token or an exec plugin instead`,
+		},
+		{
+			name:          "Token in a file",
+			user:          `{tokenFile: /var/run/token}`,
+			expectedError: `the credentials of user "remote" are in files. Put them in the kubeconfig instead`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, config, err := NewClusterConfig(context.TODO(), []byte(kubeconfig(tt.user)))
+			if tt.expectedError != "" {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				assert.Equal(t, err.Error(), tt.expectedError)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, server, "https://remote.example.com")
+			assert.Equal(t, config, tt.expectedConfig)
+		})
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+	objs, err := DecodeManifests(strings.NewReader(`
+apiVersion: v1
+kind: Secret
+metadata: {name: remote-kubeconfig, namespace: apps}
+data: {value: ` + base64.StdEncoding.EncodeToString([]byte(kubeconfig(`{token: secret}`))) + `}
+---
+apiVersion: v1
+kind: Secret
+metadata: {name: remote-kubeconfig, namespace: infra}
+stringData:
+  value: |
+` + strings.ReplaceAll(kubeconfig(`{token: secret}`), "\n", "\n    ") + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewOfflineClient(tenantScheme(), objs...)
+
+	tests := []struct {
+		name           string
+		namespace      string
+		kubeConfig     string
+		clusterNames   map[string]string
+		expectedServer string
+		expectedName   string
+		expectedSecret bool
+	}{
+		{
+			name:           "In cluster",
+			expectedServer: argo.InClusterServer,
+		},
+		{
+			name:           "Kubeconfig Secret",
+			kubeConfig:     "remote-kubeconfig",
+			expectedServer: "https://remote.example.com",
+			expectedSecret: true,
+		},
+		{
+			name:           "Kubeconfig Secret with stringData",
+			namespace:      "infra",
+			kubeConfig:     "remote-kubeconfig",
+			expectedServer: "https://remote.example.com",
+			expectedSecret: true,
+		},
+		{
+			name:         "Registered cluster",
+			kubeConfig:   "remote-kubeconfig",
+			clusterNames: map[string]string{"apps/remote-kubeconfig": "production"},
+			expectedName: "production",
+		},
+		{
+			name:         "Registered cluster by Secret name",
+			kubeConfig:   "elsewhere",
+			clusterNames: map[string]string{"elsewhere": "staging"},
+			expectedName: "staging",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := "apps"
+			if tt.namespace != "" {
+				namespace = tt.namespace
+			}
+			k := &kustomizev1.Kustomization{}
+			k.SetName("app")
+			k.SetNamespace(namespace)
+			if tt.kubeConfig != "" {
+				k.Spec.KubeConfig = &meta.KubeConfigReference{SecretRef: meta.SecretKeyReference{Name: tt.kubeConfig}}
+			}
+
+			dest, err := ResolveDestination(c, context.TODO(), "argocd", k, tt.clusterNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, dest.Server, tt.expectedServer)
+			assert.Equal(t, dest.Name, tt.expectedName)
+			assert.Equal(t, dest.Secret != nil, tt.expectedSecret)
+			if dest.Secret == nil {
+				return
+			}
+			assert.Equal(t, dest.Secret.Namespace, "argocd")
+			assert.Equal(t, dest.Secret.Labels["argocd.argoproj.io/secret-type"], "cluster")
+			assert.Equal(t, dest.Secret.StringData["name"], namespace+"-remote-kubeconfig")
+			assert.Equal(t, dest.Secret.StringData["server"], "https://remote.example.com")
+			config := v1alpha1.ClusterConfig{}
+			if err := json.Unmarshal([]byte(dest.Secret.StringData["config"]), &config); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, config.BearerToken, "secret")
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigMapMergeFunc combines the value of a key that's already in a ConfigMap with the value being added
+type ConfigMapMergeFunc func(existing string, added string) string
+
+// AppendMissingLines adds the lines that aren't there yet, for line based lists like SSH known hosts
+func AppendMissingLines(existing string, added string) string {
+	have := map[string]bool{}
+	for _, l := range strings.Split(existing, "\n") {
+		have[strings.TrimSpace(l)] = true
+	}
+
+	merged := strings.TrimRight(existing, "\n")
+	for _, l := range strings.Split(added, "\n") {
+		if strings.TrimSpace(l) == "" || have[strings.TrimSpace(l)] {
+			continue
+		}
+		if merged != "" {
+			merged += "\n"
+		}
+		merged += l
+		have[strings.TrimSpace(l)] = true
+	}
+	return merged + "\n"
+}
+
+// AppendIfMissing adds the whole value unless it's already there, for PEM bundles
+func AppendIfMissing(existing string, added string) string {
+	if strings.Contains(existing, strings.TrimSpace(added)) {
+		return existing
+	}
+	return strings.TrimRight(existing, "\n") + "\n" + strings.TrimLeft(added, "\n")
+}
+
+// ReplaceValue uses the value being added, for settings where there can only be one
+func ReplaceValue(existing string, added string) string {
+	return added
+}
+
+// MergeConfigMap adds data to a ConfigMap that may already be there, like the ones Argo CD is
+// configured with. It's created if it doesn't exist, otherwise the keys it already has are combined
+// with merge and only the changed keys are patched. Rollback deletes the ConfigMap if it was
+// created, or puts the changed keys back as they were.
+func (t *MigrationTransaction) MergeConfigMap(ns string, name string, data map[string]string, merge ConfigMapMergeFunc) error {
+	existing := &apiv1.ConfigMap{}
+	err := t.client.Get(t.ctx, types.NamespacedName{Namespace: ns, Name: name}, existing)
+	if apierrors.IsNotFound(err) {
+		cm := &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Data:       data,
+		}
+		cm.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("ConfigMap"))
+		return t.CreateIfMissing(cm)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Other migrations may have created it, keep it from being deleted when they're rolled back
+	if err := t.share("use", existing, false); err != nil {
+		return err
+	}
+
+	// Only patch what changes, and keep what was there before so it can be put back
+	changed := map[string]interface{}{}
+	previous := map[string]interface{}{}
+	for k, v := range data {
+		old, ok := existing.Data[k]
+		merged := v
+		if ok {
+			merged = merge(old, v)
+		}
+		if ok && merged == old {
+			continue
+		}
+
+		changed[k] = merged
+		previous[k] = nil
+		if ok {
+			previous[k] = old
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"data": changed})
+	if err != nil {
+		return err
+	}
+	undo, err := json.Marshal(map[string]interface{}{"data": previous})
+	if err != nil {
+		return err
+	}
+	return t.Patch("merge", existing, client.RawPatch(types.MergePatchType, patch), client.RawPatch(types.MergePatchType, undo))
+}
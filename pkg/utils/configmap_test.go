@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMergeConfigMap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	// Argo CD's known hosts, with GitHub already in them
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace("argocd")
+	cm.SetName("argocd-ssh-known-hosts-cm")
+	unstructured.SetNestedStringMap(cm.Object, map[string]string{"ssh_known_hosts": "github.com ssh-ed25519 AAAA\n"}, "data")
+
+	c := NewOfflineClient(scheme, cm)
+	ctx := context.TODO()
+	tx := NewMigrationTransaction(c, ctx)
+
+	// GitHub is only added once
+	err := tx.MergeConfigMap("argocd", "argocd-ssh-known-hosts-cm", map[string]string{"ssh_known_hosts": "github.com ssh-ed25519 AAAA\ngitlab.com ssh-ed25519 BBBB"}, AppendMissingLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &apiv1.ConfigMap{}
+	c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "argocd-ssh-known-hosts-cm"}, got)
+	assert.Equal(t, got.Data["ssh_known_hosts"], "github.com ssh-ed25519 AAAA\ngitlab.com ssh-ed25519 BBBB\n")
+
+	// A ConfigMap that isn't there is created
+	err = tx.MergeConfigMap("argocd", "argocd-tls-certs-cm", map[string]string{"gitlab.example.com": "CA"}, AppendIfMissing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = &apiv1.ConfigMap{}
+	c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "argocd-tls-certs-cm"}, got)
+	assert.Equal(t, got.Data["gitlab.example.com"], "CA")
+
+	// Rolling back puts the known hosts back and deletes the new ConfigMap
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	got = &apiv1.ConfigMap{}
+	c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "argocd-ssh-known-hosts-cm"}, got)
+	assert.Equal(t, got.Data["ssh_known_hosts"], "github.com ssh-ed25519 AAAA\n")
+	err = c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "argocd-tls-certs-cm"}, &apiv1.ConfigMap{})
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+)
+
+// ObjectRef is a Kustomization or HelmRelease in the dependency graph
+type ObjectRef struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// String returns Kind/namespace/name
+func (r ObjectRef) String() string {
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// Dependency is an entry of dependsOn
+type Dependency struct {
+	ObjectRef
+	// Missing is set when there's no such object, CrossNamespace when it's in another namespace than
+	// the object that depends on it
+	Missing        bool
+	CrossNamespace bool
+}
+
+// String returns the dependency the way the scan shows it, with what's wrong with it
+func (d Dependency) String() string {
+	s := d.Name
+	if d.CrossNamespace {
+		s = d.Namespace + "/" + d.Name + " (cross-namespace)"
+	}
+	if d.Missing {
+		s += " (missing)"
+	}
+	return s
+}
+
+// DependencyGraph is how Kustomizations and HelmReleases depend on each other with dependsOn.
+// Kustomizations only depend on Kustomizations, and HelmReleases on HelmReleases.
+type DependencyGraph struct {
+	objects []ObjectRef
+	deps    map[ObjectRef][]Dependency
+}
+
+// NewDependencyGraph builds the dependency graph of the Kustomizations and HelmReleases
+func NewDependencyGraph(ks []kustomizev1.Kustomization, hs []helmv2.HelmRelease) *DependencyGraph {
+	g := &DependencyGraph{deps: map[ObjectRef][]Dependency{}}
+	refs := map[ObjectRef][]meta.NamespacedObjectReference{}
+	for _, k := range ks {
+		ref := ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: k.Namespace, Name: k.Name}
+		g.objects = append(g.objects, ref)
+		refs[ref] = k.Spec.DependsOn
+	}
+	for _, h := range hs {
+		ref := ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: h.Namespace, Name: h.Name}
+		g.objects = append(g.objects, ref)
+		refs[ref] = h.Spec.DependsOn
+	}
+
+	for _, ref := range g.objects {
+		for _, r := range refs[ref] {
+			// dependsOn defaults to the namespace of the object it's in
+			d := Dependency{ObjectRef: ObjectRef{Kind: ref.Kind, Namespace: r.Namespace, Name: r.Name}}
+			if d.Namespace == "" {
+				d.Namespace = ref.Namespace
+			}
+			_, found := refs[d.ObjectRef]
+			d.Missing = !found
+			d.CrossNamespace = d.Namespace != ref.Namespace
+			g.deps[ref] = append(g.deps[ref], d)
+		}
+	}
+
+	return g
+}
+
+// DependsOn returns the dependencies of an object
+func (g *DependencyGraph) DependsOn(ref ObjectRef) []Dependency {
+	return g.deps[ref]
+}
+
+// Waves returns the Argo CD sync wave of each object: 0 when it depends on nothing, otherwise one
+// more than the latest wave it depends on. Missing dependencies are left out, and a cycle is an
+// error, there's no order Argo CD could sync it in.
+func (g *DependencyGraph) Waves() (map[ObjectRef]int, error) {
+	waves := map[ObjectRef]int{}
+	visiting := map[ObjectRef]bool{}
+
+	var visit func(ref ObjectRef, path []ObjectRef) (int, error)
+	visit = func(ref ObjectRef, path []ObjectRef) (int, error) {
+		if wave, ok := waves[ref]; ok {
+			return wave, nil
+		}
+		path = append(path, ref)
+		if visiting[ref] {
+			cycle := []string{}
+			for _, p := range path[indexOf(path, ref):] {
+				cycle = append(cycle, p.String())
+			}
+			return 0, fmt.Errorf("dependsOn has a cycle: %s", strings.Join(cycle, " -> "))
+		}
+		visiting[ref] = true
+
+		wave := 0
+		for _, d := range g.deps[ref] {
+			if d.Missing {
+				continue
+			}
+			w, err := visit(d.ObjectRef, path)
+			if err != nil {
+				return 0, err
+			}
+			if w+1 > wave {
+				wave = w + 1
+			}
+		}
+
+		visiting[ref] = false
+		waves[ref] = wave
+		return wave, nil
+	}
+
+	for _, ref := range g.objects {
+		if _, err := visit(ref, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return waves, nil
+}
+
+// Ordered returns the objects in the order they're migrated in, by sync wave and otherwise in the
+// order they were added to the graph
+func (g *DependencyGraph) Ordered(waves map[ObjectRef]int) []ObjectRef {
+	ordered := append([]ObjectRef{}, g.objects...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return waves[ordered[i]] < waves[ordered[j]]
+	})
+	return ordered
+}
+
+// indexOf returns where ref first is in refs
+func indexOf(refs []ObjectRef, ref ObjectRef) int {
+	for i, r := range refs {
+		if r == ref {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	kustomization := func(name string, dependsOn ...meta.NamespacedObjectReference) kustomizev1.Kustomization {
+		k := kustomizev1.Kustomization{}
+		k.SetNamespace("flux-system")
+		k.SetName(name)
+		k.Spec.DependsOn = dependsOn
+		return k
+	}
+	helmRelease := func(name string, dependsOn ...meta.NamespacedObjectReference) helmv2.HelmRelease {
+		h := helmv2.HelmRelease{}
+		h.SetNamespace("flux-system")
+		h.SetName(name)
+		h.Spec.DependsOn = dependsOn
+		return h
+	}
+	ref := func(kind string, name string) ObjectRef {
+		return ObjectRef{Kind: kind, Namespace: "flux-system", Name: name}
+	}
+
+	tests := []struct {
+		name          string
+		ks            []kustomizev1.Kustomization
+		hs            []helmv2.HelmRelease
+		expectedWaves map[ObjectRef]int
+		expectedOrder []ObjectRef
+		expectedErr   string
+	}{
+		{
+			name: "when there are no dependencies",
+			ks:   []kustomizev1.Kustomization{kustomization("apps")},
+			hs:   []helmv2.HelmRelease{helmRelease("podinfo")},
+			expectedWaves: map[ObjectRef]int{
+				ref("Kustomization", "apps"):  0,
+				ref("HelmRelease", "podinfo"): 0,
+			},
+			expectedOrder: []ObjectRef{ref("Kustomization", "apps"), ref("HelmRelease", "podinfo")},
+		},
+		{
+			name: "when there is a chain of dependencies",
+			ks: []kustomizev1.Kustomization{
+				kustomization("apps", meta.NamespacedObjectReference{Name: "infra"}, meta.NamespacedObjectReference{Name: "crds"}),
+				kustomization("infra", meta.NamespacedObjectReference{Name: "crds"}),
+				kustomization("crds"),
+			},
+			hs: []helmv2.HelmRelease{
+				helmRelease("issuers", meta.NamespacedObjectReference{Name: "cert-manager", Namespace: "flux-system"}),
+				helmRelease("cert-manager"),
+			},
+			expectedWaves: map[ObjectRef]int{
+				ref("Kustomization", "apps"):       2,
+				ref("Kustomization", "infra"):      1,
+				ref("Kustomization", "crds"):       0,
+				ref("HelmRelease", "issuers"):      1,
+				ref("HelmRelease", "cert-manager"): 0,
+			},
+			expectedOrder: []ObjectRef{
+				ref("Kustomization", "crds"), ref("HelmRelease", "cert-manager"),
+				ref("Kustomization", "infra"), ref("HelmRelease", "issuers"),
+				ref("Kustomization", "apps"),
+			},
+		},
+		{
+			name: "when a dependency is missing",
+			ks:   []kustomizev1.Kustomization{kustomization("apps", meta.NamespacedObjectReference{Name: "infra"})},
+			expectedWaves: map[ObjectRef]int{
+				ref("Kustomization", "apps"): 0,
+			},
+			expectedOrder: []ObjectRef{ref("Kustomization", "apps")},
+		},
+		{
+			name: "when there is a cycle",
+			ks: []kustomizev1.Kustomization{
+				kustomization("apps", meta.NamespacedObjectReference{Name: "infra"}),
+				kustomization("infra", meta.NamespacedObjectReference{Name: "apps"}),
+			},
+			expectedErr: "dependsOn has a cycle: Kustomization/flux-system/apps -> Kustomization/flux-system/infra -> Kustomization/flux-system/apps",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewDependencyGraph(tt.ks, tt.hs)
+			waves, err := g.Waves()
+			if tt.expectedErr != "" {
+				assert.Equal(t, err.Error(), tt.expectedErr)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, waves, tt.expectedWaves)
+			assert.Equal(t, g.Ordered(waves), tt.expectedOrder)
+		})
+	}
+}
+
+func TestDependencyString(t *testing.T) {
+	ks := []kustomizev1.Kustomization{{}, {}}
+	ks[0].SetNamespace("flux-system")
+	ks[0].SetName("apps")
+	ks[0].Spec.DependsOn = []meta.NamespacedObjectReference{{Name: "infra"}, {Name: "db", Namespace: "data"}, {Name: "shared", Namespace: "platform"}}
+	ks[1].SetNamespace("platform")
+	ks[1].SetName("shared")
+
+	deps := []string{}
+	for _, d := range NewDependencyGraph(ks, nil).DependsOn(ObjectRef{Kind: "Kustomization", Namespace: "flux-system", Name: "apps"}) {
+		deps = append(deps, d.String())
+	}
+	assert.Equal(t, deps, []string{"infra (missing)", "data/db (cross-namespace) (missing)", "platform/shared (cross-namespace)"})
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// filterFields are the fields a ScanFilter's field selector can match, Flux's CRDs can only be
+// listed by name and namespace so they're matched client-side
+var filterFields = []string{"metadata.name", "metadata.namespace", "spec.suspend", "spec.sourceRef.kind", "spec.sourceRef.name", "spec.targetNamespace"}
+
+// filterKinds are the kinds a ScanFilter can be limited to
+var filterKinds = []string{kustomizev1.KustomizationKind, helmv2.HelmReleaseKind, autov1beta1.ImageUpdateAutomationKind}
+
+// ScanFilter picks the Flux objects scan lists and migrates, so they can be moved a team or an
+// environment at a time
+type ScanFilter struct {
+	// Namespace is the only namespace listed, empty for all of them
+	Namespace string
+	// Selector is matched against the labels when listing, FieldSelector against filterFields
+	// after
+	Selector      labels.Selector
+	FieldSelector fields.Selector
+	// Include and Exclude are globs matched against the name, or namespace/name when they have a
+	// slash. An object has to match one of Include, if there are any, and none of Exclude.
+	Include []string
+	Exclude []string
+	// Kinds are the kinds listed, empty for all of them
+	Kinds []string
+}
+
+// NewScanFilter returns the filter from the values of the scan flags, checking all of them
+func NewScanFilter(namespace, selector, fieldSelector string, include, exclude, kinds []string) (*ScanFilter, error) {
+	f := &ScanFilter{Namespace: namespace, Include: include, Exclude: exclude}
+
+	var err error
+	if f.Selector, err = labels.Parse(selector); err != nil {
+		return nil, fmt.Errorf("parsing the label selector %q: %w", selector, err)
+	}
+	if f.FieldSelector, err = fields.ParseSelector(fieldSelector); err != nil {
+		return nil, fmt.Errorf("parsing the field selector %q: %w", fieldSelector, err)
+	}
+	for _, r := range f.FieldSelector.Requirements() {
+		if !slices.Contains(filterFields, r.Field) {
+			return nil, fmt.Errorf("field selector %q isn't supported, use %s", r.Field, strings.Join(filterFields, ", "))
+		}
+	}
+
+	// Globs are checked now, path.Match only complains about a bad pattern when it gets to it
+	for _, g := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad glob %q: %w", g, err)
+		}
+	}
+
+	// Kinds are matched in any case
+	for _, k := range kinds {
+		kind := ""
+		for _, fk := range filterKinds {
+			if strings.EqualFold(k, fk) {
+				kind = fk
+			}
+		}
+		if kind == "" {
+			return nil, fmt.Errorf("kind %q can't be scanned, use %s", k, strings.Join(filterKinds, ", "))
+		}
+		f.Kinds = append(f.Kinds, kind)
+	}
+
+	// If we're here, it should have gone okay...
+	return f, nil
+}
+
+// IsEmpty tells if the filter lets everything through, so everything is migrated
+func (f *ScanFilter) IsEmpty() bool {
+	return f.Namespace == "" && f.Selector.Empty() && f.FieldSelector.Empty() && len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.Kinds) == 0
+}
+
+// ListOptions returns the options to list with, the namespace and label selector
+func (f *ScanFilter) ListOptions() []client.ListOption {
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: f.Selector}}
+	if f.Namespace != "" {
+		opts = append(opts, client.InNamespace(f.Namespace))
+	}
+	return opts
+}
+
+// HasKind tells if objects of a kind are listed
+func (f *ScanFilter) HasKind(kind string) bool {
+	return len(f.Kinds) == 0 || slices.Contains(f.Kinds, kind)
+}
+
+// Kustomizations returns the Kustomizations that get through the filter
+func (f *ScanFilter) Kustomizations(ks []kustomizev1.Kustomization) []kustomizev1.Kustomization {
+	filtered := []kustomizev1.Kustomization{}
+	for _, k := range ks {
+		set := fields.Set{
+			"spec.suspend":         strconv.FormatBool(k.Spec.Suspend),
+			"spec.sourceRef.kind":  k.Spec.SourceRef.Kind,
+			"spec.sourceRef.name":  k.Spec.SourceRef.Name,
+			"spec.targetNamespace": k.Spec.TargetNamespace,
+		}
+		if f.matches(kustomizev1.KustomizationKind, &k, set) {
+			filtered = append(filtered, k)
+		}
+	}
+	return filtered
+}
+
+// HelmReleases returns the HelmReleases that get through the filter, their sourceRef is the one
+// of the chart
+func (f *ScanFilter) HelmReleases(hs []helmv2.HelmRelease) []helmv2.HelmRelease {
+	filtered := []helmv2.HelmRelease{}
+	for _, h := range hs {
+		set := fields.Set{
+			"spec.suspend":         strconv.FormatBool(h.Spec.Suspend),
+			"spec.sourceRef.kind":  h.Spec.Chart.Spec.SourceRef.Kind,
+			"spec.sourceRef.name":  h.Spec.Chart.Spec.SourceRef.Name,
+			"spec.targetNamespace": h.Spec.TargetNamespace,
+		}
+		if f.matches(helmv2.HelmReleaseKind, &h, set) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+// ImageUpdateAutomations returns the image automations that get through the filter
+func (f *ScanFilter) ImageUpdateAutomations(as []autov1beta1.ImageUpdateAutomation) []autov1beta1.ImageUpdateAutomation {
+	filtered := []autov1beta1.ImageUpdateAutomation{}
+	for _, a := range as {
+		if f.matches(autov1beta1.ImageUpdateAutomationKind, &a, fields.Set{"spec.suspend": strconv.FormatBool(a.Spec.Suspend)}) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// matches tells if an object of a kind, with the spec fields in set, gets through the filter. The
+// namespace and labels are checked again, the objects may not have been listed with ListOptions.
+func (f *ScanFilter) matches(kind string, obj client.Object, set fields.Set) bool {
+	if !f.HasKind(kind) {
+		return false
+	}
+	if f.Namespace != "" && obj.GetNamespace() != f.Namespace {
+		return false
+	}
+	if !f.Selector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	set["metadata.name"] = obj.GetName()
+	set["metadata.namespace"] = obj.GetNamespace()
+	if !f.FieldSelector.Matches(set) {
+		return false
+	}
+
+	if len(f.Include) > 0 && !matchesGlob(f.Include, obj) {
+		return false
+	}
+	return !matchesGlob(f.Exclude, obj)
+}
+
+// matchesGlob tells if any of the globs matches the name of an object, or its namespace/name when
+// the glob has a slash
+func matchesGlob(globs []string, obj client.Object) bool {
+	for _, g := range globs {
+		name := obj.GetName()
+		if strings.Contains(g, "/") {
+			name = obj.GetNamespace() + "/" + name
+		}
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
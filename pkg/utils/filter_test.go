@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScanFilterKustomizations(t *testing.T) {
+	ks := []kustomizev1.Kustomization{
+		{ObjectMeta: metav1.ObjectMeta{Name: "flux-system", Namespace: "flux-system"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments-api", Namespace: "payments", Labels: map[string]string{"team": "payments", "env": "prod"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments-db", Namespace: "payments", Labels: map[string]string{"team": "payments", "env": "staging"}}, Spec: kustomizev1.KustomizationSpec{Suspend: true}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "search", Namespace: "search", Labels: map[string]string{"team": "search"}}},
+	}
+
+	tests := []struct {
+		name          string
+		namespace     string
+		selector      string
+		fieldSelector string
+		include       []string
+		exclude       []string
+		kinds         []string
+		expectedNames []string
+		expectedErr   bool
+	}{
+		{
+			name:          "when there are no filters",
+			expectedNames: []string{"flux-system", "payments-api", "payments-db", "search"},
+		},
+		{
+			name:          "when it's one namespace",
+			namespace:     "payments",
+			expectedNames: []string{"payments-api", "payments-db"},
+		},
+		{
+			name:          "when it's by labels",
+			selector:      "team=payments,env!=prod",
+			expectedNames: []string{"payments-db"},
+		},
+		{
+			name:          "when it's by fields",
+			fieldSelector: "spec.suspend=false,metadata.namespace!=flux-system",
+			expectedNames: []string{"payments-api", "search"},
+		},
+		{
+			name:          "when it's by globs",
+			include:       []string{"payments-*", "search/*"},
+			exclude:       []string{"*-db"},
+			expectedNames: []string{"payments-api", "search"},
+		},
+		{
+			name:          "when it's another kind",
+			kinds:         []string{"helmrelease"},
+			expectedNames: []string{},
+		},
+		{
+			name:          "when the field can't be selected",
+			fieldSelector: "status.lastAppliedRevision=main",
+			expectedErr:   true,
+		},
+		{
+			name:        "when the glob is bad",
+			include:     []string{"payments-["},
+			expectedErr: true,
+		},
+		{
+			name:        "when the kind can't be scanned",
+			kinds:       []string{"GitRepository"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewScanFilter(tt.namespace, tt.selector, tt.fieldSelector, tt.include, tt.exclude, tt.kinds)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			if err != nil {
+				return
+			}
+			names := []string{}
+			for _, k := range f.Kustomizations(ks) {
+				names = append(names, k.Name)
+			}
+			assert.Equal(t, names, tt.expectedNames)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HelmReleaseMigration is what a HelmRelease is migrated to
+type HelmReleaseMigration struct {
+	// Source is the HelmRepository or GitRepository the chart comes from
+	Source      client.Object
+	RepoURL     string
+	Credentials argo.RepositoryCredentials
+	// Secret is the repository Secret, nil for public repositories, and App the Application
+	Secret *apiv1.Secret
+	App    *v1alpha1.Application
+	// Project is the AppProject of the HelmRelease's tenant, nil if it's not in one
+	Project *v1alpha1.AppProject
+	// ClusterSecret registers the remote cluster the HelmRelease deploys to, nil if there's none or
+	// it's already registered
+	ClusterSecret *apiv1.Secret
+	// Notifications configure Argo CD Notifications to send the Flux alerts of the HelmRelease, nil
+	// if there are none
+	Notifications *NotificationsConfig
+}
+
+// GetHelmReleaseMigration gets the source, credentials and values of a HelmRelease and generates
+// the Argo CD objects it's migrated to, named with the naming in opts
+func GetHelmReleaseMigration(c client.Client, ctx context.Context, ans string, h *helmv2.HelmRelease, opts MigrationOptions) (HelmReleaseMigration, error) {
+	naming := opts.Naming
+
+	// Get the HelmRepository or GitRepository the chart comes from
+	source, err := GetHelmChartSource(c, ctx, h)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Get the secret holding the source credentials, public repos don't have one
+	secret, err := GetSourceSecret(c, ctx, source)
+	if err != nil && !(opts.Offline && apierrors.IsNotFound(err)) {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Secrets are often not kept next to the Flux manifests, so don't insist on it offline
+	if err != nil {
+		LoggerFrom(ctx).Warn("The Secret of " + source.GetName() + " was not found in the manifests. Proceeding without the repository credentials.")
+	}
+	creds, err := ChartSourceCredentials(c, ctx, source, secret)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+	repoSecret, err := GenChartSourceSecret(ctx, ans, h, source, creds, naming)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Get the values, from valuesFrom and spec.values
+	values, err := HelmReleaseValues(c, ctx, h, opts.DropSecretValues)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Generate the Argo CD Helm Application
+	helmApp, err := NewHelmApplication(ctx, ans, h, source, values, naming)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+	app, err := argo.GenArgoCdHelmApplication(helmApp)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Deploy to the cluster the HelmRelease deploys to
+	dest, err := ResolveDestination(c, ctx, ans, h, opts.ClusterNames)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+	argo.SetDestination(app, dest.Server, dest.Name)
+
+	// Keep the tenant to what it could deploy with Flux
+	project, err := AssignTenantProject(c, ctx, ans, h, app, opts.ClusterNames)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// Keep alerting whoever Flux alerts
+	notifications, err := AddNotifications(c, ctx, h, source, app)
+	if err != nil {
+		return HelmReleaseMigration{}, err
+	}
+
+	// If we're here, it should have gone okay...
+	return HelmReleaseMigration{
+		Source:        source,
+		RepoURL:       helmApp.HelmRepo,
+		Credentials:   creds,
+		Secret:        repoSecret,
+		App:           app,
+		Project:       project,
+		ClusterSecret: dest.Secret,
+		Notifications: notifications,
+	}, nil
+}
+
+// GetHelmChartSource returns the Flux source the chart of a HelmRelease comes from, a
+// HelmRepository or a GitRepository. Argo CD can't get charts from a Bucket.
+func GetHelmChartSource(c client.Client, ctx context.Context, h *helmv2.HelmRelease) (client.Object, error) {
+	// The source defaults to the HelmRelease's namespace
+	ref := h.Spec.Chart.Spec.SourceRef
+	ns := ref.Namespace
+	if ns == "" {
+		ns = h.Namespace
+	}
+
+	var source client.Object
+	switch ref.Kind {
+	case sourcev1beta2.HelmRepositoryKind:
+		source = &sourcev1beta2.HelmRepository{}
+	case sourcev1.GitRepositoryKind:
+		source = &sourcev1.GitRepository{}
+	case sourcev1beta2.BucketKind:
+		return nil, fmt.Errorf("HelmRelease %s/%s gets its chart from Bucket %s/%s, which Argo CD can't use as a source. Move the chart to a Helm or Git repository and point the HelmRelease at it before migrating", h.Namespace, h.Name, ns, ref.Name)
+	default:
+		return nil, fmt.Errorf("HelmRelease %s/%s gets its chart from an unsupported source kind %q", h.Namespace, h.Name, ref.Kind)
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Namespace: ns, Name: ref.Name}, source); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// NewHelmApplication returns the Application a HelmRelease is migrated to, with the values from
+// HelmReleaseValues. Charts from a HelmRepository are installed by name and version, charts from a
+// GitRepository by their path at the revision Flux checks out. It's named with naming.
+func NewHelmApplication(ctx context.Context, ans string, h *helmv2.HelmRelease, source client.Object, values string, naming Naming) (argo.ArgoCdHelmApplication, error) {
+	name, err := naming.HelmReleaseAppName(helmReleaseNameData(h, source))
+	if err != nil {
+		return argo.ArgoCdHelmApplication{}, err
+	}
+
+	// Install is optional
+	createNamespace := h.Spec.Install != nil && h.Spec.Install.CreateNamespace
+
+	// Flux installs the release in the HelmRelease's namespace unless it's told otherwise, tenant
+	// projects only allow the namespaces they name
+	destinationNamespace := h.Spec.TargetNamespace
+	if destinationNamespace == "" {
+		destinationNamespace = h.Namespace
+	}
+
+	app := argo.ArgoCdHelmApplication{
+		Name:                 name,
+		Namespace:            ans,
+		DestinationNamespace: destinationNamespace,
+		DestinationServer:    argo.InClusterServer,
+		Project:              "default",
+		HelmReleaseName:      h.GetReleaseName(),
+		HelmValues:           values,
+		HelmCreateNamespace:  strconv.FormatBool(createNamespace),
+	}
+
+	switch s := source.(type) {
+	case *sourcev1beta2.HelmRepository:
+		app.HelmChart = h.Spec.Chart.Spec.Chart
+		app.HelmRepo = s.Spec.URL
+		app.HelmTargetRevision = h.Spec.Chart.Spec.Version
+	case *sourcev1.GitRepository:
+		revision, err := GitRepositoryRevision(ctx, s)
+		if err != nil {
+			return argo.ArgoCdHelmApplication{}, err
+		}
+
+		// Argo CD wants the path relative to the root of the repo
+		app.HelmChartPath = strings.TrimPrefix(path.Clean("/"+h.Spec.Chart.Spec.Chart), "/")
+		app.HelmRepo = s.Spec.URL
+		app.HelmTargetRevision = revision
+	default:
+		return argo.ArgoCdHelmApplication{}, fmt.Errorf("unsupported chart source %T", source)
+	}
+
+	return app, nil
+}
+
+// GetSourceSecret returns the Secret a Flux source authenticates with, or nil if it doesn't have one
+func GetSourceSecret(c client.Client, ctx context.Context, source client.Object) (*apiv1.Secret, error) {
+	name := sourceSecretName(source)
+	if name == "" {
+		return nil, nil
+	}
+
+	secret := &apiv1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: source.GetNamespace(), Name: name}, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ChartSourceCredentials returns the Argo CD credentials for the chart source of a HelmRelease, from
+// the source's Secret. OCI registries get OCI enabled, and keep Flux's spec.insecure, for
+// registries served over plain HTTP.
+func ChartSourceCredentials(c client.Client, ctx context.Context, source client.Object, secret *apiv1.Secret) (argo.RepositoryCredentials, error) {
+	s, ok := source.(*sourcev1beta2.HelmRepository)
+	if !ok || s.Spec.Type != sourcev1beta2.HelmRepositoryTypeOCI {
+		return argo.NewRepositoryCredentials(secret), nil
+	}
+
+	creds := argo.NewRegistryCredentials(secret, s.Spec.URL)
+	insecure, err := helmRepositoryInsecure(c, ctx, s)
+	if err != nil {
+		return argo.RepositoryCredentials{}, err
+	}
+	creds.Insecure = insecure
+	return creds, nil
+}
+
+// helmRepositoryInsecure tells if a HelmRepository has spec.insecure set. The HelmRepository API
+// this is built with doesn't have the field yet, so it's read from the object as it's stored.
+func helmRepositoryInsecure(c client.Client, ctx context.Context, s *sourcev1beta2.HelmRepository) (bool, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(sourcev1beta2.GroupVersion.WithKind(sourcev1beta2.HelmRepositoryKind))
+	if err := c.Get(ctx, client.ObjectKeyFromObject(s), u); err != nil {
+		return false, err
+	}
+	insecure, _, err := unstructured.NestedBool(u.Object, "spec", "insecure")
+	return insecure, err
+}
+
+// GenChartSourceSecret generates the Argo CD repository Secret for the chart source of a
+// HelmRelease, with the credentials from ChartSourceCredentials, named with naming. It returns nil
+// when there are no credentials, Argo CD doesn't need a Secret for public repositories. OCI
+// registries always get one, Argo CD only pulls charts from them with OCI enabled on the repository.
+func GenChartSourceSecret(ctx context.Context, ans string, h *helmv2.HelmRelease, source client.Object, creds argo.RepositoryCredentials, naming Naming) (*apiv1.Secret, error) {
+	if creds == (argo.RepositoryCredentials{}) {
+		return nil, nil
+	}
+
+	name, err := naming.RepositorySecretName(helmReleaseNameData(h, source))
+	if err != nil {
+		return nil, err
+	}
+
+	switch s := source.(type) {
+	case *sourcev1.GitRepository:
+		return GenRepositorySecret(ans, name, "git", s.Spec.URL, creds), nil
+	case *sourcev1beta2.HelmRepository:
+		if creds.EnableOCI {
+			// Argo CD has no workload identity for registries
+			if s.Spec.Provider != "" && s.Spec.Provider != sourcev1beta2.GenericOCIProvider && creds.Username == "" && creds.Password == "" {
+				LoggerFrom(ctx).Warn("HelmRepository \"" + s.Name + "\" logs in to the registry with the " + s.Spec.Provider + " provider, Argo CD needs a username and password. Add them to the repository Secret \"" + name + "\"")
+			}
+			return GenRepositorySecret(ans, name, "helm", argo.TrimOCIScheme(s.Spec.URL), creds), nil
+		}
+		return GenRepositorySecret(ans, name, "helm", s.Spec.URL, creds), nil
+	}
+	return nil, nil
+}
+
+// sourceSecretName returns the name of the Secret a Flux source authenticates with, if it has one
+func sourceSecretName(source client.Object) string {
+	switch s := source.(type) {
+	case *sourcev1.GitRepository:
+		if s.Spec.SecretRef != nil {
+			return s.Spec.SecretRef.Name
+		}
+	case *sourcev1beta2.HelmRepository:
+		if s.Spec.SecretRef != nil {
+			return s.Spec.SecretRef.Name
+		}
+	}
+	return ""
+}
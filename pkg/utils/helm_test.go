@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/akuity/mta/pkg/argo"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// helmReleaseScheme is the scheme the helmrelease command reads HelmReleases with
+func helmReleaseScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	helmv2.AddToScheme(scheme)
+	kustomizev1.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+	sourcev1beta2.AddToScheme(scheme)
+	argov1alpha1.AddToScheme(scheme)
+	apiv1.AddToScheme(scheme)
+	rbacv1.AddToScheme(scheme)
+	notificationv1beta2.AddToScheme(scheme)
+	return scheme
+}
+
+func TestNewHelmApplication(t *testing.T) {
+	helmRepo := &sourcev1beta2.HelmRepository{}
+	helmRepo.Spec.URL = "https://stefanprodan.github.io/podinfo"
+
+	gitRepo := &sourcev1.GitRepository{}
+	gitRepo.Spec.URL = "https://github.com/example/charts"
+	gitRepo.Spec.Reference = &sourcev1.GitRepositoryRef{Branch: "main"}
+
+	tests := []struct {
+		name                string
+		chart               string
+		releaseName         string
+		source              client.Object
+		expectedChart       string
+		expectedPath        string
+		expectedRepo        string
+		expectedRevision    string
+		expectedReleaseName string
+	}{
+		{
+			name:                "when the chart comes from a HelmRepository",
+			chart:               "podinfo",
+			source:              helmRepo,
+			expectedChart:       "podinfo",
+			expectedRepo:        "https://stefanprodan.github.io/podinfo",
+			expectedRevision:    "6.x",
+			expectedReleaseName: "podinfo",
+		},
+		{
+			name:                "when the chart comes from a GitRepository",
+			chart:               "./charts/podinfo",
+			source:              gitRepo,
+			expectedPath:        "charts/podinfo",
+			expectedRepo:        "https://github.com/example/charts",
+			expectedRevision:    "main",
+			expectedReleaseName: "podinfo",
+		},
+		{
+			name:                "when the release is named in the HelmRelease",
+			chart:               "podinfo",
+			releaseName:         "frontend",
+			source:              helmRepo,
+			expectedChart:       "podinfo",
+			expectedRepo:        "https://stefanprodan.github.io/podinfo",
+			expectedRevision:    "6.x",
+			expectedReleaseName: "frontend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &helmv2.HelmRelease{}
+			h.SetNamespace("flux-system")
+			h.SetName("podinfo")
+			h.Spec.Chart.Spec.Chart = tt.chart
+			h.Spec.Chart.Spec.Version = "6.x"
+			h.Spec.ReleaseName = tt.releaseName
+
+			app, err := NewHelmApplication(context.TODO(), "argocd", h, tt.source, "", DefaultNaming())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, app.Name, "flux-system-podinfo")
+			assert.Equal(t, app.HelmChart, tt.expectedChart)
+			assert.Equal(t, app.HelmChartPath, tt.expectedPath)
+			assert.Equal(t, app.HelmRepo, tt.expectedRepo)
+			assert.Equal(t, app.HelmTargetRevision, tt.expectedRevision)
+			assert.Equal(t, app.HelmCreateNamespace, "false")
+			assert.Equal(t, app.DestinationNamespace, "flux-system")
+
+			// Argo CD installs the release Flux installed, whatever the Application is called
+			assert.Equal(t, app.HelmReleaseName, tt.expectedReleaseName)
+			assert.Equal(t, app.HelmReleaseName, h.GetReleaseName())
+			a, err := argo.GenArgoCdHelmApplication(app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, a.Spec.Source.Helm.ReleaseName, h.GetReleaseName())
+		})
+	}
+}
+
+func TestGetHelmChartSourceBucket(t *testing.T) {
+	h := &helmv2.HelmRelease{}
+	h.SetNamespace("flux-system")
+	h.SetName("podinfo")
+	h.Spec.Chart.Spec.SourceRef = helmv2.CrossNamespaceObjectReference{Kind: "Bucket", Name: "charts"}
+
+	_, err := GetHelmChartSource(NewOfflineClient(runtime.NewScheme()), context.TODO(), h)
+	assert.Equal(t, strings.Contains(err.Error(), "Bucket flux-system/charts"), true)
+}
+
+func TestGenChartSourceSecret(t *testing.T) {
+	ociRepo := &sourcev1beta2.HelmRepository{}
+	ociRepo.SetNamespace("flux-system")
+	ociRepo.SetName("charts")
+	ociRepo.Spec.Type = sourcev1beta2.HelmRepositoryTypeOCI
+	ociRepo.Spec.URL = "oci://ghcr.io/example/charts"
+
+	helmRepo := &sourcev1beta2.HelmRepository{}
+	helmRepo.SetNamespace("flux-system")
+	helmRepo.SetName("podinfo")
+	helmRepo.Spec.URL = "https://stefanprodan.github.io/podinfo"
+
+	basicAuth := &apiv1.Secret{Data: map[string][]byte{"username": []byte("flux"), "password": []byte("s3cr3t")}}
+
+	tests := []struct {
+		name         string
+		source       client.Object
+		secret       *apiv1.Secret
+		insecure     bool
+		expectedData map[string]string
+	}{
+		{
+			name:         "when the HelmRepository is public",
+			source:       helmRepo,
+			expectedData: nil,
+		},
+		{
+			name:   "when the HelmRepository has basic auth",
+			source: helmRepo,
+			secret: basicAuth,
+			expectedData: map[string]string{
+				"type": "helm", "url": "https://stefanprodan.github.io/podinfo", "username": "flux", "password": "s3cr3t",
+			},
+		},
+		{
+			name:   "when the OCI HelmRepository is public",
+			source: ociRepo,
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true",
+			},
+		},
+		{
+			name:   "when the OCI HelmRepository has basic auth",
+			source: ociRepo,
+			secret: basicAuth,
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true", "username": "flux", "password": "s3cr3t",
+			},
+		},
+		{
+			name:     "when the OCI HelmRepository is served over plain HTTP",
+			source:   ociRepo,
+			insecure: true,
+			expectedData: map[string]string{
+				"type": "helm", "url": "ghcr.io/example/charts", "enableOCI": "true", "insecure": "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &helmv2.HelmRelease{}
+			h.SetNamespace("flux-system")
+			h.SetName("podinfo")
+
+			// The source as it's written, spec.insecure isn't in the typed HelmRepository
+			raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := &unstructured.Unstructured{Object: raw}
+			u.SetGroupVersionKind(sourcev1beta2.GroupVersion.WithKind(sourcev1beta2.HelmRepositoryKind))
+			if tt.insecure {
+				unstructured.SetNestedField(u.Object, true, "spec", "insecure")
+			}
+
+			creds, err := ChartSourceCredentials(NewOfflineClient(helmReleaseScheme(), u), context.TODO(), tt.source, tt.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := GenChartSourceSecret(context.TODO(), "argocd", h, tt.source, creds, DefaultNaming())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expectedData == nil {
+				assert.Equal(t, s == nil, true)
+				return
+			}
+			assert.Equal(t, s.Name, "mta-repo-"+helmReleaseNameData(h, tt.source).RepoHash)
+			assert.Equal(t, s.StringData, tt.expectedData)
+		})
+	}
+}
+
+func TestMigrateHelmReleaseNameTemplate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+	sourcev1beta2.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+	argov1alpha1.AddToScheme(scheme)
+
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(sourcev1beta2.GroupVersion.WithKind(sourcev1beta2.HelmRepositoryKind))
+	repo.SetNamespace("flux-system")
+	repo.SetName("podinfo")
+	unstructured.SetNestedField(repo.Object, "https://stefanprodan.github.io/podinfo", "spec", "url")
+
+	hr := &unstructured.Unstructured{}
+	hr.SetGroupVersionKind(helmv2.GroupVersion.WithKind(helmv2.HelmReleaseKind))
+	hr.SetNamespace("flux-system")
+	hr.SetName("podinfo")
+	unstructured.SetNestedField(hr.Object, "podinfo", "spec", "chart", "spec", "chart")
+	unstructured.SetNestedField(hr.Object, sourcev1beta2.HelmRepositoryKind, "spec", "chart", "spec", "sourceRef", "kind")
+	unstructured.SetNestedField(hr.Object, "podinfo", "spec", "chart", "spec", "sourceRef", "name")
+
+	// What Flux installed, a release named after the HelmRelease
+	svc := &unstructured.Unstructured{}
+	svc.SetAPIVersion("v1")
+	svc.SetKind("Service")
+	svc.SetNamespace("flux-system")
+	svc.SetName("podinfo")
+	release := `{"name":"podinfo","namespace":"flux-system","manifest":"---\napiVersion: v1\nkind: Service\nmetadata:\n  name: podinfo\n"}`
+
+	c := NewOfflineClient(scheme, repo, hr, svc, helmReleaseSecret("flux-system", "podinfo", release))
+	ctx := context.TODO()
+
+	h := helmv2.HelmRelease{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, &h); err != nil {
+		t.Fatal(err)
+	}
+	opts := MigrationOptions{Adopt: true, Naming: Naming{HelmRelease: "{{.Name}}-app"}}
+	if err := MigrateHelmReleaseToApplication(c, ctx, "argocd", h, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The template names the Application, the chart is still installed as the same release
+	app := &argov1alpha1.Application{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "podinfo-app"}, app); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, app.Spec.Source.Helm.ReleaseName, "podinfo")
+
+	// And the release's resources are tracked by the Application
+	got := &apiv1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.Labels[ArgoCDInstanceLabel], "podinfo-app")
+	assert.Equal(t, got.Annotations[ArgoCDTrackingIDAnnotation], "podinfo-app:/Service:flux-system/podinfo")
+}
@@ -0,0 +1,385 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// imagePolicyOrderDesc is the order of ImagePolicies that pick the lowest tag
+const imagePolicyOrderDesc = "desc"
+
+// ImageAutomationsFor returns the ImageUpdateAutomations that update the manifests of a
+// Kustomization: the ones that push to its GitRepository, in or above its path, and aren't
+// suspended
+func ImageAutomationsFor(c client.Client, ctx context.Context, k *kustomizev1.Kustomization) ([]autov1beta1.ImageUpdateAutomation, error) {
+	al := &autov1beta1.ImageUpdateAutomationList{}
+	err := c.List(ctx, al)
+	if isMissingKind(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	automations := []autov1beta1.ImageUpdateAutomation{}
+	for _, a := range al.Items {
+		if !a.Spec.Suspend && Automates(&a, k) {
+			automations = append(automations, a)
+		}
+	}
+	return automations, nil
+}
+
+// Automates tells if an ImageUpdateAutomation updates the manifests of a Kustomization, from the
+// same GitRepository with paths that overlap. Automations only use GitRepositories in their own
+// namespace.
+func Automates(a *autov1beta1.ImageUpdateAutomation, k *kustomizev1.Kustomization) bool {
+	if a.Spec.SourceRef.Kind != sourcev1.GitRepositoryKind || k.Spec.SourceRef.Kind != sourcev1.GitRepositoryKind {
+		return false
+	}
+	if a.Namespace != getNamespace(k.Spec.SourceRef.Namespace, k.Namespace) || a.Spec.SourceRef.Name != k.Spec.SourceRef.Name {
+		return false
+	}
+
+	// Flux updates the whole repository without a path
+	updatePath := ""
+	if a.Spec.Update != nil {
+		updatePath = cleanRepoPath(a.Spec.Update.Path)
+	}
+	kPath := cleanRepoPath(k.Spec.Path)
+	return isUnder(kPath, updatePath) || isUnder(updatePath, kPath)
+}
+
+// NewImageUpdater returns how Argo CD Image Updater updates the images an ImageUpdateAutomation
+// does: the ImagePolicies in its namespace, committed to the branch it pushes to. It also returns
+// the settings of the ImageUpdaterConfigMap for the commit author and message. Policies that can't
+// be carried over are left out with a warning.
+func NewImageUpdater(c client.Client, ctx context.Context, a *autov1beta1.ImageUpdateAutomation, gitSource *sourcev1.GitRepository) (argo.ImageUpdater, map[string]string, error) {
+	u := argo.ImageUpdater{WriteBackTarget: "kustomization"}
+
+	// Flux updates the images of any policy with a marker in the manifests, the ones next to the
+	// automation are the likely ones
+	pl := &imagev1beta2.ImagePolicyList{}
+	if err := c.List(ctx, pl, client.InNamespace(a.Namespace)); err != nil && !isMissingKind(err) {
+		return argo.ImageUpdater{}, nil, err
+	}
+	for i := range pl.Items {
+		p := &pl.Items[i]
+		repo := &imagev1beta2.ImageRepository{}
+		ref := p.Spec.ImageRepositoryRef
+		err := c.Get(ctx, types.NamespacedName{Namespace: getNamespace(ref.Namespace, p.Namespace), Name: ref.Name}, repo)
+		if apierrors.IsNotFound(err) {
+			LoggerFrom(ctx).Warn("ImageRepository \"" + ref.Name + "\" of ImagePolicy " + p.Namespace + "/" + p.Name + " was not found, it's not carried over to Image Updater")
+			continue
+		}
+		if err != nil {
+			return argo.ImageUpdater{}, nil, err
+		}
+
+		update, err := NewImageUpdate(ctx, p, repo)
+		if err != nil {
+			LoggerFrom(ctx).Warn("ImagePolicy " + p.Namespace + "/" + p.Name + " isn't carried over to Image Updater: " + err.Error())
+			continue
+		}
+		u.Images = append(u.Images, update)
+	}
+
+	// Commit where Flux checks out, and push where it pushes
+	var config map[string]string
+	if git := a.Spec.GitSpec; git != nil {
+		branch := ""
+		if git.Checkout != nil {
+			branch = git.Checkout.Reference.Branch
+		}
+		if branch == "" {
+			revision, err := GitRepositoryRevision(ctx, gitSource)
+			if err != nil {
+				return argo.ImageUpdater{}, nil, err
+			}
+			branch = revision
+		}
+		u.GitBranch = branch
+		if git.Push != nil && git.Push.Branch != "" && git.Push.Branch != branch {
+			u.GitBranch = branch + ":" + git.Push.Branch
+		}
+
+		// Flux's message template is given what it updated differently
+		message := git.Commit.MessageTemplate
+		if strings.Contains(message, "{{") {
+			LoggerFrom(ctx).Warn("The commit message template of ImageUpdateAutomation " + a.Namespace + "/" + a.Name + " uses Flux's template data, Image Updater's default message is used instead. Set git.commit-message-template in the " + argo.ImageUpdaterConfigMap + " ConfigMap with Image Updater's")
+			message = ""
+		}
+		if git.Commit.SigningKey != nil {
+			LoggerFrom(ctx).Warn("ImageUpdateAutomation " + a.Namespace + "/" + a.Name + " signs its commits, Image Updater's commits aren't signed unless it's configured to")
+		}
+		config = argo.GenImageUpdaterConfigData(git.Commit.Author.Name, git.Commit.Author.Email, message)
+	}
+
+	// If we're here, it should have gone okay...
+	return u, config, nil
+}
+
+// NewImageUpdate translates an ImagePolicy into an image Image Updater updates: semver ranges use
+// the semver strategy, ascending alphabetical and numerical orders the name strategy, and the tag
+// filter pattern the allowed tags. Image Updater can't pick the lowest tag, and orders the whole
+// tag rather than what's extracted from it.
+func NewImageUpdate(ctx context.Context, p *imagev1beta2.ImagePolicy, repo *imagev1beta2.ImageRepository) (argo.ImageUpdate, error) {
+	update := argo.ImageUpdate{
+		Alias: imageAlias(p.Name),
+		Image: repo.Spec.Image,
+	}
+
+	policy := p.Spec.Policy
+	switch {
+	case policy.SemVer != nil:
+		// The image list is comma separated, ranges can be space separated too
+		update.Strategy = "semver"
+		update.Constraint = strings.Join(strings.Fields(strings.ReplaceAll(policy.SemVer.Range, ",", " ")), " ")
+	case policy.Alphabetical != nil:
+		if policy.Alphabetical.Order == imagePolicyOrderDesc {
+			return argo.ImageUpdate{}, fmt.Errorf("Image Updater can't pick the first tag in alphabetical order")
+		}
+		update.Strategy = "name"
+	case policy.Numerical != nil:
+		if policy.Numerical.Order == imagePolicyOrderDesc {
+			return argo.ImageUpdate{}, fmt.Errorf("Image Updater can't pick the lowest numerical tag")
+		}
+		LoggerFrom(ctx).Warn("ImagePolicy " + p.Namespace + "/" + p.Name + " orders tags numerically, Image Updater orders them alphabetically, which is the same for numbers of the same length")
+		update.Strategy = "name"
+	default:
+		return argo.ImageUpdate{}, fmt.Errorf("it has no policy")
+	}
+
+	if f := p.Spec.FilterTags; f != nil && f.Pattern != "" {
+		update.AllowTags = "regexp:" + f.Pattern
+		if f.Extract != "" && f.Extract != "$0" {
+			LoggerFrom(ctx).Warn("ImagePolicy " + p.Namespace + "/" + p.Name + " orders what it extracts from the tags with " + f.Extract + ", Image Updater orders the whole tags")
+		}
+	}
+
+	// Registry credentials
+	if repo.Spec.SecretRef != nil {
+		update.PullSecret = "pullsecret:" + repo.Namespace + "/" + repo.Spec.SecretRef.Name
+	}
+	if repo.Spec.Provider != "" && repo.Spec.Provider != "generic" {
+		LoggerFrom(ctx).Warn("ImageRepository " + repo.Namespace + "/" + repo.Name + " logs in to " + repo.Spec.Provider + " with the controller's identity, configure Image Updater's registry credentials for " + repo.Spec.Image)
+	}
+
+	// If we're here, it should have gone okay...
+	return update, nil
+}
+
+// AddImageUpdater annotates what a Kustomization is migrated to, app, so Image Updater updates the
+// images the ImageUpdateAutomations of the Kustomization update. It returns the settings of the
+// ImageUpdaterConfigMap, nil if no automation updates the Kustomization.
+func AddImageUpdater(c client.Client, ctx context.Context, k *kustomizev1.Kustomization, gitSource *sourcev1.GitRepository, app client.Object) (map[string]string, error) {
+	automations, err := ImageAutomationsFor(c, ctx, k)
+	if err != nil || len(automations) == 0 {
+		return nil, err
+	}
+	if len(automations) > 1 {
+		LoggerFrom(ctx).Warn("Kustomization " + k.Namespace + "/" + k.Name + " is updated by more than one ImageUpdateAutomation, Image Updater pushes where " + automations[0].Namespace + "/" + automations[0].Name + " does")
+	}
+
+	// The images of every automation, committed like the first one does
+	var u argo.ImageUpdater
+	var config map[string]string
+	for i := range automations {
+		au, ac, err := NewImageUpdater(c, ctx, &automations[i], gitSource)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			u, config = au, ac
+			continue
+		}
+		u.Images = append(u.Images, au.Images...)
+	}
+	if len(u.Images) == 0 {
+		return nil, nil
+	}
+	argo.SetImageUpdater(app, u)
+	LoggerFrom(ctx).Warn("Kustomization " + k.Namespace + "/" + k.Name + " has its images updated by Flux, Argo CD Image Updater has to be installed to keep updating them. It only updates Kustomize and Helm Applications, the path needs a kustomization.yaml")
+
+	// If we're here, it should have gone okay...
+	if config == nil {
+		config = map[string]string{}
+	}
+	return config, nil
+}
+
+// WarnImageUpdaterConfig tells the user what to add to the ImageUpdaterConfigMap by hand. Printing
+// the ConfigMap to be applied would replace what's in it.
+func WarnImageUpdaterConfig(data map[string]string) {
+	for k, v := range data {
+		log.Warn("Set the \"", k, "\" key of the ", argo.ImageUpdaterConfigMap, " ConfigMap to: ", v)
+	}
+}
+
+// imageAlias returns the name of an ImagePolicy as an Image Updater alias, which is alphanumeric
+func imageAlias(name string) string {
+	alias := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, name)
+	if alias == "" {
+		return "image"
+	}
+	return alias
+}
+
+// cleanRepoPath returns a path in a repository without a leading "./" or "/", "" for the root
+func cleanRepoPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// isUnder tells if a path in a repository is dir or under it
+func isUnder(p string, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// AnnotateImageUpdater annotates an Application or ApplicationSet that's already there so Image
+// Updater updates its images. Rollback puts the annotations back as they were.
+func (t *MigrationTransaction) AnnotateImageUpdater(obj client.Object, u argo.ImageUpdater) error {
+	annotations := u.Annotations()
+
+	// Keep what was there before so it can be put back
+	previous := map[string]interface{}{}
+	for k := range annotations {
+		previous[k] = nil
+		if v, ok := obj.GetAnnotations()[k]; ok {
+			previous[k] = v
+		}
+	}
+
+	// The Applications an ApplicationSet generates are annotated with its template
+	annotate := func(a interface{}) map[string]interface{} {
+		p := map[string]interface{}{"metadata": map[string]interface{}{"annotations": a}}
+		if _, ok := obj.(*v1alpha1.ApplicationSet); ok {
+			p["spec"] = map[string]interface{}{"template": map[string]interface{}{"metadata": map[string]interface{}{"annotations": a}}}
+		}
+		return p
+	}
+	patch, err := json.Marshal(annotate(annotations))
+	if err != nil {
+		return err
+	}
+	undo, err := json.Marshal(annotate(previous))
+	if err != nil {
+		return err
+	}
+	return t.Patch("annotate", obj, client.RawPatch(types.MergePatchType, patch), client.RawPatch(types.MergePatchType, undo))
+}
+
+// GetMigratedApp returns the Application, or else the ApplicationSet, named name in the Argo CD
+// namespace, which a Flux object was migrated to
+func GetMigratedApp(c client.Client, ctx context.Context, ans string, name string) (client.Object, error) {
+	app := &v1alpha1.Application{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: ans, Name: name}, app)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return app, err
+	}
+
+	appSet := &v1alpha1.ApplicationSet{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: ans, Name: name}, appSet); err != nil {
+		return nil, err
+	}
+	return appSet, nil
+}
+
+// MigrateImageAutomation hands an ImageUpdateAutomation over to Argo CD Image Updater: the
+// Applications and ApplicationSets the Kustomizations it updates were migrated to are annotated,
+// Image Updater is configured to commit like Flux did, and the automation is deleted so they don't
+// both push. The Kustomizations have to be migrated first, with the naming in opts.
+func MigrateImageAutomation(c client.Client, ctx context.Context, ans string, a *autov1beta1.ImageUpdateAutomation, opts MigrationOptions) error {
+	// Get the GitRepository the automation pushes to
+	gitSource := &sourcev1.GitRepository{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: a.Namespace, Name: a.Spec.SourceRef.Name}, gitSource); err != nil {
+		return err
+	}
+	u, config, err := NewImageUpdater(c, ctx, a, gitSource)
+	if err != nil {
+		return err
+	}
+	if len(u.Images) == 0 {
+		return fmt.Errorf("ImageUpdateAutomation %s/%s has no ImagePolicies Image Updater can use", a.Namespace, a.Name)
+	}
+
+	// Get the Kustomizations it updates
+	kl := &kustomizev1.KustomizationList{}
+	if err := c.List(ctx, kl); err != nil {
+		return err
+	}
+	apps := []client.Object{}
+	for i := range kl.Items {
+		k := &kl.Items[i]
+		if !Automates(a, k) {
+			continue
+		}
+		name, err := opts.Naming.KustomizationName(kustomizationNameData(k, gitSource))
+		if err != nil {
+			return err
+		}
+		app, err := GetMigratedApp(c, ctx, ans, name)
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("Kustomization %s/%s hasn't been migrated to %q yet, migrate it before its ImageUpdateAutomation", k.Namespace, k.Name, name)
+		}
+		if err != nil {
+			return err
+		}
+		apps = append(apps, app)
+	}
+	if len(apps) == 0 {
+		return fmt.Errorf("ImageUpdateAutomation %s/%s doesn't update any Kustomization", a.Namespace, a.Name)
+	}
+
+	// Record every step so a failure can be rolled back
+	tx := NewMigrationTransaction(c, ctx).WithJournal(opts.Stores...)
+
+	// Stop Flux from pushing while Image Updater takes over
+	if err := tx.Suspend(a); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Commit the image updates like Flux did
+	if len(config) > 0 {
+		if err := tx.MergeConfigMap(ans, argo.ImageUpdaterConfigMap, config, ReplaceValue); err != nil {
+			return tx.Abort(err)
+		}
+	}
+	for _, app := range apps {
+		log.Info("Annotating " + describeObject(c, app) + " for Argo CD Image Updater")
+		if err := tx.AnnotateImageUpdater(app, u); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// Delete the ImageUpdateAutomation
+	if err := tx.Delete(a); err != nil {
+		return tx.Abort(err)
+	}
+
+	if len(opts.Stores) > 0 {
+		log.Info("Migration journal " + tx.Journal.ID + " saved, undo the migration with: mta rollback --journal " + tx.Journal.ID)
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
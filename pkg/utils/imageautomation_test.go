@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewImageUpdate(t *testing.T) {
+	tests := []struct {
+		name               string
+		policy             imagev1beta2.ImagePolicySpec
+		secretRef          *meta.LocalObjectReference
+		expectedStrategy   string
+		expectedConstraint string
+		expectedAllowTags  string
+		expectedPullSecret string
+		expectedError      bool
+	}{
+		{
+			name:               "Semver range",
+			policy:             imagev1beta2.ImagePolicySpec{Policy: imagev1beta2.ImagePolicyChoice{SemVer: &imagev1beta2.SemVerPolicy{Range: ">=1.0.0, <2.0.0"}}},
+			expectedStrategy:   "semver",
+			expectedConstraint: ">=1.0.0 <2.0.0",
+		},
+		{
+			name: "Alphabetical order with a tag filter",
+			policy: imagev1beta2.ImagePolicySpec{
+				Policy:     imagev1beta2.ImagePolicyChoice{Alphabetical: &imagev1beta2.AlphabeticalPolicy{Order: "asc"}},
+				FilterTags: &imagev1beta2.TagFilter{Pattern: "^main-[a-f0-9]+"},
+			},
+			expectedStrategy:  "name",
+			expectedAllowTags: "regexp:^main-[a-f0-9]+",
+		},
+		{
+			name:               "Numerical order with registry credentials",
+			policy:             imagev1beta2.ImagePolicySpec{Policy: imagev1beta2.ImagePolicyChoice{Numerical: &imagev1beta2.NumericalPolicy{}}},
+			secretRef:          &meta.LocalObjectReference{Name: "registry"},
+			expectedStrategy:   "name",
+			expectedPullSecret: "pullsecret:flux-system/registry",
+		},
+		{
+			name:          "Lowest tag",
+			policy:        imagev1beta2.ImagePolicySpec{Policy: imagev1beta2.ImagePolicyChoice{Numerical: &imagev1beta2.NumericalPolicy{Order: "desc"}}},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &imagev1beta2.ImagePolicy{ObjectMeta: metav1.ObjectMeta{Name: "pod-info", Namespace: "flux-system"}, Spec: tt.policy}
+			repo := &imagev1beta2.ImageRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "flux-system"},
+				Spec:       imagev1beta2.ImageRepositorySpec{Image: "ghcr.io/stefanprodan/podinfo", SecretRef: tt.secretRef},
+			}
+
+			update, err := NewImageUpdate(context.TODO(), p, repo)
+			assert.Equal(t, err != nil, tt.expectedError)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, update.Alias, "podinfo")
+			assert.Equal(t, update.Image, "ghcr.io/stefanprodan/podinfo")
+			assert.Equal(t, update.Strategy, tt.expectedStrategy)
+			assert.Equal(t, update.Constraint, tt.expectedConstraint)
+			assert.Equal(t, update.AllowTags, tt.expectedAllowTags)
+			assert.Equal(t, update.PullSecret, tt.expectedPullSecret)
+		})
+	}
+}
+
+func TestAutomates(t *testing.T) {
+	tests := []struct {
+		name       string
+		updatePath string
+		path       string
+		source     string
+		expected   bool
+	}{
+		{name: "Whole repository", path: "./apps/podinfo", source: "fleet", expected: true},
+		{name: "Kustomization under the update path", updatePath: "./apps", path: "./apps/podinfo", source: "fleet", expected: true},
+		{name: "Update path under the Kustomization", updatePath: "./apps/podinfo", path: "./", source: "fleet", expected: true},
+		{name: "Other directory", updatePath: "./infra", path: "./apps/podinfo", source: "fleet", expected: false},
+		{name: "Directory with the same prefix", updatePath: "./apps", path: "./apps-staging", source: "fleet", expected: false},
+		{name: "Other repository", path: "./apps/podinfo", source: "other", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autov1beta1.ImageUpdateAutomation{
+				ObjectMeta: metav1.ObjectMeta{Name: "fleet", Namespace: "flux-system"},
+				Spec: autov1beta1.ImageUpdateAutomationSpec{
+					SourceRef: autov1beta1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: "fleet"},
+					Update:    &autov1beta1.UpdateStrategy{Strategy: autov1beta1.UpdateStrategySetters, Path: tt.updatePath},
+				},
+			}
+			k := &kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "flux-system"},
+				Spec: kustomizev1.KustomizationSpec{
+					Path:      tt.path,
+					SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: "GitRepository", Name: tt.source},
+				},
+			}
+			assert.Equal(t, Automates(a, k), tt.expected)
+		})
+	}
+}
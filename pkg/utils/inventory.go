@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	log "github.com/sirupsen/logrus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	yaml "sigs.k8s.io/yaml"
+)
+
+// Scan output formats, besides the table
+const (
+	ScanOutputWide = "wide"
+	ScanOutputJSON = "json"
+	ScanOutputYAML = "yaml"
+	ScanOutputCSV  = "csv"
+)
+
+// ScanItem is a Flux object in the inventory mta scan outputs, with what's needed to plan its
+// migration
+type ScanItem struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// The source the manifests or chart come from, with its URL and the revision Flux checks out
+	SourceKind      string `json:"sourceKind,omitempty"`
+	SourceName      string `json:"sourceName,omitempty"`
+	SourceNamespace string `json:"sourceNamespace,omitempty"`
+	SourceURL       string `json:"sourceURL,omitempty"`
+	SourceRevision  string `json:"sourceRevision,omitempty"`
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+	Suspended       bool   `json:"suspended"`
+	// Status is its state from FluxStatus, Ready the status of the Ready condition, empty if the
+	// object hasn't been reconciled
+	Status              string `json:"status"`
+	Ready               string `json:"ready,omitempty"`
+	ReadyReason         string `json:"readyReason,omitempty"`
+	ReadyMessage        string `json:"readyMessage,omitempty"`
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+	// Wave is the sync wave it's migrated in, empty if the dependencies can't be ordered
+	Wave      string   `json:"wave,omitempty"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Blockers are what keeps it from being migrated as it is
+	Blockers []string `json:"blockers,omitempty"`
+	// Readiness is the group it's in from its blockers, and Score how ready it is from 0 to 100
+	Readiness string `json:"readiness"`
+	Score     int    `json:"score"`
+}
+
+// scanCSVHeader are the columns of the CSV output
+var scanCSVHeader = []string{"kind", "name", "namespace", "sourceKind", "sourceName", "sourceNamespace", "sourceURL", "sourceRevision", "targetNamespace", "suspended", "status", "ready", "readyReason", "readyMessage", "lastAppliedRevision", "wave", "dependsOn", "blockers", "readiness", "score"}
+
+// NewKustomizationScanItem returns the inventory entry of a Kustomization. What blocks its
+// migration is found by generating it with opts, the way it would be migrated.
+func NewKustomizationScanItem(c client.Client, ctx context.Context, ans string, k *kustomizev1.Kustomization, exd []string, opts MigrationOptions) ScanItem {
+	ref := k.Spec.SourceRef
+	item := ScanItem{
+		Kind:                kustomizev1.KustomizationKind,
+		Name:                k.Name,
+		Namespace:           k.Namespace,
+		SourceKind:          ref.Kind,
+		SourceName:          ref.Name,
+		SourceNamespace:     getNamespace(ref.Namespace, k.Namespace),
+		TargetNamespace:     k.Spec.TargetNamespace,
+		Suspended:           k.Spec.Suspend,
+		LastAppliedRevision: k.Status.LastAppliedRevision,
+		Readiness:           ReadinessSafe,
+		Score:               100,
+	}
+	item.setStatus(k.Status.Conditions)
+	item.setSource(c, ctx)
+
+	// Argo CD only gets manifests from Git
+	if ref.Kind != sourcev1.GitRepositoryKind {
+		item.block(fmt.Sprintf("Argo CD has no %s sources", ref.Kind), penaltyUnsupported)
+		return item
+	}
+	item.blockStatus(opts)
+	item.blockKustomization(k)
+	if _, unmapped, err := NewKustomizeSource(c, ctx, k); err == nil {
+		for _, u := range unmapped {
+			item.block(u, penaltyUnmapped)
+		}
+	}
+	if _, err := GetKustomizationMigration(c, quiet(ctx), ans, k, exd, opts); err != nil {
+		item.block(err.Error(), penaltyNotGenerated)
+	}
+	return item
+}
+
+// NewHelmReleaseScanItem returns the inventory entry of a HelmRelease. What blocks its migration
+// is found by generating it with opts, the way it would be migrated.
+func NewHelmReleaseScanItem(c client.Client, ctx context.Context, ans string, h *helmv2.HelmRelease, opts MigrationOptions) ScanItem {
+	ref := h.Spec.Chart.Spec.SourceRef
+	item := ScanItem{
+		Kind:                helmv2.HelmReleaseKind,
+		Name:                h.Name,
+		Namespace:           h.Namespace,
+		SourceKind:          ref.Kind,
+		SourceName:          ref.Name,
+		SourceNamespace:     getNamespace(ref.Namespace, h.Namespace),
+		TargetNamespace:     getNamespace(h.Spec.TargetNamespace, h.Namespace),
+		Suspended:           h.Spec.Suspend,
+		LastAppliedRevision: h.Status.LastAppliedRevision,
+		Readiness:           ReadinessSafe,
+		Score:               100,
+	}
+	item.setStatus(h.Status.Conditions)
+	source := item.setSource(c, ctx)
+
+	// Argo CD only gets charts from Helm and Git repositories
+	if ref.Kind != sourcev1beta2.HelmRepositoryKind && ref.Kind != sourcev1.GitRepositoryKind {
+		item.block(fmt.Sprintf("Argo CD can't get charts from a %s", ref.Kind), penaltyUnsupported)
+		return item
+	}
+
+	// Charts from a HelmRepository are at a version, from Git at the revision of the repository.
+	// OCI registries that Flux logs in to with a cloud identity need credentials in Argo CD.
+	if ref.Kind == sourcev1beta2.HelmRepositoryKind {
+		item.SourceRevision = h.Spec.Chart.Spec.Chart + "@" + firstNonEmpty(h.Spec.Chart.Spec.Version, "*")
+		if provider, _, _ := unstructured.NestedString(source.Object, "spec", "provider"); provider != "" && provider != sourcev1beta2.GenericOCIProvider {
+			item.block("its OCI registry is logged in to with the "+provider+" provider, Argo CD needs a username and password", penaltyOCIProvider)
+		}
+	}
+
+	item.blockStatus(opts)
+	item.blockHelmRelease(h, opts)
+
+	// Values from Secrets are already a blocker, see if the rest can be generated
+	opts.DropSecretValues = true
+	if _, err := GetHelmReleaseMigration(c, quiet(ctx), ans, h, opts); err != nil {
+		item.block(err.Error(), penaltyNotGenerated)
+	}
+	return item
+}
+
+// NewScanItems returns the inventory of Kustomizations and HelmReleases, with the sync waves in
+// opts and the dependencies in graph, grouped by their readiness
+func NewScanItems(c client.Client, ctx context.Context, ans string, ks []kustomizev1.Kustomization, hs []helmv2.HelmRelease, exd []string, opts MigrationOptions, graph *DependencyGraph) []ScanItem {
+	items := []ScanItem{}
+	for n := range hs {
+		item := NewHelmReleaseScanItem(c, ctx, ans, &hs[n], opts)
+		item.setDependencies(ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: hs[n].Namespace, Name: hs[n].Name}, opts.SyncWaves, graph)
+		items = append(items, item)
+	}
+	for n := range ks {
+		item := NewKustomizationScanItem(c, ctx, ans, &ks[n], exd, opts)
+		item.setDependencies(ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: ks[n].Namespace, Name: ks[n].Name}, opts.SyncWaves, graph)
+		items = append(items, item)
+	}
+	SortScanItems(items)
+	return items
+}
+
+// setDependencies sets the sync wave and the dependencies, with the ones that are missing or in
+// another namespace pointed out. There's no wave without waves, when dependsOn has a cycle.
+func (i *ScanItem) setDependencies(ref ObjectRef, waves map[ObjectRef]int, graph *DependencyGraph) {
+	for _, d := range graph.DependsOn(ref) {
+		i.DependsOn = append(i.DependsOn, d.String())
+	}
+	if waves != nil {
+		i.Wave = strconv.Itoa(waves[ref])
+	}
+}
+
+// setStatus sets the state and the status of the Ready condition, the conditions can be in any
+// order
+func (i *ScanItem) setStatus(conditions []metav1.Condition) {
+	i.Status, _ = FluxStatus(conditions, i.Suspended)
+	ready := apimeta.FindStatusCondition(conditions, meta.ReadyCondition)
+	if ready == nil {
+		return
+	}
+	i.Ready = string(ready.Status)
+	i.ReadyReason = ready.Reason
+	i.ReadyMessage = ready.Message
+}
+
+// setSource sets the URL and revision of the source, any kind of it, and returns it. A source
+// that isn't there is left out and empty, it's a blocker of its own.
+func (i *ScanItem) setSource(c client.Client, ctx context.Context) *unstructured.Unstructured {
+	version := sourcev1beta2.GroupVersion
+	if i.SourceKind == sourcev1.GitRepositoryKind {
+		version = sourcev1.GroupVersion
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(version.WithKind(i.SourceKind))
+	if err := c.Get(ctx, types.NamespacedName{Namespace: i.SourceNamespace, Name: i.SourceName}, u); err != nil {
+		return &unstructured.Unstructured{Object: map[string]interface{}{}}
+	}
+
+	i.SourceURL, _, _ = unstructured.NestedString(u.Object, "spec", "url")
+	if i.SourceKind == sourcev1beta2.BucketKind {
+		endpoint, _, _ := unstructured.NestedString(u.Object, "spec", "endpoint")
+		bucket, _, _ := unstructured.NestedString(u.Object, "spec", "bucketName")
+		i.SourceURL = endpoint + "/" + bucket
+	}
+
+	// The most specific reference wins, like in Flux
+	for _, field := range []string{"commit", "digest", "name", "semver", "tag", "branch"} {
+		if v, _, _ := unstructured.NestedString(u.Object, "spec", "ref", field); v != "" {
+			i.SourceRevision = v
+			return u
+		}
+	}
+	if i.SourceKind == sourcev1.GitRepositoryKind {
+		i.SourceRevision = fluxDefaultBranch
+	}
+	return u
+}
+
+// quiet returns a copy of ctx the migrations don't log their warnings with, inventories are for
+// machines
+func quiet(ctx context.Context) context.Context {
+	l := log.New()
+	l.SetOutput(log.StandardLogger().Out)
+	l.SetFormatter(log.StandardLogger().Formatter)
+	l.SetLevel(log.ErrorLevel)
+	return WithLogger(ctx, l)
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// WriteScanItems writes the inventory in a format: json, yaml or csv
+func WriteScanItems(w io.Writer, format string, items []ScanItem) error {
+	switch format {
+	case ScanOutputJSON:
+		out, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+
+	case ScanOutputYAML:
+		out, err := yaml.Marshal(items)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+
+	case ScanOutputCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(scanCSVHeader); err != nil {
+			return err
+		}
+		for _, i := range items {
+			row := []string{
+				i.Kind, i.Name, i.Namespace,
+				i.SourceKind, i.SourceName, i.SourceNamespace, i.SourceURL, i.SourceRevision,
+				i.TargetNamespace, strconv.FormatBool(i.Suspended), i.Status,
+				i.Ready, i.ReadyReason, i.ReadyMessage, i.LastAppliedRevision,
+				i.Wave, strings.Join(i.DependsOn, ";"), strings.Join(i.Blockers, ";"),
+				i.Readiness, strconv.Itoa(i.Score),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return fmt.Errorf("unknown output format %q, use %q, %q, %q or %q", format, ScanOutputWide, ScanOutputJSON, ScanOutputYAML, ScanOutputCSV)
+}
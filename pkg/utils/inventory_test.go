@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNewKustomizationScanItem(t *testing.T) {
+	scheme := runtime.NewScheme()
+	sourcev1.AddToScheme(scheme)
+	sourcev1beta2.AddToScheme(scheme)
+	kustomizev1.AddToScheme(scheme)
+
+	repo := &unstructured.Unstructured{}
+	repo.SetAPIVersion(sourcev1.GroupVersion.String())
+	repo.SetKind(sourcev1.GitRepositoryKind)
+	repo.SetNamespace("flux-system")
+	repo.SetName("apps")
+	unstructured.SetNestedField(repo.Object, "https://github.com/example/apps", "spec", "url")
+	unstructured.SetNestedField(repo.Object, "v1.0.0", "spec", "ref", "tag")
+
+	tests := []struct {
+		name              string
+		sourceKind        string
+		conditions        []metav1.Condition
+		expectedURL       string
+		expectedRevision  string
+		expectedReady     string
+		expectedBlockers  []string
+		expectedReadiness string
+	}{
+		{
+			name:              "when it comes from a GitRepository",
+			sourceKind:        sourcev1.GitRepositoryKind,
+			conditions:        []metav1.Condition{{Type: meta.ReconcilingCondition, Status: metav1.ConditionTrue}, {Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: "BuildFailed"}},
+			expectedURL:       "https://github.com/example/apps",
+			expectedRevision:  "v1.0.0",
+			expectedReady:     "False",
+			expectedBlockers:  []string{"isn't Ready (BuildFailed), migrate it once Flux reconciles it"},
+			expectedReadiness: ReadinessManual,
+		},
+		{
+			name:              "when it's Ready",
+			sourceKind:        sourcev1.GitRepositoryKind,
+			conditions:        []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}},
+			expectedURL:       "https://github.com/example/apps",
+			expectedRevision:  "v1.0.0",
+			expectedReady:     "True",
+			expectedReadiness: ReadinessSafe,
+		},
+		{
+			name:              "when Flux hasn't reconciled it",
+			sourceKind:        sourcev1.GitRepositoryKind,
+			expectedURL:       "https://github.com/example/apps",
+			expectedRevision:  "v1.0.0",
+			expectedBlockers:  []string{"isn't Ready (Unknown), migrate it once Flux reconciles it"},
+			expectedReadiness: ReadinessManual,
+		},
+		{
+			name:              "when it comes from an OCIRepository",
+			sourceKind:        sourcev1beta2.OCIRepositoryKind,
+			expectedBlockers:  []string{"Argo CD has no OCIRepository sources"},
+			expectedReadiness: ReadinessUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "flux-system"},
+				Spec: kustomizev1.KustomizationSpec{
+					Path:      "./apps",
+					SourceRef: kustomizev1.CrossNamespaceSourceReference{Kind: tt.sourceKind, Name: "apps"},
+				},
+				Status: kustomizev1.KustomizationStatus{Conditions: tt.conditions},
+			}
+			k.SetGroupVersionKind(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind))
+			obj, _ := runtime.DefaultUnstructuredConverter.ToUnstructured(k)
+			c := NewOfflineClient(scheme, repo, &unstructured.Unstructured{Object: obj})
+
+			item := NewKustomizationScanItem(c, context.TODO(), "argocd", k, []string{}, MigrationOptions{OutputMode: OutputModeApplication})
+			assert.Equal(t, item.SourceURL, tt.expectedURL)
+			assert.Equal(t, item.SourceRevision, tt.expectedRevision)
+			assert.Equal(t, item.Ready, tt.expectedReady)
+			assert.Equal(t, item.Blockers, tt.expectedBlockers)
+			assert.Equal(t, item.Readiness, tt.expectedReadiness)
+		})
+	}
+}
+
+func TestWriteScanItems(t *testing.T) {
+	items := []ScanItem{{Kind: "Kustomization", Name: "apps", Namespace: "flux-system", Suspended: true, Status: StatusSuspended, DependsOn: []string{"infra", "flux-system/crds"}, Readiness: ReadinessSafe, Score: 100}}
+
+	tests := []struct {
+		name           string
+		format         string
+		expectedOutput string
+		expectedErr    bool
+	}{
+		{
+			name:           "when it's json",
+			format:         ScanOutputJSON,
+			expectedOutput: "[\n  {\n    \"kind\": \"Kustomization\",\n    \"name\": \"apps\",\n    \"namespace\": \"flux-system\",\n    \"suspended\": true,\n    \"status\": \"Suspended\",\n    \"dependsOn\": [\n      \"infra\",\n      \"flux-system/crds\"\n    ],\n    \"readiness\": \"safe\",\n    \"score\": 100\n  }\n]\n",
+		},
+		{
+			name:           "when it's yaml",
+			format:         ScanOutputYAML,
+			expectedOutput: "- dependsOn:\n  - infra\n  - flux-system/crds\n  kind: Kustomization\n  name: apps\n  namespace: flux-system\n  readiness: safe\n  score: 100\n  status: Suspended\n  suspended: true\n",
+		},
+		{
+			name:           "when it's csv",
+			format:         ScanOutputCSV,
+			expectedOutput: "kind,name,namespace,sourceKind,sourceName,sourceNamespace,sourceURL,sourceRevision,targetNamespace,suspended,status,ready,readyReason,readyMessage,lastAppliedRevision,wave,dependsOn,blockers,readiness,score\nKustomization,apps,flux-system,,,,,,,true,Suspended,,,,,,infra;flux-system/crds,,safe,100\n",
+		},
+		{
+			name:        "when the format is unknown",
+			format:      "xml",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := WriteScanItems(out, tt.format, items)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			assert.Equal(t, out.String(), tt.expectedOutput)
+		})
+	}
+}
@@ -0,0 +1,572 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	yaml "sigs.k8s.io/yaml"
+)
+
+// JournalLabel is set on the ConfigMaps that hold migration journals
+const JournalLabel = "mta.akuity.io/journal"
+
+// journalConfigMapKey is the ConfigMap key the journal is stored under
+const journalConfigMapKey = "journal.yaml"
+
+// argoSecretTypeLabel tells Argo CD what a Secret is for, a repository or a cluster
+const argoSecretTypeLabel = "argocd.argoproj.io/secret-type"
+
+// Journal is the record of a confirmed migration: the Flux objects as they were before it started,
+// the Argo CD objects it created, the objects it shares with other migrations, how to undo its
+// changes to objects that were already there and every action it took. It's everything
+// `mta rollback` needs.
+type Journal struct {
+	ID            string          `json:"id"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	FluxObjects   []string        `json:"fluxObjects,omitempty"`
+	ArgoObjects   []string        `json:"argoObjects,omitempty"`
+	SharedObjects []SharedObject  `json:"sharedObjects,omitempty"`
+	Undo          []JournalPatch  `json:"undo,omitempty"`
+	Actions       []JournalAction `json:"actions,omitempty"`
+	// RolledBack is set once the migration has been rolled back, it doesn't use its shared
+	// objects anymore
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// SharedObject is an object other migrations may use too, like a repository Secret, an AppProject,
+// a cluster Secret or one of Argo CD's ConfigMaps. Created is set when the migration created it,
+// rollback only deletes those and only once nothing else uses them.
+type SharedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Created    bool   `json:"created,omitempty"`
+}
+
+// JournalPatch is the patch that reverts a change made to an object that was already there
+type JournalPatch struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Namespace  string          `json:"namespace,omitempty"`
+	Name       string          `json:"name"`
+	Type       types.PatchType `json:"type"`
+	Patch      string          `json:"patch"`
+}
+
+// JournalAction is a single change made during a migration or a rollback
+type JournalAction struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+}
+
+// NewJournal returns an empty Journal with a new, time ordered, ID
+func NewJournal() *Journal {
+	now := time.Now().UTC()
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+
+	return &Journal{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		CreatedAt: now,
+	}
+}
+
+// addAction records an action taken on obj
+func (j *Journal) addAction(action string, kind string, obj client.Object) {
+	j.Actions = append(j.Actions, JournalAction{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	})
+}
+
+// journalObject returns an empty object with the kind, namespace and name of a shared object or a patch
+func journalObject(apiVersion, kind, ns, name string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	o.SetNamespace(ns)
+	o.SetName(name)
+	return o
+}
+
+// newSharedObject returns the journal entry of a shared object
+func newSharedObject(c client.Client, obj client.Object, created bool) SharedObject {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if k, err := c.GroupVersionKindFor(obj); err == nil {
+		gvk = k
+	}
+	return SharedObject{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Namespace: obj.GetNamespace(), Name: obj.GetName(), Created: created}
+}
+
+// newJournalPatch returns the journal entry of the patch that reverts a change to obj. The journal
+// may end up in a ConfigMap, so the values of a Secret are left out of a merge patch: the keys the
+// migration added are still removed, the ones it changed can't be put back.
+func newJournalPatch(c client.Client, obj client.Object, undo client.Patch) (JournalPatch, error) {
+	data, err := undo.Data(obj)
+	if err != nil {
+		return JournalPatch{}, err
+	}
+
+	s := newSharedObject(c, obj, false)
+	if s.Kind == "Secret" && undo.Type() == types.MergePatchType {
+		patch := map[string]interface{}{}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return JournalPatch{}, err
+		}
+		if d, ok := patch["data"].(map[string]interface{}); ok {
+			for k, v := range d {
+				if v != nil {
+					log.Warn("The migration journal can't keep the old value of \"" + k + "\" in Secret " + s.Namespace + "/" + s.Name + ", rolling back won't put it back")
+					delete(d, k)
+				}
+			}
+		}
+		if data, err = json.Marshal(patch); err != nil {
+			return JournalPatch{}, err
+		}
+	}
+
+	return JournalPatch{APIVersion: s.APIVersion, Kind: s.Kind, Namespace: s.Namespace, Name: s.Name, Type: undo.Type(), Patch: string(data)}, nil
+}
+
+// Objects decodes a list of manifests stored in the journal
+func (j *Journal) Objects(manifests []string) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, m := range manifests {
+		decoded, err := DecodeManifests(strings.NewReader(m))
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, decoded...)
+	}
+	return objs, nil
+}
+
+// journalManifest serializes obj for the journal. Server managed fields are dropped and so is
+// the data of Secrets, the journal may end up in a ConfigMap.
+func journalManifest(c client.Client, obj client.Object) (string, error) {
+	o := obj.DeepCopyObject().(client.Object)
+	if gvk, err := c.GroupVersionKindFor(obj); err == nil {
+		o.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+	o.SetManagedFields(nil)
+
+	if s, ok := o.(*apiv1.Secret); ok {
+		s.Data = nil
+		s.StringData = nil
+	}
+
+	data, err := yaml.Marshal(o)
+	return string(data), err
+}
+
+// JournalStore persists migration journals
+type JournalStore interface {
+	Save(j *Journal) error
+	Load(id string) (*Journal, error)
+	List() ([]*Journal, error)
+}
+
+// FileJournalStore keeps journals as YAML files in a local directory
+type FileJournalStore struct {
+	Dir string
+}
+
+// Save writes the journal to <Dir>/<ID>.yaml
+func (s FileJournalStore) Save(j *Journal) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, j.ID+".yaml"), data, 0o600)
+}
+
+// Load reads the journal from <Dir>/<id>.yaml
+func (s FileJournalStore) Load(id string) (*Journal, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, id+".yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{}
+	return j, yaml.Unmarshal(data, j)
+}
+
+// List reads every journal in Dir, there are none if it isn't there yet
+func (s FileJournalStore) List() ([]*Journal, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []*Journal
+	for _, f := range files {
+		j, err := s.Load(strings.TrimSuffix(filepath.Base(f), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	return journals, nil
+}
+
+// ConfigMapJournalStore keeps journals in ConfigMaps, usually in the Argo CD namespace
+type ConfigMapJournalStore struct {
+	Client    client.Client
+	Ctx       context.Context
+	Namespace string
+}
+
+// configMapName returns the name of the ConfigMap holding a journal
+func (s ConfigMapJournalStore) configMapName(id string) string {
+	return "mta-journal-" + id
+}
+
+// Save creates or updates the journal's ConfigMap
+func (s ConfigMapJournalStore) Save(j *Journal) error {
+	data, err := yaml.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.configMapName(j.ID),
+			Namespace: s.Namespace,
+			Labels:    map[string]string{JournalLabel: "true"},
+		},
+		Data: map[string]string{journalConfigMapKey: string(data)},
+	}
+	cm.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("ConfigMap"))
+
+	err = s.Client.Create(s.Ctx, cm)
+	if apierrors.IsAlreadyExists(err) {
+		return s.Client.Update(s.Ctx, cm)
+	}
+	return err
+}
+
+// Load reads the journal from its ConfigMap
+func (s ConfigMapJournalStore) Load(id string) (*Journal, error) {
+	cm := &apiv1.ConfigMap{}
+	if err := s.Client.Get(s.Ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.configMapName(id)}, cm); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{}
+	return j, yaml.Unmarshal([]byte(cm.Data[journalConfigMapKey]), j)
+}
+
+// List reads the journals of every labelled ConfigMap in Namespace
+func (s ConfigMapJournalStore) List() ([]*Journal, error) {
+	cms := &apiv1.ConfigMapList{}
+	if err := s.Client.List(s.Ctx, cms, client.InNamespace(s.Namespace), client.MatchingLabels{JournalLabel: "true"}); err != nil {
+		return nil, err
+	}
+
+	var journals []*Journal
+	for _, cm := range cms.Items {
+		j := &Journal{}
+		if err := yaml.Unmarshal([]byte(cm.Data[journalConfigMapKey]), j); err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	return journals, nil
+}
+
+// LoadJournal tries each store in turn and returns the first journal found
+func LoadJournal(id string, stores ...JournalStore) (*Journal, error) {
+	var errs []string
+	for _, s := range stores {
+		j, err := s.Load(id)
+		if err == nil {
+			return j, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("journal %q not found: %s", id, strings.Join(errs, "; "))
+}
+
+// otherJournals returns the journals in the stores besides j
+func otherJournals(j *Journal, stores []JournalStore) ([]*Journal, error) {
+	seen := map[string]bool{j.ID: true}
+	var journals []*Journal
+	for _, s := range stores {
+		listed, err := s.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range listed {
+			if !seen[o.ID] {
+				seen[o.ID] = true
+				journals = append(journals, o)
+			}
+		}
+	}
+	return journals, nil
+}
+
+// sameObject tells if two shared objects are the same object
+func sameObject(a SharedObject, b SharedObject) bool {
+	return a.Kind == b.Kind && a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+// leftBehind tells if a migration that's been rolled back created a shared object, and kept it
+// because others still used it
+func leftBehind(others []*Journal, s SharedObject) bool {
+	for _, o := range others {
+		for _, so := range o.SharedObjects {
+			if o.RolledBack && so.Created && sameObject(so, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sharedObjectUser returns what still uses a shared object besides j: the journal of a migration
+// that hasn't been rolled back, or a live Application or ApplicationSet that isn't j's. It's empty
+// when nothing does.
+func sharedObjectUser(c client.Client, ctx context.Context, j *Journal, others []*Journal, s SharedObject) (string, error) {
+	for _, o := range others {
+		for _, so := range o.SharedObjects {
+			if !o.RolledBack && sameObject(so, s) {
+				return "migration " + o.ID, nil
+			}
+		}
+	}
+
+	// Applications use AppProjects by name, repository Secrets by URL and cluster Secrets by server
+	// or name
+	var uses func(spec map[string]interface{}) bool
+	switch s.Kind {
+	case "AppProject":
+		uses = func(spec map[string]interface{}) bool {
+			project, _, _ := unstructured.NestedString(spec, "project")
+			return project == s.Name
+		}
+	case "Secret":
+		secret := &apiv1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, secret)
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		data := secretData(secret)
+		switch secret.Labels[argoSecretTypeLabel] {
+		case "repository":
+			uses = func(spec map[string]interface{}) bool {
+				return slices.Contains(specRepoURLs(spec), data["url"])
+			}
+		case "cluster":
+			uses = func(spec map[string]interface{}) bool {
+				server, _, _ := unstructured.NestedString(spec, "destination", "server")
+				name, _, _ := unstructured.NestedString(spec, "destination", "name")
+				return (server != "" && server == data["server"]) || (name != "" && name == data["name"])
+			}
+		}
+	}
+	if uses == nil {
+		return "", nil
+	}
+
+	// The Applications of j are being deleted, and so are the ones its ApplicationSets generated
+	argoObjs, err := j.Objects(j.ArgoObjects)
+	if err != nil {
+		return "", err
+	}
+	ours := map[string]bool{}
+	for _, o := range argoObjs {
+		ours[o.GetKind()+"/"+o.GetNamespace()+"/"+o.GetName()] = true
+	}
+
+	for _, kind := range []string{"Application", "ApplicationSet"} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(argov1alpha1.SchemeGroupVersion.WithKind(kind + "List"))
+		err := c.List(ctx, list)
+		if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		for _, item := range list.Items {
+			if ours[kind+"/"+item.GetNamespace()+"/"+item.GetName()] {
+				continue
+			}
+			owned := false
+			for _, ref := range item.GetOwnerReferences() {
+				owned = owned || ours[ref.Kind+"/"+item.GetNamespace()+"/"+ref.Name]
+			}
+			if owned {
+				continue
+			}
+
+			spec, _, _ := unstructured.NestedMap(item.Object, "spec")
+			if kind == "ApplicationSet" {
+				spec, _, _ = unstructured.NestedMap(item.Object, "spec", "template", "spec")
+			}
+			if uses(spec) {
+				return kind + " " + item.GetNamespace() + "/" + item.GetName(), nil
+			}
+		}
+	}
+
+	// If we're here, nothing uses it
+	return "", nil
+}
+
+// specRepoURLs returns the repo URLs of the source or sources of an Application spec
+func specRepoURLs(spec map[string]interface{}) []string {
+	var urls []string
+	if url, _, _ := unstructured.NestedString(spec, "source", "repoURL"); url != "" {
+		urls = append(urls, url)
+	}
+	sources, _, _ := unstructured.NestedSlice(spec, "sources")
+	for _, src := range sources {
+		if m, ok := src.(map[string]interface{}); ok {
+			if url, _, _ := unstructured.NestedString(m, "repoURL"); url != "" {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// RollbackJournal undoes a finished migration from its journal: the Argo CD objects it created are
+// deleted, and so are the shared objects it created, or that the migrations rolled back before it
+// left behind, once no other migration in the stores or live Application uses them. The changes it made to objects that were already there are reverted, newest
+// first. Then the Flux objects are recreated as they were before the migration, Flux objects that
+// still exist are resumed instead.
+func RollbackJournal(c client.Client, ctx context.Context, j *Journal, stores ...JournalStore) error {
+	argoObjs, err := j.Objects(j.ArgoObjects)
+	if err != nil {
+		return err
+	}
+	fluxObjs, err := j.Objects(j.FluxObjects)
+	if err != nil {
+		return err
+	}
+	others, err := otherJournals(j, stores)
+	if err != nil {
+		return err
+	}
+
+	// Delete what we created, newest first
+	var deletable []*unstructured.Unstructured
+	for i := len(argoObjs) - 1; i >= 0; i-- {
+		deletable = append(deletable, argoObjs[i])
+	}
+
+	// Then the shared objects we created, unless something else uses them. They're checked before
+	// anything is deleted, the Applications that use them are about to go.
+	// Those a rolled back migration left behind for us are deleted too.
+	checked := []SharedObject{}
+	for i := len(j.SharedObjects) - 1; i >= 0; i-- {
+		s := j.SharedObjects[i]
+		if slices.ContainsFunc(checked, func(o SharedObject) bool { return sameObject(o, s) }) {
+			continue
+		}
+		checked = append(checked, s)
+		if !s.Created && !leftBehind(others, s) {
+			continue
+		}
+		o := journalObject(s.APIVersion, s.Kind, s.Namespace, s.Name)
+		user, err := sharedObjectUser(c, ctx, j, others, s)
+		if err != nil {
+			return fmt.Errorf("checking what uses %s/%s/%s: %w", s.Kind, s.Namespace, s.Name, err)
+		}
+		if user != "" {
+			log.Info("Keeping " + s.Kind + " " + s.Namespace + "/" + s.Name + ", " + user + " still uses it")
+			j.addAction("rollback-keep", s.Kind, o)
+			continue
+		}
+		deletable = append(deletable, o)
+	}
+
+	for _, o := range deletable {
+		if err := c.Delete(ctx, o); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting %s/%s/%s: %w", o.GetKind(), o.GetNamespace(), o.GetName(), err)
+		}
+		j.addAction("rollback-delete", o.GetKind(), o)
+	}
+
+	// Revert the changes to the objects that were already there, newest first
+	for i := len(j.Undo) - 1; i >= 0; i-- {
+		p := j.Undo[i]
+		o := journalObject(p.APIVersion, p.Kind, p.Namespace, p.Name)
+		if err := c.Patch(ctx, o, client.RawPatch(p.Type, []byte(p.Patch))); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("reverting %s/%s/%s: %w", p.Kind, p.Namespace, p.Name, err)
+		}
+		j.addAction("rollback-patch", p.Kind, o)
+	}
+
+	// Bring back the Flux objects
+	for _, o := range fluxObjs {
+		suspended, _, _ := unstructured.NestedBool(o.Object, "spec", "suspend")
+
+		n := o.DeepCopy()
+		n.SetResourceVersion("")
+		n.SetUID("")
+		n.SetCreationTimestamp(metav1.Time{})
+		n.SetDeletionTimestamp(nil)
+		n.SetDeletionGracePeriodSeconds(nil)
+		unstructured.RemoveNestedField(n.Object, "status")
+
+		err := c.Create(ctx, n)
+		if err == nil {
+			j.addAction("rollback-create", o.GetKind(), o)
+			continue
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("recreating %s/%s/%s: %w", o.GetKind(), o.GetNamespace(), o.GetName(), err)
+		}
+
+		// It was never deleted, put suspend back to what it was
+		patch := []byte(fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspended))
+		if err := c.Patch(ctx, n, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return fmt.Errorf("resuming %s/%s/%s: %w", o.GetKind(), o.GetNamespace(), o.GetName(), err)
+		}
+		j.addAction("rollback-resume", o.GetKind(), o)
+	}
+
+	// If we're here, it should have gone okay...
+	j.RolledBack = true
+	return nil
+}
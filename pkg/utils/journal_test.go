@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRollbackJournal(t *testing.T) {
+	scheme := runtime.NewScheme()
+	helmv2.AddToScheme(scheme)
+	argov1alpha1.AddToScheme(scheme)
+	apiv1.AddToScheme(scheme)
+
+	cm := &unstructured.Unstructured{}
+	cm.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("ConfigMap"))
+	cm.SetNamespace("argocd")
+	cm.SetName("argocd-cm")
+	unstructured.SetNestedStringMap(cm.Object, map[string]string{"setting": "original"}, "data")
+
+	hr := &unstructured.Unstructured{}
+	hr.SetGroupVersionKind(helmv2.GroupVersion.WithKind("HelmRelease"))
+	hr.SetNamespace("flux-system")
+	hr.SetName("podinfo")
+	unstructured.SetNestedField(hr.Object, "podinfo", "spec", "releaseName")
+
+	c := NewOfflineClient(scheme, hr, cm)
+	ctx := context.TODO()
+	store := FileJournalStore{Dir: t.TempDir()}
+
+	helmRelease := &helmv2.HelmRelease{}
+	c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, helmRelease)
+	app := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: "podinfo"}}
+
+	// A migration that went through
+	tx := NewMigrationTransaction(c, ctx).WithJournal(store)
+	if err := tx.Suspend(helmRelease); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create(app); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"first", "second"} {
+		if err := tx.MergeConfigMap("argocd", "argocd-cm", map[string]string{"setting": v}, ReplaceValue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Delete(helmRelease); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undo it from the saved journal
+	j, err := LoadJournal(tx.Journal.ID, FileJournalStore{Dir: t.TempDir()}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(j.FluxObjects), 1)
+	assert.Equal(t, len(j.ArgoObjects), 1)
+	assert.Equal(t, len(j.Undo), 2)
+	if err := RollbackJournal(c, ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes to the ConfigMap are undone newest first
+	gotCM := &apiv1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "argocd-cm"}, gotCM); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gotCM.Data["setting"], "original")
+
+	// The HelmRelease is back as it was before the migration, and not suspended
+	got := &helmv2.HelmRelease{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.Spec.ReleaseName, "podinfo")
+	assert.Equal(t, got.Spec.Suspend, false)
+
+	// The Application is gone
+	err = c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "podinfo"}, &argov1alpha1.Application{})
+	assert.Equal(t, apierrors.IsNotFound(err), true)
+	assert.Equal(t, j.Actions[len(j.Actions)-1].Action, "rollback-create")
+}
+
+func TestRollbackJournalSharedObjects(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+	kustomizev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+	argov1alpha1.AddToScheme(scheme)
+
+	// Two Kustomizations from the same private repo
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(sourcev1.GroupVersion.WithKind(sourcev1.GitRepositoryKind))
+	repo.SetNamespace("flux-system")
+	repo.SetName("apps")
+	unstructured.SetNestedField(repo.Object, "https://github.com/example/apps", "spec", "url")
+	unstructured.SetNestedField(repo.Object, "main", "spec", "ref", "branch")
+	unstructured.SetNestedField(repo.Object, "apps-auth", "spec", "secretRef", "name")
+
+	auth := &unstructured.Unstructured{}
+	auth.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("Secret"))
+	auth.SetNamespace("flux-system")
+	auth.SetName("apps-auth")
+	unstructured.SetNestedStringMap(auth.Object, map[string]string{"username": "git", "password": "secret"}, "stringData")
+
+	objs := []*unstructured.Unstructured{repo, auth}
+	for _, name := range []string{"frontend", "backend"} {
+		k := &unstructured.Unstructured{}
+		k.SetGroupVersionKind(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind))
+		k.SetNamespace("flux-system")
+		k.SetName(name)
+		unstructured.SetNestedField(k.Object, "./"+name, "spec", "path")
+		unstructured.SetNestedField(k.Object, sourcev1.GitRepositoryKind, "spec", "sourceRef", "kind")
+		unstructured.SetNestedField(k.Object, "apps", "spec", "sourceRef", "name")
+		objs = append(objs, k)
+	}
+
+	c := NewOfflineClient(scheme, objs...)
+	ctx := context.TODO()
+	store := FileJournalStore{Dir: t.TempDir()}
+	opts := MigrationOptions{Stores: []JournalStore{store}, OutputMode: OutputModeApplication, Naming: DefaultNaming()}
+
+	// Migrate both, the second one finds the repository Secret the first one created
+	var journals []string
+	for _, name := range []string{"frontend", "backend"} {
+		k := kustomizev1.Kustomization{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: name}, &k); err != nil {
+			t.Fatal(err)
+		}
+		if err := MigrateKustomizationToApplicationSet(c, ctx, "argocd", k, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		listed, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, j := range listed {
+			if !slices.Contains(journals, j.ID) {
+				journals = append(journals, j.ID)
+			}
+		}
+	}
+	assert.Equal(t, len(journals), 2)
+
+	first, err := LoadJournal(journals[0], store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(first.SharedObjects), 1)
+	assert.Equal(t, first.SharedObjects[0].Created, true)
+	secretKey := types.NamespacedName{Namespace: first.SharedObjects[0].Namespace, Name: first.SharedObjects[0].Name}
+
+	// Rolling back the first one keeps the Secret the second one still uses
+	if err := RollbackJournal(c, ctx, first, store); err != nil {
+		t.Fatal(err)
+	}
+	store.Save(first)
+	assert.Equal(t, c.Get(ctx, secretKey, &apiv1.Secret{}), nil)
+	assert.Equal(t, c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "frontend"}, &kustomizev1.Kustomization{}), nil)
+	err = c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "flux-system-frontend-ks"}, &argov1alpha1.Application{})
+	assert.Equal(t, apierrors.IsNotFound(err), true)
+	assert.Equal(t, c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "flux-system-backend-ks"}, &argov1alpha1.Application{}), nil)
+
+	// Once the second one is rolled back too, nothing uses it and it goes
+	second, err := LoadJournal(journals[1], store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RollbackJournal(c, ctx, second, store); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(ctx, secretKey, &apiv1.Secret{})
+	assert.Equal(t, apierrors.IsNotFound(err), true)
+}
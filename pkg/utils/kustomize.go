@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// OutputModeApplicationSet migrates a Kustomization to an ApplicationSet with an Application for
+	// each directory under its path
+	OutputModeApplicationSet = "applicationset"
+	// OutputModeApplication migrates a Kustomization to a single Application with the same path
+	OutputModeApplication = "application"
+	// bootstrapKustomization is the Kustomization flux bootstrap creates, it's always migrated to an
+	// ApplicationSet
+	bootstrapKustomization = "flux-system"
+)
+
+// KustomizationMigration is what a Kustomization is migrated to
+type KustomizationMigration struct {
+	// Source is the GitRepository the Kustomization comes from
+	Source      *sourcev1.GitRepository
+	RepoURL     string
+	Credentials argo.RepositoryCredentials
+	// Secret is the repository Secret and App the Application or ApplicationSet
+	Secret *apiv1.Secret
+	App    client.Object
+	// Plugin is set when the manifests are rendered by the envsubst plugin
+	Plugin bool
+	// Decryption is set when the manifests are decrypted by the sops plugin, with the keys
+	Decryption *SOPSDecryption
+	// Project is the AppProject of the Kustomization's tenant, nil if it's not in one
+	Project *v1alpha1.AppProject
+	// ClusterSecret registers the remote cluster the Kustomization deploys to, nil if there's none
+	// or it's already registered
+	ClusterSecret *apiv1.Secret
+	// ImageUpdaterConfig are the settings of Argo CD Image Updater's ConfigMap when the Application
+	// is annotated to have its images updated, nil otherwise
+	ImageUpdaterConfig map[string]string
+	// Notifications configure Argo CD Notifications to send the Flux alerts of the Kustomization,
+	// nil if there are none
+	Notifications *NotificationsConfig
+}
+
+// NewKustomizationMigration generates the Argo CD objects a Kustomization is migrated to, an
+// Application or an ApplicationSet depending on the output mode in opts. The bootstrap flux-system
+// Kustomization is always migrated to an ApplicationSet, it's what the other Kustomizations live
+// in. The objects are named with the naming in opts.
+func NewKustomizationMigration(c client.Client, ctx context.Context, ans string, k *kustomizev1.Kustomization, gitSource *sourcev1.GitRepository, creds argo.RepositoryCredentials, exd []string, opts MigrationOptions) (KustomizationMigration, error) {
+	mode, naming := opts.OutputMode, opts.Naming
+	if mode == "" {
+		mode = OutputModeApplicationSet
+	}
+	if mode != OutputModeApplicationSet && mode != OutputModeApplication {
+		return KustomizationMigration{}, fmt.Errorf("unknown output mode %q, use %q or %q", mode, OutputModeApplication, OutputModeApplicationSet)
+	}
+
+	// Carry over what Flux does to the manifests, variable substitution needs a plugin
+	rendering, err := TranslateKustomization(c, ctx, k)
+	if err != nil {
+		return KustomizationMigration{}, err
+	}
+
+	m := KustomizationMigration{
+		Source:      gitSource,
+		RepoURL:     gitSource.Spec.URL,
+		Credentials: creds,
+		Plugin:      rendering.PluginName == EnvsubstPluginName,
+	}
+
+	// Decrypt the manifests with the keys Flux decrypts them with
+	if m.Decryption, err = GetSOPSDecryption(c, ctx, k); err != nil {
+		return KustomizationMigration{}, err
+	}
+	if m.Decryption != nil && opts.SOPSPluginImage == "" {
+		return KustomizationMigration{}, fmt.Errorf("Kustomization %s/%s decrypts with SOPS, the %s plugin needs an image with sh, sops, gpg, kubectl and flux, give it with --sops-plugin-image", k.Namespace, k.Name, SOPSPluginName)
+	}
+
+	// Deploy to the cluster the Kustomization deploys to
+	dest, err := ResolveDestination(c, ctx, ans, k, opts.ClusterNames)
+	if err != nil {
+		return KustomizationMigration{}, err
+	}
+	m.ClusterSecret = dest.Secret
+
+	if mode == OutputModeApplication && k.Name != bootstrapKustomization {
+		app, err := NewKustomizationApplication(ctx, k, gitSource, rendering)
+		if err != nil {
+			return KustomizationMigration{}, err
+		}
+		d := kustomizationNameData(k, gitSource)
+		name, err := naming.KustomizationName(d)
+		if err != nil {
+			return KustomizationMigration{}, err
+		}
+		secretName, err := naming.RepositorySecretName(d)
+		if err != nil {
+			return KustomizationMigration{}, err
+		}
+		m.App = argo.GenApplication(name, ans, app)
+		m.Secret = GenRepositorySecret(ans, secretName, "git", gitSource.Spec.URL, creds)
+	} else {
+		// Generate the ApplicationSet manifest based on the struct
+		applicationSet, err := NewGitDirApplicationSet(ctx, ans, k, gitSource, creds, exd, naming)
+		if err != nil {
+			return KustomizationMigration{}, err
+		}
+		applicationSet.AppRendering = rendering
+
+		if m.App, err = argo.GenGitDirAppSet(applicationSet); err != nil {
+			return KustomizationMigration{}, err
+		}
+		m.Secret = GenK8SSecret(applicationSet)
+	}
+	argo.SetDestination(m.App, dest.Server, dest.Name)
+
+	// Keep the tenant to what it could deploy with Flux
+	if m.Project, err = AssignTenantProject(c, ctx, ans, k, m.App, opts.ClusterNames); err != nil {
+		return KustomizationMigration{}, err
+	}
+
+	// Keep updating the images Flux updates
+	if m.ImageUpdaterConfig, err = AddImageUpdater(c, ctx, k, gitSource, m.App); err != nil {
+		return KustomizationMigration{}, err
+	}
+
+	// Keep alerting whoever Flux alerts
+	if m.Notifications, err = AddNotifications(c, ctx, k, gitSource, m.App); err != nil {
+		return KustomizationMigration{}, err
+	}
+
+	// If we're here, it should have gone okay...
+	return m, nil
+}
+
+// GetKustomizationMigration gets the GitRepository and credentials of a Kustomization and
+// generates the Argo CD objects it's migrated to
+func GetKustomizationMigration(c client.Client, ctx context.Context, ans string, k *kustomizev1.Kustomization, exd []string, opts MigrationOptions) (KustomizationMigration, error) {
+	// Get the GitRepository from the Kustomization, it defaults to the Kustomization's namespace
+	gitRepoNamespace := k.Spec.SourceRef.Namespace
+	if gitRepoNamespace == "" {
+		gitRepoNamespace = k.Namespace
+	}
+
+	// get the gitsource
+	gitSource := &sourcev1.GitRepository{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: gitRepoNamespace, Name: k.Spec.SourceRef.Name}, gitSource)
+	if err != nil {
+		return KustomizationMigration{}, err
+	}
+
+	//Get the secret holding the info we need, public repos don't have one
+	var secret *apiv1.Secret
+	if gitSource.Spec.SecretRef != nil && gitSource.Spec.SecretRef.Name != "" {
+		secret = &apiv1.Secret{}
+		err = c.Get(ctx, types.NamespacedName{Namespace: gitRepoNamespace, Name: gitSource.Spec.SecretRef.Name}, secret)
+		if err != nil && !(opts.Offline && apierrors.IsNotFound(err)) {
+			return KustomizationMigration{}, err
+		}
+
+		// Secrets are often not kept next to the Flux manifests, so don't insist on it offline
+		if err != nil {
+			LoggerFrom(ctx).Warn("Secret \"" + gitSource.Spec.SecretRef.Name + "\" was not found in the manifests. Proceeding without the repository credentials.")
+			secret = nil
+		}
+	}
+
+	// Generate the Application or ApplicationSet, with its repository Secret
+	return NewKustomizationMigration(c, ctx, ans, k, gitSource, argo.NewRepositoryCredentials(secret), exd, opts)
+}
+
+// NewKustomizationApplication returns the Application a single Kustomization is migrated to, with
+// its exact path, target namespace, prune setting and source revision
+func NewKustomizationApplication(ctx context.Context, k *kustomizev1.Kustomization, gitSource *sourcev1.GitRepository, rendering argo.Rendering) (argo.AppSpec, error) {
+	// Pin Argo CD to what Flux checks out
+	revision, err := GitRepositoryRevision(ctx, gitSource)
+	if err != nil {
+		return argo.AppSpec{}, err
+	}
+
+	// Argo CD wants the path relative to the root of the repo
+	appPath := strings.TrimPrefix(path.Clean("/"+k.Spec.Path), "/")
+	if appPath == "" {
+		appPath = "."
+	}
+
+	return argo.AppSpec{
+		Project:              "default",
+		RepoURL:              gitSource.Spec.URL,
+		TargetRevision:       revision,
+		Path:                 appPath,
+		DestinationServer:    argo.InClusterServer,
+		DestinationNamespace: k.Spec.TargetNamespace,
+		Prune:                k.Spec.Prune,
+		Rendering:            rendering,
+	}, nil
+}
+
+// TranslateKustomization returns how to render the manifests of a Kustomization the way Flux does:
+// with the sops plugin for decryption, with the envsubst plugin for postBuild substitution, or with
+// the kustomize fields. It warns about anything that can't be carried over.
+func TranslateKustomization(c client.Client, ctx context.Context, k *kustomizev1.Kustomization) (argo.Rendering, error) {
+	vars, err := PostBuildVariables(c, ctx, k)
+	if err != nil {
+		return argo.Rendering{}, err
+	}
+
+	kustomize, unmapped, err := NewKustomizeSource(c, ctx, k)
+	if err != nil {
+		return argo.Rendering{}, err
+	}
+
+	// An Argo CD source is either rendered by kustomize or by a plugin
+	rendering := argo.Rendering{Kustomize: kustomize}
+	plugin := ""
+	switch {
+	case k.Spec.Decryption != nil:
+		// Argo CD would apply the ciphertext, the sops plugin substitutes the variables too
+		plugin = SOPSPluginName
+	case vars != nil:
+		plugin = EnvsubstPluginName
+	}
+	if plugin != "" {
+		rendering = argo.Rendering{PluginName: plugin, PluginEnv: vars}
+		if kustomize != nil {
+			unmapped = append(unmapped, "images, namePrefix, nameSuffix, commonMetadata and targetNamespace: the Applications are rendered by the "+plugin+" plugin, which doesn't take kustomize options. Add them to a kustomization.yaml in the repo")
+		}
+	}
+
+	for _, u := range unmapped {
+		LoggerFrom(ctx).Warn("Kustomization \"" + k.Name + "\" can't be fully carried over to Argo CD, " + u)
+	}
+
+	// If we're here, it should have gone okay...
+	return rendering, nil
+}
+
+// NewKustomizeSource translates the kustomize fields of a Kustomization into the kustomize options
+// of an Argo CD source, field by field. It returns nil options if there's nothing to translate, and
+// a line for each field Argo CD has no equivalent for.
+func NewKustomizeSource(c client.Client, ctx context.Context, k *kustomizev1.Kustomization) (*v1alpha1.ApplicationSourceKustomize, []string, error) {
+	kustomize := &v1alpha1.ApplicationSourceKustomize{}
+	unmapped := []string{}
+
+	// namePrefix and nameSuffix are newer than the Kustomization API we read, get them as they are
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind))
+	if err := c.Get(ctx, types.NamespacedName{Namespace: k.Namespace, Name: k.Name}, u); err != nil {
+		return nil, nil, err
+	}
+	kustomize.NamePrefix, _, _ = unstructured.NestedString(u.Object, "spec", "namePrefix")
+	kustomize.NameSuffix, _, _ = unstructured.NestedString(u.Object, "spec", "nameSuffix")
+
+	// Flux moves every object to the targetNamespace, the destination namespace only gets the ones
+	// without a namespace
+	kustomize.Namespace = k.Spec.TargetNamespace
+
+	for _, i := range k.Spec.Images {
+		image := KustomizeImage(i.Name, i.NewName, i.NewTag, i.Digest)
+		if image == "" {
+			continue
+		}
+		kustomize.Images = append(kustomize.Images, v1alpha1.KustomizeImage(image))
+	}
+
+	if k.Spec.CommonMetadata != nil {
+		// Flux replaces annotations that are already there
+		if len(k.Spec.CommonMetadata.Annotations) > 0 {
+			kustomize.CommonAnnotations = k.Spec.CommonMetadata.Annotations
+			kustomize.ForceCommonAnnotations = true
+		}
+
+		// Argo CD's commonLabels are added to selectors too, which can't be changed on live objects
+		if len(k.Spec.CommonMetadata.Labels) > 0 {
+			unmapped = append(unmapped, fmt.Sprintf("commonMetadata.labels: Argo CD adds common labels to selectors as well, which would change the selectors of live objects. Add the labels %v to the manifests", k.Spec.CommonMetadata.Labels))
+		}
+	}
+
+	if len(k.Spec.Patches) > 0 {
+		unmapped = append(unmapped, fmt.Sprintf("patches: this version of Argo CD has no inline kustomize patches. Move the %d patches to a kustomization.yaml in the repo", len(k.Spec.Patches)))
+	}
+	if len(k.Spec.Components) > 0 {
+		unmapped = append(unmapped, fmt.Sprintf("components: this version of Argo CD has no kustomize components. Add the components %v to a kustomization.yaml in the repo", k.Spec.Components))
+	}
+
+	if kustomize.IsZero() {
+		return nil, unmapped, nil
+	}
+	return kustomize, unmapped, nil
+}
+
+// KustomizeImage returns an image override the way Argo CD writes them, [name=]newName[:tag|@digest].
+// A digest wins over a tag, like in kustomize. It's empty if nothing is overridden.
+func KustomizeImage(name string, newName string, newTag string, digest string) string {
+	if newName == "" && newTag == "" && digest == "" {
+		return ""
+	}
+
+	image := name
+	if newName != "" {
+		image = name + "=" + newName
+	}
+	switch {
+	case digest != "":
+		image += "@" + digest
+	case newTag != "":
+		image += ":" + newTag
+	}
+	return image
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	autov1beta1 "github.com/fluxcd/image-automation-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// kustomizationScheme is the scheme the kustomization command reads Kustomizations with
+func kustomizationScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	kustomizev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+	apiv1.AddToScheme(scheme)
+	appsv1.AddToScheme(scheme)
+	rbacv1.AddToScheme(scheme)
+	v1alpha1.AddToScheme(scheme)
+	imagev1beta2.AddToScheme(scheme)
+	autov1beta1.AddToScheme(scheme)
+	notificationv1beta2.AddToScheme(scheme)
+	return scheme
+}
+
+func TestKustomizeImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		image         kustomize.Image
+		expectedImage string
+	}{
+		{
+			name:          "when nothing is overridden",
+			image:         kustomize.Image{Name: "podinfo"},
+			expectedImage: "",
+		},
+		{
+			name:          "when the tag is overridden",
+			image:         kustomize.Image{Name: "podinfo", NewTag: "6.5.0"},
+			expectedImage: "podinfo:6.5.0",
+		},
+		{
+			name:          "when the name is overridden",
+			image:         kustomize.Image{Name: "podinfo", NewName: "ghcr.io/stefanprodan/podinfo"},
+			expectedImage: "podinfo=ghcr.io/stefanprodan/podinfo",
+		},
+		{
+			name:          "when the name and digest are overridden",
+			image:         kustomize.Image{Name: "podinfo", NewName: "ghcr.io/stefanprodan/podinfo", NewTag: "6.5.0", Digest: "sha256:abc"},
+			expectedImage: "podinfo=ghcr.io/stefanprodan/podinfo@sha256:abc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, KustomizeImage(tt.image.Name, tt.image.NewName, tt.image.NewTag, tt.image.Digest), tt.expectedImage)
+		})
+	}
+}
+
+func TestNewKustomizeSource(t *testing.T) {
+	k := &kustomizev1.Kustomization{}
+	k.SetNamespace("flux-system")
+	k.SetName("apps")
+	k.Spec.TargetNamespace = "podinfo"
+	k.Spec.Images = []kustomize.Image{{Name: "podinfo", NewTag: "6.5.0"}}
+	k.Spec.CommonMetadata = &kustomizev1.CommonMetadata{
+		Annotations: map[string]string{"team": "platform"},
+		Labels:      map[string]string{"env": "production"},
+	}
+	k.Spec.Patches = []kustomize.Patch{{Patch: "- op: remove\n  path: /spec/replicas"}}
+	k.Spec.Components = []string{"../components/ingress"}
+
+	// namePrefix is only in the manifest
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("kustomize.toolkit.fluxcd.io/v1")
+	u.SetKind("Kustomization")
+	u.SetNamespace("flux-system")
+	u.SetName("apps")
+	unstructured.SetNestedField(u.Object, "prod-", "spec", "namePrefix")
+
+	source, unmapped, err := NewKustomizeSource(NewOfflineClient(kustomizationScheme(), u), context.TODO(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, source.NamePrefix, "prod-")
+	assert.Equal(t, source.NameSuffix, "")
+	assert.Equal(t, source.Namespace, "podinfo")
+	assert.Equal(t, source.Images, v1alpha1.KustomizeImages{"podinfo:6.5.0"})
+	assert.Equal(t, source.CommonAnnotations, map[string]string{"team": "platform"})
+	assert.Equal(t, source.ForceCommonAnnotations, true)
+	assert.Equal(t, len(source.CommonLabels), 0)
+
+	// Labels, patches and components are reported
+	assert.Equal(t, len(unmapped), 3)
+}
+
+func TestNewKustomizationMigration(t *testing.T) {
+	gitSource := &sourcev1.GitRepository{}
+	gitSource.SetName("fleet")
+	gitSource.Spec.URL = "https://github.com/example/fleet"
+	gitSource.Spec.Reference = &sourcev1.GitRepositoryRef{Branch: "main"}
+
+	tests := []struct {
+		name           string
+		kustomization  string
+		mode           string
+		decryption     *kustomizev1.Decryption
+		sopsImage      string
+		expectedErr    bool
+		expectedKind   string
+		expectedName   string
+		expectedPath   string
+		expectedPlugin string
+	}{
+		{
+			name:          "when the output mode is applicationset",
+			kustomization: "apps",
+			mode:          OutputModeApplicationSet,
+			expectedKind:  "ApplicationSet",
+			expectedName:  "flux-system-apps-ks",
+		},
+		{
+			name:          "when the output mode is application",
+			kustomization: "apps",
+			mode:          OutputModeApplication,
+			expectedKind:  "Application",
+			expectedName:  "flux-system-apps-ks",
+			expectedPath:  "clusters/production/apps",
+		},
+		{
+			name:          "when the output mode is application for the bootstrap Kustomization",
+			kustomization: "flux-system",
+			mode:          OutputModeApplication,
+			expectedKind:  "ApplicationSet",
+			expectedName:  "flux-system-flux-system-ks",
+		},
+		{
+			name:           "when the manifests are decrypted",
+			kustomization:  "apps",
+			mode:           OutputModeApplication,
+			decryption:     &kustomizev1.Decryption{Provider: "sops"},
+			sopsImage:      "ghcr.io/example/sops:v1",
+			expectedKind:   "Application",
+			expectedName:   "flux-system-apps-ks",
+			expectedPath:   "clusters/production/apps",
+			expectedPlugin: SOPSPluginName,
+		},
+		{
+			name:          "when the manifests are decrypted without a sops image",
+			kustomization: "apps",
+			mode:          OutputModeApplication,
+			decryption:    &kustomizev1.Decryption{Provider: "sops"},
+			expectedErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &kustomizev1.Kustomization{}
+			k.SetNamespace("flux-system")
+			k.SetName(tt.kustomization)
+			k.Spec.Path = "./clusters/production/apps/"
+			k.Spec.Prune = true
+			k.Spec.Decryption = tt.decryption
+
+			u := &unstructured.Unstructured{}
+			u.SetAPIVersion("kustomize.toolkit.fluxcd.io/v1")
+			u.SetKind("Kustomization")
+			u.SetNamespace("flux-system")
+			u.SetName(tt.kustomization)
+
+			m, err := NewKustomizationMigration(NewOfflineClient(kustomizationScheme(), u), context.TODO(), "argocd", k, gitSource, argo.RepositoryCredentials{}, nil, MigrationOptions{OutputMode: tt.mode, Naming: DefaultNaming(), SOPSPluginImage: tt.sopsImage})
+			if tt.expectedErr {
+				assert.Equal(t, err != nil, true)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, m.App.GetObjectKind().GroupVersionKind().Kind, tt.expectedKind)
+			assert.Equal(t, m.App.GetName(), tt.expectedName)
+			if app, ok := m.App.(*v1alpha1.Application); ok {
+				assert.Equal(t, app.Spec.Source.Path, tt.expectedPath)
+				assert.Equal(t, app.Spec.Source.TargetRevision, "main")
+				assert.Equal(t, app.Spec.SyncPolicy.Automated.Prune, true)
+				if tt.expectedPlugin != "" {
+					assert.Equal(t, app.Spec.Source.Plugin.Name, tt.expectedPlugin)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// loggerKey is the key of the logger in a context
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx the migrations log to l with, instead of the standard logger
+func WithLogger(ctx context.Context, l log.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger of ctx, or the standard logger if it has none
+func LoggerFrom(ctx context.Context) log.FieldLogger {
+	if l, ok := ctx.Value(loggerKey{}).(log.FieldLogger); ok {
+		return l
+	}
+	return log.StandardLogger()
+}
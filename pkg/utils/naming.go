@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultHelmReleaseAppNameTemplate names the Application a HelmRelease is migrated to. Only the
+	// Application, the chart is still installed as the release Flux installed.
+	DefaultHelmReleaseAppNameTemplate = "{{.TargetNamespace}}-{{.Name}}"
+	// DefaultKustomizationNameTemplate names the Application or ApplicationSet a Kustomization is
+	// migrated to. The suffix keeps them apart from HelmReleases with the same name.
+	DefaultKustomizationNameTemplate = "{{.Namespace}}-{{.Name}}-ks"
+	// DefaultRepositorySecretNameTemplate names repository Secrets, one for each repository URL so
+	// migrations from the same repository share it
+	DefaultRepositorySecretNameTemplate = "mta-repo-{{.RepoHash}}"
+	// maxNameLength is the longest name that still fits in a label, like the one Argo CD tracks
+	// resources with
+	maxNameLength = 63
+	// hashLength is how much of a hash goes in a name
+	hashLength = 8
+)
+
+// invalidNameChars are the characters that can't be in a Kubernetes name
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Naming has the templates the names of the objects a migration creates are made from. The
+// templates are text/template templates, executed with NameData.
+type Naming struct {
+	HelmRelease      string
+	Kustomization    string
+	RepositorySecret string
+}
+
+// NameData is what a name template is executed with
+type NameData struct {
+	// Kind, Namespace and Name are of the Flux object being migrated
+	Kind      string
+	Namespace string
+	Name      string
+	// TargetNamespace is where the Flux object deploys to, its own namespace if it isn't set
+	TargetNamespace string
+	// SourceKind, SourceNamespace and SourceName are of the Flux source
+	SourceKind      string
+	SourceNamespace string
+	SourceName      string
+	// RepoURL is the URL of the source and RepoHash a short hash of it
+	RepoURL  string
+	RepoHash string
+}
+
+// DefaultNaming returns the default name templates
+func DefaultNaming() Naming {
+	return Naming{
+		HelmRelease:      DefaultHelmReleaseAppNameTemplate,
+		Kustomization:    DefaultKustomizationNameTemplate,
+		RepositorySecret: DefaultRepositorySecretNameTemplate,
+	}
+}
+
+// NewNameData returns the data for naming what a Flux object is migrated to
+func NewNameData(kind string, namespace string, name string, targetNamespace string) NameData {
+	if targetNamespace == "" {
+		targetNamespace = namespace
+	}
+	return NameData{Kind: kind, Namespace: namespace, Name: name, TargetNamespace: targetNamespace}
+}
+
+// WithSource adds the Flux source and its repository URL to the name data
+func (d NameData) WithSource(kind string, namespace string, name string, repoURL string) NameData {
+	d.SourceKind = kind
+	d.SourceNamespace = namespace
+	d.SourceName = name
+	d.RepoURL = repoURL
+	d.RepoHash = shortHash(repoURL)
+	return d
+}
+
+// HelmReleaseAppName returns the name of the Application a HelmRelease is migrated to, not the name
+// of its Helm release
+func (n Naming) HelmReleaseAppName(d NameData) (string, error) {
+	return executeName(n.HelmRelease, DefaultHelmReleaseAppNameTemplate, d)
+}
+
+// KustomizationName returns the name of the Application or ApplicationSet a Kustomization is
+// migrated to
+func (n Naming) KustomizationName(d NameData) (string, error) {
+	return executeName(n.Kustomization, DefaultKustomizationNameTemplate, d)
+}
+
+// RepositorySecretName returns the name of the repository Secret for a source
+func (n Naming) RepositorySecretName(d NameData) (string, error) {
+	return executeName(n.RepositorySecret, DefaultRepositorySecretNameTemplate, d)
+}
+
+// executeName executes a name template, or the default if it's empty, and makes a valid name of it
+func executeName(tmpl string, defaultTmpl string, d NameData) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultTmpl
+	}
+
+	t, err := template.New("name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parsing the name template %q: %w", tmpl, err)
+	}
+	var name bytes.Buffer
+	if err := t.Execute(&name, d); err != nil {
+		return "", fmt.Errorf("executing the name template %q: %w", tmpl, err)
+	}
+
+	s := SanitizeName(name.String())
+	if s == "" {
+		return "", fmt.Errorf("the name template %q makes an empty name for %s %s/%s", tmpl, d.Kind, d.Namespace, d.Name)
+	}
+	return s, nil
+}
+
+// SanitizeName makes a valid Kubernetes name, that also fits in a label. Names that are too long
+// are cut short and end with a hash of the whole name, so they stay unique.
+func SanitizeName(name string) string {
+	s := invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	s = strings.Trim(s, "-")
+	if len(s) <= maxNameLength {
+		return s
+	}
+
+	// Keep room for the hash
+	return strings.TrimRight(s[:maxNameLength-hashLength-1], "-") + "-" + shortHash(name)
+}
+
+// sanitizeNameTemplate returns an ApplicationSet template for the name SanitizeName makes of prefix
+// and the name of the directory, which is only known when Argo CD generates the Applications. It
+// uses the Sprig functions Argo CD has in Go templates.
+func sanitizeNameTemplate(prefix string) string {
+	return fmt.Sprintf(`{{- $n := print %q .path.basename -}}`+
+		`{{- $s := regexReplaceAll %q (lower $n) "-" | trimAll "-" -}}`+
+		`{{- if le (len $s) %d }}{{ $s }}{{ else }}{{ trunc %d $s | trimAll "-" }}-{{ sha256sum $n | trunc %d }}{{ end -}}`,
+		prefix, invalidNameChars.String(), maxNameLength, maxNameLength-hashLength-1, hashLength)
+}
+
+// shortHash returns the start of the SHA-256 hash of s
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// kustomizationNameData returns the data for naming what a Kustomization is migrated to
+func kustomizationNameData(k *kustomizev1.Kustomization, gitSource *sourcev1.GitRepository) NameData {
+	return NewNameData(kustomizev1.KustomizationKind, k.Namespace, k.Name, k.Spec.TargetNamespace).
+		WithSource(sourcev1.GitRepositoryKind, gitSource.Namespace, gitSource.Name, gitSource.Spec.URL)
+}
+
+// helmReleaseNameData returns the data for naming what a HelmRelease is migrated to
+func helmReleaseNameData(h *helmv2.HelmRelease, source client.Object) NameData {
+	d := NewNameData(helmv2.HelmReleaseKind, h.Namespace, h.Name, h.Spec.TargetNamespace)
+	switch s := source.(type) {
+	case *sourcev1.GitRepository:
+		d = d.WithSource(sourcev1.GitRepositoryKind, s.Namespace, s.Name, s.Spec.URL)
+	case *sourcev1beta2.HelmRepository:
+		d = d.WithSource(sourcev1beta2.HelmRepositoryKind, s.Namespace, s.Name, s.Spec.URL)
+	}
+	return d
+}
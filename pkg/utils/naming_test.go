@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestSanitizeName(t *testing.T) {
+	long := strings.Repeat("podinfo-", 10)
+
+	tests := []struct {
+		name         string
+		in           string
+		expectedName string
+	}{
+		{
+			name:         "when the name is valid",
+			in:           "flux-system-podinfo",
+			expectedName: "flux-system-podinfo",
+		},
+		{
+			name:         "when the name has invalid characters",
+			in:           "Flux_System.podinfo--",
+			expectedName: "flux-system-podinfo",
+		},
+		{
+			name:         "when the name is too long",
+			in:           long,
+			expectedName: "podinfo-podinfo-podinfo-podinfo-podinfo-podinfo-podinf-" + shortHash(long),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := SanitizeName(tt.in)
+			assert.Equal(t, name, tt.expectedName)
+			assert.Equal(t, len(name) <= maxNameLength, true)
+		})
+	}
+}
+
+func TestSanitizeNameTemplate(t *testing.T) {
+	// The Sprig functions the template uses, like Argo CD has them
+	funcs := template.FuncMap{
+		"lower": strings.ToLower,
+		"regexReplaceAll": func(regex string, s string, repl string) string {
+			return regexp.MustCompile(regex).ReplaceAllString(s, repl)
+		},
+		"trimAll": func(cutset string, s string) string { return strings.Trim(s, cutset) },
+		"trunc": func(c int, s string) string {
+			if len(s) > c {
+				return s[:c]
+			}
+			return s
+		},
+		"sha256sum": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		basename string
+	}{
+		{"when the name is valid", "flux-system-apps-ks-", "podinfo"},
+		{"when the directory has invalid characters", "flux-system-apps-ks-", "Pod_Info.v2"},
+		{"when there's no prefix", "", "Infra-Controllers-"},
+		{"when the name is too long", "flux-system-apps-ks-", strings.Repeat("podinfo-", 6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := template.New("name").Funcs(funcs).Parse(sanitizeNameTemplate(tt.prefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var name bytes.Buffer
+			if err := tmpl.Execute(&name, map[string]interface{}{"path": map[string]interface{}{"basename": tt.basename}}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Argo CD makes the same name mta would
+			assert.Equal(t, name.String(), SanitizeName(tt.prefix+tt.basename))
+			assert.Equal(t, len(name.String()) <= maxNameLength, true)
+		})
+	}
+}
+
+func TestNamingTemplates(t *testing.T) {
+	d := NewNameData("HelmRelease", "flux-system", "podinfo", "").
+		WithSource("HelmRepository", "flux-system", "podinfo", "https://stefanprodan.github.io/podinfo")
+
+	tests := []struct {
+		name         string
+		naming       Naming
+		expectedName string
+		expectedErr  bool
+	}{
+		{
+			name:         "when the template is the default",
+			naming:       DefaultNaming(),
+			expectedName: "flux-system-podinfo",
+		},
+		{
+			name:         "when the template is empty",
+			naming:       Naming{},
+			expectedName: "flux-system-podinfo",
+		},
+		{
+			name:         "when the template is custom",
+			naming:       Naming{HelmRelease: "{{.SourceName}}-{{.Name}}-{{.Kind}}"},
+			expectedName: "podinfo-podinfo-helmrelease",
+		},
+		{
+			name:        "when the template has an unknown field",
+			naming:      Naming{HelmRelease: "{{.Cluster}}-{{.Name}}"},
+			expectedErr: true,
+		},
+		{
+			name:        "when the template makes an empty name",
+			naming:      Naming{HelmRelease: "--"},
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := tt.naming.HelmReleaseAppName(d)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			assert.Equal(t, name, tt.expectedName)
+		})
+	}
+
+	// Every source with the same URL gets the same Secret
+	secretName, err := DefaultNaming().RepositorySecretName(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, secretName, "mta-repo-"+shortHash("https://stefanprodan.github.io/podinfo"))
+}
@@ -0,0 +1,405 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/akuity/mta/pkg/argo"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	yaml "sigs.k8s.io/yaml"
+)
+
+// NotificationsConfig is what Argo CD Notifications needs to send the alerts Flux sends for an
+// object
+type NotificationsConfig struct {
+	// ConfigMap are the services, triggers and templates of the NotificationsConfigMap
+	ConfigMap map[string]string
+	// Secret are the credentials of the services in the NotificationsSecret, and SecretRefs the
+	// Secret of the Provider each of them comes from
+	Secret     map[string]string
+	SecretRefs map[string]string
+}
+
+// AlertTriggers returns the triggers an Alert's event severity is sent on. Flux sends errors with
+// either severity, and the other events with info.
+func AlertTriggers(severity string) []string {
+	triggers := []string{argo.TriggerSyncFailed, argo.TriggerHealthDegraded, argo.TriggerSyncStatusUnknown}
+	if severity != "error" {
+		triggers = append(triggers, argo.TriggerDeployed)
+	}
+	return triggers
+}
+
+// AlertMatches tells if an Alert sends the events of obj, of kind kind: one of its event sources
+// names it, or all objects of its kind in its namespace with the labels it matches. Event sources
+// default to the Alert's namespace.
+func AlertMatches(a *notificationv1beta2.Alert, kind string, obj client.Object) bool {
+	for _, s := range a.Spec.EventSources {
+		if s.Kind != kind || getNamespace(s.Namespace, a.Namespace) != obj.GetNamespace() {
+			continue
+		}
+		if s.Name != "*" {
+			if s.Name == obj.GetName() {
+				return true
+			}
+			continue
+		}
+
+		// Every label it matches has to be there
+		matches := true
+		for k, v := range s.MatchLabels {
+			if obj.GetLabels()[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNotificationService translates a Provider into the service Argo CD Notifications sends to,
+// with the recipient to subscribe and the credentials for the NotificationsSecret. Slack, Microsoft
+// Teams, generic webhooks and GitHub commit statuses are carried over.
+func NewNotificationService(c client.Client, ctx context.Context, p *notificationv1beta2.Provider) (argo.NotificationService, string, map[string]string, error) {
+	svc := SanitizeName(p.Namespace + "-" + p.Name)
+	secrets := map[string]string{}
+
+	// Get the Secret the credentials are in, Flux reads the address from it before spec.address
+	data := map[string]string{}
+	if p.Spec.SecretRef != nil {
+		secret := &apiv1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Spec.SecretRef.Name}, secret); err != nil {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("getting the credentials of Provider %s/%s: %w", p.Namespace, p.Name, err)
+		}
+		data = secretData(secret)
+	}
+	if p.Spec.Proxy != "" || data["proxy"] != "" {
+		LoggerFrom(ctx).Warn("Provider " + p.Namespace + "/" + p.Name + " sends through a proxy, Argo CD Notifications sends with the proxy of the notifications controller")
+	}
+	if p.Spec.CertSecretRef != nil {
+		LoggerFrom(ctx).Warn("Provider " + p.Namespace + "/" + p.Name + " trusts the CA in Secret " + p.Spec.CertSecretRef.Name + ", add it to the notifications controller")
+	}
+
+	// Secrets are referenced with their key in the NotificationsSecret
+	ref := func(what string, value string) string {
+		key := svc + "-" + what
+		secrets[key] = value
+		return "$" + key
+	}
+	address := p.Spec.Address
+	if v, ok := data["address"]; ok {
+		address = ref("address", v)
+	}
+
+	switch p.Spec.Type {
+	case notificationv1beta2.SlackProvider:
+		// A token posts to the channel, an address to an incoming webhook
+		if token, ok := data["token"]; ok {
+			config := map[string]interface{}{"token": ref("token", token)}
+			if p.Spec.Username != "" {
+				config["username"] = p.Spec.Username
+			}
+			if p.Spec.Channel == "" {
+				return argo.NotificationService{}, "", nil, fmt.Errorf("it posts with a token but has no channel")
+			}
+			return argo.NotificationService{Type: "slack", Name: svc, Config: config}, p.Spec.Channel, secrets, nil
+		}
+		if address == "" {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("it has no token or address")
+		}
+		return argo.NotificationService{Type: "webhook", Name: svc, Config: map[string]interface{}{"url": address}, WebhookFormat: argo.WebhookSlack}, "", secrets, nil
+
+	case notificationv1beta2.MSTeamsProvider:
+		if address == "" {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("it has no address")
+		}
+		config := map[string]interface{}{"recipientUrls": map[string]interface{}{svc: address}}
+		return argo.NotificationService{Type: "teams", Name: svc, Config: config}, svc, secrets, nil
+
+	case notificationv1beta2.GenericProvider:
+		if address == "" {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("it has no address")
+		}
+		config := map[string]interface{}{"url": address}
+
+		// The headers are a YAML map in the Secret
+		if h, ok := data["headers"]; ok {
+			headers := map[string]string{}
+			if err := yaml.Unmarshal([]byte(h), &headers); err != nil {
+				return argo.NotificationService{}, "", nil, fmt.Errorf("reading its headers: %w", err)
+			}
+			list := []interface{}{}
+			for _, name := range sortedStringKeys(headers) {
+				list = append(list, map[string]interface{}{"name": name, "value": ref("header-"+SanitizeName(name), headers[name])})
+			}
+			config["headers"] = list
+		}
+		return argo.NotificationService{Type: "webhook", Name: svc, Config: config, WebhookFormat: argo.WebhookGeneric}, "", secrets, nil
+
+	case notificationv1beta2.GitHubProvider:
+		// The address is the repository, the statuses are set with the API of its host
+		repo, err := url.Parse(p.Spec.Address)
+		if err != nil || p.Spec.Address == "" {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("its address %q isn't a repository URL", p.Spec.Address)
+		}
+		ownerRepo := strings.TrimSuffix(strings.Trim(repo.Path, "/"), ".git")
+		if strings.Count(ownerRepo, "/") != 1 {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("its address %q isn't a repository URL", p.Spec.Address)
+		}
+		api := "https://api.github.com"
+		if repo.Host != "github.com" {
+			api = "https://" + repo.Host + "/api/v3"
+		}
+		token, ok := data["token"]
+		if !ok {
+			return argo.NotificationService{}, "", nil, fmt.Errorf("it has no token")
+		}
+		config := map[string]interface{}{
+			"url":     api,
+			"headers": []interface{}{map[string]interface{}{"name": "Authorization", "value": "token " + ref("token", token)}},
+		}
+		return argo.NotificationService{
+			Type:          "webhook",
+			Name:          svc,
+			Config:        config,
+			WebhookFormat: argo.WebhookGitHub,
+			WebhookPath:   "/repos/" + ownerRepo + "/statuses/{{.app.status.operationState.syncResult.revision}}",
+		}, "", secrets, nil
+	}
+
+	return argo.NotificationService{}, "", nil, fmt.Errorf("Argo CD Notifications has no %s service mta can configure", p.Spec.Type)
+}
+
+// AddNotifications subscribes what a Kustomization or HelmRelease is migrated to, app, to the
+// triggers of the Alerts that send its events or the events of its source. It returns what Argo CD
+// Notifications needs to send them, nil if no Alert sends its events. Alerts that can't be carried
+// over are warned about, so they don't go quiet unnoticed.
+func AddNotifications(c client.Client, ctx context.Context, obj client.Object, source client.Object, app client.Object) (*NotificationsConfig, error) {
+	al := &notificationv1beta2.AlertList{}
+	err := c.List(ctx, al)
+	if isMissingKind(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n := &NotificationsConfig{Secret: map[string]string{}, SecretRefs: map[string]string{}}
+	services := map[string]argo.NotificationService{}
+	subs := []argo.Subscription{}
+	kind, sourceKind := kindOf(c, obj), kindOf(c, source)
+	for i := range al.Items {
+		a := &al.Items[i]
+		if !AlertMatches(a, kind, obj) && !AlertMatches(a, sourceKind, source) {
+			continue
+		}
+		if a.Spec.Suspend {
+			LoggerFrom(ctx).Warn("Alert " + a.Namespace + "/" + a.Name + " is suspended, it isn't carried over to " + describeObject(c, app))
+			continue
+		}
+
+		// Get the Provider the Alert sends to
+		p := &notificationv1beta2.Provider{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: a.Namespace, Name: a.Spec.ProviderRef.Name}, p)
+		if apierrors.IsNotFound(err) {
+			LoggerFrom(ctx).Warn("Provider \"" + a.Spec.ProviderRef.Name + "\" of Alert " + a.Namespace + "/" + a.Name + " was not found, it isn't carried over to Argo CD Notifications")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.Spec.Suspend {
+			LoggerFrom(ctx).Warn("Provider " + p.Namespace + "/" + p.Name + " is suspended, Alert " + a.Namespace + "/" + a.Name + " isn't carried over")
+			continue
+		}
+		service, recipient, secrets, err := NewNotificationService(c, ctx, p)
+		if err != nil {
+			LoggerFrom(ctx).Warn("Alert " + a.Namespace + "/" + a.Name + " isn't carried over to Argo CD Notifications, Provider " + p.Namespace + "/" + p.Name + " can't be: " + err.Error())
+			continue
+		}
+		services[service.Name] = service
+		for k, v := range secrets {
+			n.Secret[k] = v
+			n.SecretRefs[k] = "Secret " + p.Namespace + "/" + p.Spec.SecretRef.Name
+		}
+
+		for _, t := range AlertTriggers(a.Spec.EventSeverity) {
+			subs = append(subs, argo.Subscription{Trigger: t, Service: service.Name, Recipient: recipient})
+		}
+		if a.Spec.Summary != "" || len(a.Spec.ExclusionList) > 0 {
+			LoggerFrom(ctx).Warn("Alert " + a.Namespace + "/" + a.Name + " has a summary or an exclusion list, Argo CD Notifications sends its own messages without them")
+		}
+	}
+	if len(services) == 0 {
+		return nil, nil
+	}
+	argo.SetSubscriptions(app, subs)
+
+	list := []argo.NotificationService{}
+	for _, name := range sortedServiceNames(services) {
+		list = append(list, services[name])
+	}
+	if n.ConfigMap, err = argo.GenNotificationsConfigData(list); err != nil {
+		return nil, err
+	}
+	LoggerFrom(ctx).Warn(describeObject(c, obj) + " has Flux alerts, Argo CD Notifications has to be installed to keep sending them")
+
+	// If we're here, it should have gone okay...
+	return n, nil
+}
+
+// Merge adds the services, triggers and templates of another NotificationsConfig, with the
+// webhooks of both
+func (n *NotificationsConfig) Merge(other *NotificationsConfig) {
+	if other == nil {
+		return
+	}
+	if n.ConfigMap == nil {
+		n.ConfigMap, n.Secret, n.SecretRefs = map[string]string{}, map[string]string{}, map[string]string{}
+	}
+	for k, v := range other.ConfigMap {
+		if old, ok := n.ConfigMap[k]; ok {
+			v = MergeYAML(old, v)
+		}
+		n.ConfigMap[k] = v
+	}
+	for k, v := range other.Secret {
+		n.Secret[k] = v
+	}
+	for k, v := range other.SecretRefs {
+		n.SecretRefs[k] = v
+	}
+}
+
+// WarnNotifications tells the user what to add to the NotificationsConfigMap and NotificationsSecret
+// by hand. Printing them to be applied would replace what's in them, and the credentials stay where
+// they are.
+func WarnNotifications(n *NotificationsConfig) {
+	if n == nil {
+		return
+	}
+	for _, k := range sortedStringKeys(n.ConfigMap) {
+		log.Warn("Merge into the \"", k, "\" key of the ", argo.NotificationsConfigMap, " ConfigMap: ", n.ConfigMap[k])
+	}
+	for _, k := range sortedStringKeys(n.SecretRefs) {
+		log.Warn("Set the \"", k, "\" key of the ", argo.NotificationsSecret, " Secret to the credential in ", n.SecretRefs[k])
+	}
+}
+
+// AddNotifications configures Argo CD Notifications to send the alerts of a migration. Rollback
+// puts the ConfigMap and Secret back as they were.
+func (t *MigrationTransaction) AddNotifications(ns string, n *NotificationsConfig) error {
+	if n == nil {
+		return nil
+	}
+	if err := t.MergeConfigMap(ns, argo.NotificationsConfigMap, n.ConfigMap, MergeYAML); err != nil {
+		return err
+	}
+	if len(n.Secret) == 0 {
+		return nil
+	}
+	return t.MergeSecret(ns, argo.NotificationsSecret, n.Secret)
+}
+
+// MergeYAML deep merges YAML maps, like the templates of the NotificationsConfigMap with the
+// webhooks of other migrations. The values being added win, anything that isn't a map is replaced.
+func MergeYAML(existing string, added string) string {
+	old, add := map[string]interface{}{}, map[string]interface{}{}
+	if yaml.Unmarshal([]byte(existing), &old) != nil || yaml.Unmarshal([]byte(added), &add) != nil {
+		return added
+	}
+	merged, err := yaml.Marshal(mergeValues(old, add))
+	if err != nil {
+		return added
+	}
+	return string(merged)
+}
+
+// MergeSecret adds data to a Secret that may already be there, like the NotificationsSecret. It's
+// created if it doesn't exist, otherwise only the changed keys are patched. Rollback deletes the
+// Secret if it was created, or puts the changed keys back as they were.
+func (t *MigrationTransaction) MergeSecret(ns string, name string, data map[string]string) error {
+	existing := &apiv1.Secret{}
+	err := t.client.Get(t.ctx, types.NamespacedName{Namespace: ns, Name: name}, existing)
+	if apierrors.IsNotFound(err) {
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Type:       apiv1.SecretTypeOpaque,
+			Data:       map[string][]byte{},
+		}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		secret.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("Secret"))
+		return t.CreateIfMissing(secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Other migrations may have created it, keep it from being deleted when they're rolled back
+	if err := t.share("use", existing, false); err != nil {
+		return err
+	}
+
+	// Only patch what changes, and keep what was there before so it can be put back
+	changed := map[string]interface{}{}
+	previous := map[string]interface{}{}
+	for k, v := range data {
+		old, ok := existing.Data[k]
+		if ok && string(old) == v {
+			continue
+		}
+		changed[k] = []byte(v)
+		previous[k] = nil
+		if ok {
+			previous[k] = old
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"data": changed})
+	if err != nil {
+		return err
+	}
+	undo, err := json.Marshal(map[string]interface{}{"data": previous})
+	if err != nil {
+		return err
+	}
+	return t.Patch("merge", existing, client.RawPatch(types.MergePatchType, patch), client.RawPatch(types.MergePatchType, undo))
+}
+
+// sortedStringKeys returns the keys of a map in order
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedServiceNames returns the names of services in order
+func sortedServiceNames(m map[string]argo.NotificationService) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/akuity/mta/pkg/argo"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	notificationv1beta2 "github.com/fluxcd/notification-controller/api/v1beta2"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAlertMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   notificationv1beta2.CrossNamespaceObjectReference
+		expected bool
+	}{
+		{"By name", notificationv1beta2.CrossNamespaceObjectReference{Kind: "Kustomization", Name: "podinfo"}, true},
+		{"Another name", notificationv1beta2.CrossNamespaceObjectReference{Kind: "Kustomization", Name: "other"}, false},
+		{"Another kind", notificationv1beta2.CrossNamespaceObjectReference{Kind: "HelmRelease", Name: "podinfo"}, false},
+		{"Another namespace", notificationv1beta2.CrossNamespaceObjectReference{Kind: "Kustomization", Name: "podinfo", Namespace: "apps"}, false},
+		{"All with the labels", notificationv1beta2.CrossNamespaceObjectReference{Kind: "Kustomization", Name: "*", MatchLabels: map[string]string{"team": "a"}}, true},
+		{"All without the labels", notificationv1beta2.CrossNamespaceObjectReference{Kind: "Kustomization", Name: "*", MatchLabels: map[string]string{"team": "b"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &notificationv1beta2.Alert{
+				ObjectMeta: metav1.ObjectMeta{Name: "oncall", Namespace: "flux-system"},
+				Spec:       notificationv1beta2.AlertSpec{EventSources: []notificationv1beta2.CrossNamespaceObjectReference{tt.source}},
+			}
+			k := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "flux-system", Labels: map[string]string{"team": "a"}}}
+			assert.Equal(t, AlertMatches(a, "Kustomization", k), tt.expected)
+		})
+	}
+}
+
+func TestAlertTriggers(t *testing.T) {
+	assert.Equal(t, AlertTriggers("error"), []string{"on-sync-failed", "on-health-degraded", "on-sync-status-unknown"})
+	assert.Equal(t, AlertTriggers("info"), []string{"on-sync-failed", "on-health-degraded", "on-sync-status-unknown", "on-deployed"})
+	assert.Equal(t, AlertTriggers(""), AlertTriggers("info"))
+}
+
+func TestNewNotificationService(t *testing.T) {
+	tests := []struct {
+		name              string
+		spec              notificationv1beta2.ProviderSpec
+		secret            map[string]string
+		expectedType      string
+		expectedRecipient string
+		expectedConfig    string
+		expectedSecret    map[string]string
+		expectedError     bool
+	}{
+		{
+			name:              "Slack with a token",
+			spec:              notificationv1beta2.ProviderSpec{Type: "slack", Channel: "oncall", Username: "flux"},
+			secret:            map[string]string{"token": "xoxb"},
+			expectedType:      "slack",
+			expectedRecipient: "oncall",
+			expectedConfig:    "token: $flux-system-alerts-token\nusername: flux\n",
+			expectedSecret:    map[string]string{"flux-system-alerts-token": "xoxb"},
+		},
+		{
+			name:           "Slack incoming webhook",
+			spec:           notificationv1beta2.ProviderSpec{Type: "slack", Address: "https://hooks.slack.com/services/x"},
+			expectedType:   "webhook",
+			expectedConfig: "url: https://hooks.slack.com/services/x\n",
+			expectedSecret: map[string]string{},
+		},
+		{
+			name:              "Teams with the address in the Secret",
+			spec:              notificationv1beta2.ProviderSpec{Type: "msteams"},
+			secret:            map[string]string{"address": "https://teams.example.com/hook"},
+			expectedType:      "teams",
+			expectedRecipient: "flux-system-alerts",
+			expectedConfig:    "recipientUrls:\n  flux-system-alerts: $flux-system-alerts-address\n",
+			expectedSecret:    map[string]string{"flux-system-alerts-address": "https://teams.example.com/hook"},
+		},
+		{
+			name:           "Generic webhook with headers",
+			spec:           notificationv1beta2.ProviderSpec{Type: "generic", Address: "https://alerts.example.com"},
+			secret:         map[string]string{"headers": "X-Token: abc"},
+			expectedType:   "webhook",
+			expectedConfig: "headers:\n- name: X-Token\n  value: $flux-system-alerts-header-x-token\nurl: https://alerts.example.com\n",
+			expectedSecret: map[string]string{"flux-system-alerts-header-x-token": "abc"},
+		},
+		{
+			name:           "GitHub commit status",
+			spec:           notificationv1beta2.ProviderSpec{Type: "github", Address: "https://github.com/example/app.git"},
+			secret:         map[string]string{"token": "ghp"},
+			expectedType:   "webhook",
+			expectedConfig: "headers:\n- name: Authorization\n  value: token $flux-system-alerts-token\nurl: https://api.github.com\n",
+			expectedSecret: map[string]string{"flux-system-alerts-token": "ghp"},
+		},
+		{
+			name:          "Unsupported type",
+			spec:          notificationv1beta2.ProviderSpec{Type: "discord", Address: "https://discord.example.com"},
+			expectedError: true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []*unstructured.Unstructured{}
+			if tt.secret != nil {
+				tt.spec.SecretRef = &meta.LocalObjectReference{Name: "alerts"}
+				secret := &unstructured.Unstructured{}
+				secret.SetAPIVersion("v1")
+				secret.SetKind("Secret")
+				secret.SetNamespace("flux-system")
+				secret.SetName("alerts")
+				unstructured.SetNestedStringMap(secret.Object, tt.secret, "stringData")
+				objs = append(objs, secret)
+			}
+			p := &notificationv1beta2.Provider{ObjectMeta: metav1.ObjectMeta{Name: "alerts", Namespace: "flux-system"}, Spec: tt.spec}
+
+			service, recipient, secrets, err := NewNotificationService(NewOfflineClient(scheme, objs...), context.TODO(), p)
+			assert.Equal(t, err != nil, tt.expectedError)
+			if err != nil {
+				return
+			}
+			data, err := argo.GenNotificationsConfigData([]argo.NotificationService{service})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, service.Type, tt.expectedType)
+			assert.Equal(t, recipient, tt.expectedRecipient)
+			assert.Equal(t, data["service."+service.Type+".flux-system-alerts"], tt.expectedConfig)
+			assert.Equal(t, secrets, tt.expectedSecret)
+		})
+	}
+}
+
+func TestMergeYAML(t *testing.T) {
+	existing := "message: hello\nwebhook:\n  a:\n    method: POST\n"
+	added := "message: hello\nwebhook:\n  b:\n    method: POST\n"
+	merged := MergeYAML(existing, added)
+	assert.Equal(t, strings.Contains(merged, "  a:\n"), true)
+	assert.Equal(t, strings.Contains(merged, "  b:\n"), true)
+}
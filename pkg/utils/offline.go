@@ -0,0 +1,701 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// clusterScopedKinds are the kinds the offline client treats as not namespaced
+var clusterScopedKinds = map[string]bool{
+	"Namespace":                true,
+	"ClusterRole":              true,
+	"ClusterRoleBinding":       true,
+	"CustomResourceDefinition": true,
+}
+
+// LoadManifests reads every YAML or JSON document in the given files and directories.
+// Directories are walked recursively and only files ending in .yaml, .yml or .json are read.
+func LoadManifests(files []string, dirs []string) ([]*unstructured.Unstructured, error) {
+	paths := append([]string{}, files...)
+
+	// Collect the manifests in the directories
+	for _, d := range dirs {
+		err := filepath.WalkDir(d, func(p string, e os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if e.IsDir() {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(p)) {
+			case ".yaml", ".yml", ".json":
+				paths = append(paths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := DecodeManifests(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		objs = append(objs, decoded...)
+	}
+
+	return objs, nil
+}
+
+// DecodeManifests decodes a multi-document YAML or JSON stream. Empty documents and
+// documents without a kind (like a kustomization.yaml) are skipped; Lists are flattened.
+func DecodeManifests(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := decoder.Decode(&u.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+
+		// Skip empty documents and things that are not Kubernetes objects
+		if len(u.Object) == 0 || u.GetKind() == "" || u.GetAPIVersion() == "" {
+			continue
+		}
+
+		if u.IsList() {
+			if err := u.EachListItem(func(o runtime.Object) error {
+				objs = append(objs, o.(*unstructured.Unstructured))
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objs = append(objs, u)
+	}
+
+	return objs, nil
+}
+
+// offlineClient is the client.Client used when working from manifests instead of a cluster. The
+// objects are kept by client-go's object tracker, the store behind the clientset and
+// controller-runtime fakes; the controller-runtime fake itself needs newer Kubernetes libraries
+// than the ones Argo CD pins. Each kind is stored at a single version, so a GitRepository written
+// as v1beta2 can be read as v1. The fields of a manifest the typed API doesn't have (like
+// spec.insecure of a HelmRepository) are kept on the side, and given back to unstructured reads.
+type offlineClient struct {
+	scheme  *runtime.Scheme
+	mapper  meta.RESTMapper
+	tracker k8stesting.ObjectTracker
+	react   k8stesting.ReactionFunc
+	mu      sync.Mutex
+	// extras are the fields dropped from each typed object, by resource and object key
+	extras   map[schema.GroupVersionResource]map[types.NamespacedName]map[string]interface{}
+	extrasMu sync.Mutex
+}
+
+// NewOfflineClient returns a client.Client backed by an in-memory object tracker holding objs
+func NewOfflineClient(scheme *runtime.Scheme, objs ...*unstructured.Unstructured) client.Client {
+	tracker := k8stesting.NewObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder())
+	c := &offlineClient{
+		scheme:  scheme,
+		tracker: tracker,
+		react:   k8stesting.ObjectReaction(tracker),
+		extras:  map[schema.GroupVersionResource]map[types.NamespacedName]map[string]interface{}{},
+	}
+
+	// Set up a RESTMapper for everything the scheme knows about
+	mapper := meta.NewDefaultRESTMapper(scheme.PrioritizedVersionsAllGroups())
+	for gvk := range scheme.AllKnownTypes() {
+		scope := meta.RESTScopeNamespace
+		if clusterScopedKinds[gvk.Kind] {
+			scope = meta.RESTScopeRoot
+		}
+		mapper.Add(gvk, scope)
+	}
+	c.mapper = mapper
+
+	// The last of two manifests for the same object wins
+	for _, o := range objs {
+		stored, gvr, err := c.toStored(o)
+		if err == nil {
+			if err = c.tracker.Create(gvr, stored, o.GetNamespace()); apierrors.IsAlreadyExists(err) {
+				err = c.tracker.Update(gvr, stored, o.GetNamespace())
+			}
+		}
+		if err == nil {
+			err = c.keepExtras(gvr, o, stored)
+		}
+		if err != nil {
+			log.Warnf("Skipping %s %s/%s: %s", o.GetKind(), o.GetNamespace(), o.GetName(), err)
+		}
+	}
+
+	return c
+}
+
+// storedKind returns the kind obj is stored as, the first version of its group in the scheme that
+// has the kind, or its own version if the scheme doesn't know it
+func (c *offlineClient) storedKind(obj runtime.Object) (schema.GroupVersionKind, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return gvk, err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	for _, gv := range c.scheme.PrioritizedVersionsForGroup(gvk.Group) {
+		if c.scheme.Recognizes(gv.WithKind(gvk.Kind)) {
+			return gv.WithKind(gvk.Kind), nil
+		}
+	}
+	return gvk, nil
+}
+
+// resource returns the resource obj is tracked under
+func (c *offlineClient) resource(obj runtime.Object) (schema.GroupVersionResource, error) {
+	gvk, err := c.storedKind(obj)
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	return gvr, nil
+}
+
+// toStored converts obj to the kind it's stored as, typed if the scheme knows it so the tracker
+// can list and patch it. It goes through JSON, the unstructured converter can't handle some of the
+// Argo CD types.
+func (c *offlineClient) toStored(obj runtime.Object) (runtime.Object, schema.GroupVersionResource, error) {
+	gvk, err := c.storedKind(obj)
+	if err != nil {
+		return nil, schema.GroupVersionResource{}, err
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+
+	stored, err := c.scheme.New(gvk)
+	if err != nil {
+		stored = &unstructured.Unstructured{}
+	}
+	if err := convert(obj, gvk, stored); err != nil {
+		return nil, gvr, err
+	}
+	return stored, gvr, nil
+}
+
+// fromStored fills obj (typed or unstructured) from a tracked object, at the version obj asks for.
+// An unstructured obj gets the fields the typed object dropped back.
+func (c *offlineClient) fromStored(gvr schema.GroupVersionResource, stored runtime.Object, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	if err := convert(stored, gvk, obj); err != nil {
+		return err
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		mergeMissing(u.Object, c.getExtras(gvr, u.GetNamespace(), u.GetName()))
+	}
+	return nil
+}
+
+// keepExtras remembers the fields of obj that stored, its typed copy, doesn't have. Only
+// unstructured objects can carry them, a typed write leaves what was kept alone.
+func (c *offlineClient) keepExtras(gvr schema.GroupVersionResource, obj runtime.Object, stored runtime.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	if _, ok := stored.(*unstructured.Unstructured); ok {
+		return nil
+	}
+	gvk, err := c.storedKind(obj)
+	if err != nil {
+		return err
+	}
+	known := &unstructured.Unstructured{}
+	if err := convert(stored, gvk, known); err != nil {
+		return err
+	}
+	c.setExtras(gvr, u.GetNamespace(), u.GetName(), dropped(u.Object, known.Object))
+	return nil
+}
+
+// getExtras returns the fields kept for an object, nil if there are none
+func (c *offlineClient) getExtras(gvr schema.GroupVersionResource, namespace, name string) map[string]interface{} {
+	c.extrasMu.Lock()
+	defer c.extrasMu.Unlock()
+	return runtime.DeepCopyJSON(c.extras[gvr][types.NamespacedName{Namespace: namespace, Name: name}])
+}
+
+// setExtras replaces the fields kept for an object, removing them when extras is empty
+func (c *offlineClient) setExtras(gvr schema.GroupVersionResource, namespace, name string, extras map[string]interface{}) {
+	c.extrasMu.Lock()
+	defer c.extrasMu.Unlock()
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if len(extras) == 0 {
+		delete(c.extras[gvr], key)
+		return
+	}
+	if c.extras[gvr] == nil {
+		c.extras[gvr] = map[types.NamespacedName]map[string]interface{}{}
+	}
+	c.extras[gvr][key] = extras
+}
+
+// dropped returns the fields of full that known doesn't have. Lists are compared as a whole.
+func dropped(full, known map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range full {
+		kv, ok := known[k]
+		if !ok {
+			out[k] = runtime.DeepCopyJSONValue(v)
+			continue
+		}
+		m, isMap := v.(map[string]interface{})
+		km, knownIsMap := kv.(map[string]interface{})
+		if isMap && knownIsMap {
+			if d := dropped(m, km); len(d) > 0 {
+				out[k] = d
+			}
+		}
+	}
+	return out
+}
+
+// mergeMissing adds the fields of extras that dst doesn't have to dst
+func mergeMissing(dst, extras map[string]interface{}) {
+	for k, v := range extras {
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		m, isMap := v.(map[string]interface{})
+		dm, dstIsMap := dv.(map[string]interface{})
+		if isMap && dstIsMap {
+			mergeMissing(dm, m)
+		}
+	}
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to obj
+func mergePatch(obj, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(obj, k)
+			continue
+		}
+		pm, isMap := v.(map[string]interface{})
+		if !isMap {
+			obj[k] = v
+			continue
+		}
+		om, ok := obj[k].(map[string]interface{})
+		if !ok {
+			om = map[string]interface{}{}
+			obj[k] = om
+		}
+		mergePatch(om, pm)
+	}
+}
+
+// convert copies in to out through JSON, setting the kind of out to gvk. out is reset first so
+// fields that are gone (like removed finalizers) don't linger.
+func convert(in runtime.Object, gvk schema.GroupVersionKind, out runtime.Object) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(out).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	if err := json.Unmarshal(data, out); err != nil {
+		return err
+	}
+	out.GetObjectKind().SetGroupVersionKind(gvk)
+	return nil
+}
+
+// isDryRun reports whether a write was requested as a server-side dry-run
+func isDryRun(dryRun []string) bool {
+	return len(dryRun) > 0 && dryRun[0] == metav1.DryRunAll
+}
+
+// Get retrieves an object from the tracker
+func (c *offlineClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	gvr, err := c.resource(obj)
+	if err != nil {
+		return err
+	}
+	_, o, err := c.react(k8stesting.NewGetAction(gvr, key.Namespace, key.Name))
+	if err != nil {
+		return err
+	}
+	return c.fromStored(gvr, o, obj)
+}
+
+// List retrieves the tracked objects that match the namespace, label and field selectors. The
+// tracker only filters by namespace, the selectors are matched here like the fake does.
+func (c *offlineClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, c.scheme)
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	stored, err := c.storedKind(list)
+	if err != nil {
+		return err
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(stored)
+
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	_, o, err := c.react(k8stesting.NewListAction(gvr, stored, listOpts.Namespace, metav1.ListOptions{}))
+	if err != nil {
+		return err
+	}
+	objs, err := meta.ExtractList(o)
+	if err != nil {
+		return err
+	}
+
+	var items []runtime.Object
+	for _, s := range objs {
+		if ok, err := c.matchesListOptions(gvr, s, listOpts); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var item runtime.Object
+		if _, ok := list.(*unstructured.UnstructuredList); ok {
+			item = &unstructured.Unstructured{}
+		} else if item, err = c.scheme.New(gvk); err != nil {
+			return err
+		}
+		item.GetObjectKind().SetGroupVersionKind(gvk)
+		if err := c.fromStored(gvr, s, item); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+
+	return meta.SetList(list, items)
+}
+
+// matchesListOptions checks a tracked object against the label and field selectors of a List call.
+// Any field can be selected on, by its dotted path like spec.type, not only the ones the API server
+// indexes.
+func (c *offlineClient) matchesListOptions(gvr schema.GroupVersionResource, obj runtime.Object, opts client.ListOptions) (bool, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return false, err
+	}
+	if opts.LabelSelector != nil && !opts.LabelSelector.Matches(labels.Set(m.GetLabels())) {
+		return false, nil
+	}
+	if opts.FieldSelector == nil || opts.FieldSelector.Empty() {
+		return true, nil
+	}
+
+	gvk, err := c.storedKind(obj)
+	if err != nil {
+		return false, err
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	if err := c.fromStored(gvr, obj, u); err != nil {
+		return false, err
+	}
+	set := fields.Set{}
+	for _, r := range opts.FieldSelector.Requirements() {
+		v, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(r.Field, ".")...)
+		if err != nil {
+			return false, err
+		}
+		if found && v != nil {
+			set[r.Field] = fmt.Sprint(v)
+		}
+	}
+	return opts.FieldSelector.Matches(set), nil
+}
+
+// exists returns the NotFound error the tracker would for a missing object, for dry-runs
+func (c *offlineClient) exists(obj client.Object) error {
+	gvr, err := c.resource(obj)
+	if err != nil {
+		return err
+	}
+	_, err = c.tracker.Get(gvr, obj.GetNamespace(), obj.GetName())
+	return err
+}
+
+// Create adds a new object to the tracker
+func (c *offlineClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	createOpts := client.CreateOptions{}
+	createOpts.ApplyOptions(opts)
+
+	stored, gvr, err := c.toStored(obj)
+	if err != nil {
+		return err
+	}
+	if isDryRun(createOpts.DryRun) {
+		if err := c.exists(obj); err == nil {
+			return apierrors.NewAlreadyExists(gvr.GroupResource(), obj.GetName())
+		}
+		return nil
+	}
+
+	_, o, err := c.react(k8stesting.NewCreateAction(gvr, obj.GetNamespace(), stored))
+	if err != nil {
+		return err
+	}
+	c.setExtras(gvr, obj.GetNamespace(), obj.GetName(), nil)
+	if err := c.keepExtras(gvr, obj, o); err != nil {
+		return err
+	}
+	return c.fromStored(gvr, o, obj)
+}
+
+// Delete removes an object from the tracker
+func (c *offlineClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	deleteOpts := client.DeleteOptions{}
+	deleteOpts.ApplyOptions(opts)
+
+	gvr, err := c.resource(obj)
+	if err != nil {
+		return err
+	}
+	if isDryRun(deleteOpts.DryRun) {
+		return c.exists(obj)
+	}
+
+	if _, _, err = c.react(k8stesting.NewDeleteAction(gvr, obj.GetNamespace(), obj.GetName())); err != nil {
+		return err
+	}
+	c.setExtras(gvr, obj.GetNamespace(), obj.GetName(), nil)
+	return nil
+}
+
+// Update replaces an object in the tracker
+func (c *offlineClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	updateOpts := client.UpdateOptions{}
+	updateOpts.ApplyOptions(opts)
+
+	stored, gvr, err := c.toStored(obj)
+	if err != nil {
+		return err
+	}
+	if isDryRun(updateOpts.DryRun) {
+		return c.exists(obj)
+	}
+
+	_, o, err := c.react(k8stesting.NewUpdateAction(gvr, obj.GetNamespace(), stored))
+	if err != nil {
+		return err
+	}
+	if err := c.keepExtras(gvr, obj, o); err != nil {
+		return err
+	}
+	return c.fromStored(gvr, o, obj)
+}
+
+// Patch applies a JSON, merge or strategic merge patch to a tracked object. A dry-run patch is
+// applied and then undone, so obj still gets the result. Merge patches also apply to the fields
+// the typed object doesn't have.
+func (c *offlineClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	patchOpts := client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+
+	gvr, err := c.resource(obj)
+	if err != nil {
+		return err
+	}
+	original, err := c.tracker.Get(gvr, obj.GetNamespace(), obj.GetName())
+	if err != nil {
+		return err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+
+	_, o, err := c.react(k8stesting.NewPatchAction(gvr, obj.GetNamespace(), obj.GetName(), patch.Type(), data))
+	if err != nil {
+		return err
+	}
+	if isDryRun(patchOpts.DryRun) {
+		if err := c.tracker.Update(gvr, original, obj.GetNamespace()); err != nil {
+			return err
+		}
+	}
+
+	// The fields the typed object doesn't have are patched on their own
+	extras := c.getExtras(gvr, obj.GetNamespace(), obj.GetName())
+	if patch.Type() == types.MergePatchType && len(extras) > 0 {
+		var p map[string]interface{}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		gvk, err := c.storedKind(obj)
+		if err != nil {
+			return err
+		}
+		known := &unstructured.Unstructured{}
+		if err := convert(o, gvk, known); err != nil {
+			return err
+		}
+		full := runtime.DeepCopyJSON(known.Object)
+		mergeMissing(full, extras)
+		mergePatch(full, p)
+		extras = dropped(full, known.Object)
+		if !isDryRun(patchOpts.DryRun) {
+			c.setExtras(gvr, obj.GetNamespace(), obj.GetName(), extras)
+		}
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	if err := convert(o, gvk, obj); err != nil {
+		return err
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		mergeMissing(u.Object, extras)
+	}
+	return nil
+}
+
+// DeleteAllOf removes all objects of the given type matching the options
+func (c *offlineClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	deleteOpts := client.DeleteAllOfOptions{}
+	deleteOpts.ApplyOptions(opts)
+
+	gvk, err := c.storedKind(obj)
+	if err != nil {
+		return err
+	}
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	if isDryRun(deleteOpts.DryRun) {
+		return nil
+	}
+
+	list, err := c.tracker.List(gvr, gvk, deleteOpts.Namespace)
+	if err != nil {
+		return err
+	}
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, o := range objs {
+		if ok, err := c.matchesListOptions(gvr, o, deleteOpts.ListOptions); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		m, _ := meta.Accessor(o)
+		if err := c.tracker.Delete(gvr, m.GetNamespace(), m.GetName()); err != nil {
+			return err
+		}
+		c.setExtras(gvr, m.GetNamespace(), m.GetName(), nil)
+	}
+	return nil
+}
+
+// Status returns a writer for the status subresource, which is not supported offline
+func (c *offlineClient) Status() client.SubResourceWriter {
+	return &offlineSubResourceClient{}
+}
+
+// SubResource returns a client for the named subresource, which is not supported offline
+func (c *offlineClient) SubResource(subResource string) client.SubResourceClient {
+	return &offlineSubResourceClient{}
+}
+
+// Scheme returns the scheme this client is using
+func (c *offlineClient) Scheme() *runtime.Scheme {
+	return c.scheme
+}
+
+// RESTMapper returns the RESTMapper this client is using
+func (c *offlineClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
+
+// GroupVersionKindFor returns the GroupVersionKind for the given object
+func (c *offlineClient) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, c.scheme)
+}
+
+// IsObjectNamespaced returns true if the GroupVersionKind of the object is namespaced
+func (c *offlineClient) IsObjectNamespaced(obj runtime.Object) (bool, error) {
+	return apiutil.IsObjectNamespaced(obj, c.scheme, c.mapper)
+}
+
+// errSubResource is returned for any subresource call on the offline client
+var errSubResource = errors.New("subresources are not supported by the offline client")
+
+// offlineSubResourceClient rejects every subresource request
+type offlineSubResourceClient struct{}
+
+func (s *offlineSubResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	return errSubResource
+}
+
+func (s *offlineSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return errSubResource
+}
+
+func (s *offlineSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	return errSubResource
+}
+
+func (s *offlineSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return errSubResource
+}
+
+// make sure offlineClient satisfies the client.Client interface
+var _ client.Client = (*offlineClient)(nil)
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/magiconair/properties/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const offlineManifests = `
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: GitRepository
+metadata:
+  name: podinfo
+  namespace: flux-system
+spec:
+  url: https://github.com/stefanprodan/podinfo
+  ref:
+    branch: master
+---
+# an empty document and a kustomize config file should be skipped
+---
+apiVersion: kustomize.config.k8s.io/v1beta1
+resources:
+  - podinfo.yaml
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: flux-system
+spec:
+  chart:
+    spec:
+      chart: podinfo
+      sourceRef:
+        kind: HelmRepository
+        name: podinfo
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: redis
+  namespace: apps
+spec:
+  chart:
+    spec:
+      chart: redis
+      sourceRef:
+        kind: HelmRepository
+        name: bitnami
+`
+
+func TestOfflineClient(t *testing.T) {
+	scheme := runtime.NewScheme()
+	sourcev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+
+	objs, err := DecodeManifests(strings.NewReader(offlineManifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(objs), 3)
+
+	c := NewOfflineClient(scheme, objs...)
+	ctx := context.TODO()
+
+	// The GitRepository was written as v1beta2 but should be readable as v1
+	gitRepo := &sourcev1.GitRepository{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, gitRepo); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gitRepo.Spec.URL, "https://github.com/stefanprodan/podinfo")
+	assert.Equal(t, gitRepo.Spec.Reference.Branch, "master")
+
+	// Listing across all namespaces and in a single namespace
+	hrList := &helmv2.HelmReleaseList{}
+	if err := c.List(ctx, hrList); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(hrList.Items), 2)
+
+	if err := c.List(ctx, hrList, client.InNamespace("apps")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(hrList.Items), 1)
+	assert.Equal(t, hrList.Items[0].Name, "redis")
+
+	// Patches and deletes are applied to the in-memory store
+	if err := c.Patch(ctx, gitRepo, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"suspend":true}}`))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gitRepo.Spec.Suspend, true)
+
+	if err := c.Delete(ctx, gitRepo); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, &sourcev1.GitRepository{})
+	assert.Equal(t, err != nil, true)
+}
+
+func TestOfflineClientUnknownFields(t *testing.T) {
+	scheme := runtime.NewScheme()
+	sourcev1beta2.AddToScheme(scheme)
+
+	objs, err := DecodeManifests(strings.NewReader(`
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: charts
+  namespace: flux-system
+spec:
+  type: oci
+  url: oci://registry.local/charts
+  insecure: true
+---
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: flux-system
+spec:
+  url: https://stefanprodan.github.io/podinfo
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewOfflineClient(scheme, objs...)
+	ctx := context.TODO()
+	key := types.NamespacedName{Namespace: "flux-system", Name: "charts"}
+
+	// spec.insecure isn't in the typed HelmRepository, but it's kept for unstructured reads
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(sourcev1beta2.GroupVersion.WithKind(sourcev1beta2.HelmRepositoryKind))
+	if err := c.Get(ctx, key, u); err != nil {
+		t.Fatal(err)
+	}
+	insecure, _, _ := unstructured.NestedBool(u.Object, "spec", "insecure")
+	assert.Equal(t, insecure, true)
+
+	// A patch through the typed object leaves it alone, a merge patch can change it
+	repo := &sourcev1beta2.HelmRepository{}
+	if err := c.Get(ctx, key, repo); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Patch(ctx, repo, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"suspend":true}}`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, u); err != nil {
+		t.Fatal(err)
+	}
+	insecure, _, _ = unstructured.NestedBool(u.Object, "spec", "insecure")
+	assert.Equal(t, insecure, true)
+
+	if err := c.Patch(ctx, u, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"insecure":null}}`))); err != nil {
+		t.Fatal(err)
+	}
+	_, found, _ := unstructured.NestedBool(u.Object, "spec", "insecure")
+	assert.Equal(t, found, false)
+	suspended, _, _ := unstructured.NestedBool(u.Object, "spec", "suspend")
+	assert.Equal(t, suspended, true)
+
+	// Any field can be selected on
+	list := &sourcev1beta2.HelmRepositoryList{}
+	if err := c.List(ctx, list, client.MatchingFields{"spec.type": "oci"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(list.Items), 1)
+	assert.Equal(t, list.Items[0].Name, "charts")
+}
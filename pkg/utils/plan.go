@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	apiv1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PlanStep is a single mutation recorded by the PlanClient
+type PlanStep struct {
+	Action    string
+	Kind      string
+	Namespace string
+	Name      string
+	Detail    string
+	Err       error
+}
+
+// PlanClient wraps a client.Client, records every mutation as a step in a migration plan, and
+// sends it to the API server as a server-side dry-run so nothing is changed. Reads go straight
+// to the wrapped client. Failed steps are recorded instead of returned, so the whole plan is
+// built even if an early step would be rejected.
+type PlanClient struct {
+	client.Client
+	Steps []PlanStep
+}
+
+// NewPlanClient returns a PlanClient wrapping c
+func NewPlanClient(c client.Client) *PlanClient {
+	return &PlanClient{Client: c}
+}
+
+// record adds a step to the plan
+func (p *PlanClient) record(action string, obj client.Object, detail string, err error) {
+	p.Steps = append(p.Steps, PlanStep{
+		Action:    action,
+		Kind:      kindOf(p.Client, obj),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Detail:    detail,
+		Err:       err,
+	})
+}
+
+// Create records the creation of obj and runs it as a dry-run
+func (p *PlanClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := p.Client.Create(ctx, obj, append(opts, client.DryRunAll)...)
+	p.record("CREATE", obj, "", err)
+	return nil
+}
+
+// Delete records the deletion of obj and runs it as a dry-run
+func (p *PlanClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := p.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...)
+	p.record("DELETE", obj, "", err)
+	return nil
+}
+
+// Update records the update of obj and runs it as a dry-run
+func (p *PlanClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := p.Client.Update(ctx, obj, append(opts, client.DryRunAll)...)
+	p.record("UPDATE", obj, "", err)
+	return nil
+}
+
+// Patch records the patch of obj and runs it as a dry-run
+func (p *PlanClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	// Get the patch before sending it, the dry-run fills obj in with the result
+	data, _ := patch.Data(obj)
+	detail := string(data)
+	if _, ok := obj.(*apiv1.Secret); ok {
+		// Don't print credentials
+		detail = "(Secret data not shown)"
+	}
+	err := p.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...)
+	p.record("PATCH", obj, detail, err)
+	return nil
+}
+
+// DeleteAllOf records the deletion of all objects like obj and runs it as a dry-run
+func (p *PlanClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	err := p.Client.DeleteAllOf(ctx, obj, append(opts, client.DryRunAll)...)
+	p.record("DELETE ALL", obj, "", err)
+	return nil
+}
+
+// Print writes the plan in the order the steps would run. It returns an error if any step was
+// rejected by the dry-run.
+func (p *PlanClient) Print(w io.Writer) error {
+	fmt.Fprintln(w, "Migration plan (dry-run, nothing has been changed):")
+	if len(p.Steps) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+
+	failed := 0
+	for i, s := range p.Steps {
+		name := s.Name
+		if s.Namespace != "" {
+			name = s.Namespace + "/" + s.Name
+		}
+
+		line := fmt.Sprintf("%3d. %-10s %s %s", i+1, s.Action, s.Kind, name)
+		if s.Detail != "" {
+			line += " " + s.Detail
+		}
+		fmt.Fprintln(w, line)
+
+		// Show why the API server would reject the step
+		if s.Err != nil {
+			failed++
+			fmt.Fprintf(w, "     FAILED: %s\n", s.Err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d steps in the migration plan would fail", failed, len(p.Steps))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"github.com/akuity/mta/pkg/argo"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AddPlugin configures a config management plugin and adds its sidecar to the repo server, unless
+// it's already there. Rollback takes the sidecar out again. If there's no repo server, like in an
+// offline dry-run, the user is told how to add the sidecar.
+func (t *MigrationTransaction) AddPlugin(p argo.ConfigManagementPlugin) error {
+	cm, err := argo.GenPluginConfigMap(p)
+	if err != nil {
+		return err
+	}
+	if err := t.MergeConfigMap(cm.Namespace, cm.Name, cm.Data, ReplaceValue); err != nil {
+		return err
+	}
+
+	// Get the repo server
+	repoServer := &appsv1.Deployment{}
+	err = t.client.Get(t.ctx, types.NamespacedName{Namespace: p.Namespace, Name: argo.RepoServerDeployment}, repoServer)
+	if apierrors.IsNotFound(err) {
+		return WarnPlugin(p)
+	}
+	if err != nil {
+		return err
+	}
+	if argo.HasSidecar(repoServer, p) {
+		return nil
+	}
+
+	patch, undo, err := argo.GenRepoServerPatch(p)
+	if err != nil {
+		return err
+	}
+	return t.Patch("patch", repoServer, client.RawPatch(types.StrategicMergePatchType, patch), client.RawPatch(types.StrategicMergePatchType, undo))
+}
+
+// WarnPlugin tells the user how to add the sidecar of a config management plugin to the repo
+// server by hand. Printing the Deployment to be applied would replace it.
+func WarnPlugin(p argo.ConfigManagementPlugin) error {
+	patch, _, err := argo.GenRepoServerPatch(p)
+	if err != nil {
+		return err
+	}
+	log.Warn("The Applications need the \"" + p.Name + "\" plugin. Add it to the repo server with:\nkubectl patch deployment " + argo.RepoServerDeployment + " -n " + p.Namespace + " --type strategic --patch '" + string(patch) + "'")
+
+	// If we're here, it should have gone okay...
+	return nil
+}
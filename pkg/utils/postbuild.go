@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akuity/mta/pkg/argo"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// EnvsubstPluginName is the config management plugin that does Flux's postBuild substitution
+	EnvsubstPluginName = "flux-envsubst"
+	// DefaultPluginImage has the kubectl and flux CLIs the envsubst plugin runs
+	DefaultPluginImage = "ghcr.io/fluxcd/flux-cli:v2.1.2"
+)
+
+// renderScript starts the scripts of the plugins. Argo CD syncs whatever they print, with pruning,
+// so any error has to stop them before they print anything, and what they build is kept in a
+// directory of its own until it's checked.
+const renderScript = `set -e
+dir=$(mktemp -d)
+trap 'rm -rf "$dir"' EXIT
+`
+
+// buildScript builds the directory like Flux does, into $dir/build.yaml, and fails if nothing
+// was built. Flux generates a kustomization.yaml for directories without one, every manifest in
+// them is used then.
+const buildScript = `if [ -f kustomization.yaml ] || [ -f kustomization.yml ] || [ -f Kustomization ]; then
+  kubectl kustomize --load-restrictor LoadRestrictionsNone . > "$dir/build.yaml"
+else
+  find . -type f \( -name '*.yaml' -o -name '*.yml' \) > "$dir/manifests"
+  sort -o "$dir/manifests" "$dir/manifests"
+  while read -r f; do echo '---'; cat "$f"; done < "$dir/manifests" > "$dir/build.yaml"
+fi
+if ! grep -q '[^[:space:]-]' "$dir/build.yaml"; then
+  echo "Building $PWD gave no manifests" >&2
+  exit 1
+fi
+`
+
+// substituteScript substitutes the variables in each object that was built, except the ones with
+// Flux's kustomize.toolkit.fluxcd.io/substitute: disabled. flux envsubst only gets the variables
+// of the Application, without the ARGOCD_ENV_ prefix Argo CD gives them, not the environment of
+// the sidecar.
+const substituteScript = `flux=$(command -v flux)
+set --
+for v in $(env | sed -n 's/^ARGOCD_ENV_\([A-Za-z_][A-Za-z0-9_]*\)=.*/\1/p'); do set -- "$@" "$v=$(printenv "ARGOCD_ENV_$v")"; done
+awk -v dir="$dir" '/^---/ { close(f); n++; next } { f = sprintf("%s/object-%06d.yaml", dir, n); print > f }' "$dir/build.yaml"
+for f in "$dir"/object-*.yaml; do
+  echo '---'
+  if grep -q -E '^ +kustomize\.toolkit\.fluxcd\.io/substitute: *["'"'"']?disabled' "$f"; then
+    cat "$f"
+  else
+    env -i "$@" "$flux" envsubst < "$f"
+  fi
+done > "$dir/substituted"
+cat "$dir/substituted"
+`
+
+// envsubstScript builds the directory and substitutes the variables
+const envsubstScript = renderScript + buildScript + substituteScript
+
+// NewEnvsubstPlugin returns the config management plugin that renders Kustomizations with
+// postBuild substitutions, running in image
+func NewEnvsubstPlugin(ans string, image string) argo.ConfigManagementPlugin {
+	if image == "" {
+		image = DefaultPluginImage
+	}
+	return argo.ConfigManagementPlugin{
+		Name:      EnvsubstPluginName,
+		Namespace: ans,
+		Image:     image,
+		Generate:  envsubstScript,
+	}
+}
+
+// PostBuildVariables returns the variables a Kustomization substitutes after it's built, merged
+// like Flux does: substituteFrom in order, then substitute on top. It returns nil if there's no
+// postBuild. Variables from Secrets would end up in plain text in the Application, so those
+// Kustomizations can't be migrated.
+func PostBuildVariables(c client.Client, ctx context.Context, k *kustomizev1.Kustomization) (map[string]string, error) {
+	if k.Spec.PostBuild == nil || (len(k.Spec.PostBuild.Substitute) == 0 && len(k.Spec.PostBuild.SubstituteFrom) == 0) {
+		return nil, nil
+	}
+
+	vars := map[string]string{}
+	for _, ref := range k.Spec.PostBuild.SubstituteFrom {
+		switch ref.Kind {
+		case "ConfigMap":
+			cm := &apiv1.ConfigMap{}
+			err := c.Get(ctx, types.NamespacedName{Namespace: k.Namespace, Name: ref.Name}, cm)
+			if apierrors.IsNotFound(err) && ref.Optional {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("getting the postBuild variables of Kustomization %s/%s from ConfigMap %s: %w", k.Namespace, k.Name, ref.Name, err)
+			}
+			for n, v := range cm.Data {
+				vars[n] = v
+			}
+		case "Secret":
+			return nil, fmt.Errorf("Kustomization %s/%s substitutes variables from Secret %s/%s. Argo CD passes variables to plugins in the Application, in plain text, so it can't be migrated safely. Move the variables that aren't secret to a ConfigMap, and the secret ones to the manifests encrypted, before migrating", k.Namespace, k.Name, k.Namespace, ref.Name)
+		default:
+			return nil, fmt.Errorf("Kustomization %s/%s substitutes variables from an unsupported kind %q", k.Namespace, k.Name, ref.Kind)
+		}
+	}
+
+	// substitute always wins
+	for n, v := range k.Spec.PostBuild.Substitute {
+		vars[n] = v
+	}
+
+	// If we're here, it should have gone okay...
+	return vars, nil
+}
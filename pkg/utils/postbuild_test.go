@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestPostBuildVariables(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetNamespace("flux-system")
+	cm.SetName("cluster-vars")
+	unstructured.SetNestedField(cm.Object, map[string]interface{}{"cluster_name": "staging", "region": "eu-west-1"}, "data")
+	c := NewOfflineClient(scheme, cm)
+
+	tests := []struct {
+		name         string
+		postBuild    *kustomizev1.PostBuild
+		expectedVars map[string]string
+		expectedErr  bool
+	}{
+		{
+			name: "when there is no postBuild",
+		},
+		{
+			name: "when variables come from a ConfigMap and substitute",
+			postBuild: &kustomizev1.PostBuild{
+				Substitute: map[string]string{"cluster_name": "production"},
+				SubstituteFrom: []kustomizev1.SubstituteReference{
+					{Kind: "ConfigMap", Name: "cluster-vars"},
+					{Kind: "ConfigMap", Name: "missing", Optional: true},
+				},
+			},
+			expectedVars: map[string]string{"cluster_name": "production", "region": "eu-west-1"},
+		},
+		{
+			name: "when a required ConfigMap is missing",
+			postBuild: &kustomizev1.PostBuild{
+				SubstituteFrom: []kustomizev1.SubstituteReference{{Kind: "ConfigMap", Name: "missing"}},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "when variables come from a Secret",
+			postBuild: &kustomizev1.PostBuild{
+				SubstituteFrom: []kustomizev1.SubstituteReference{{Kind: "Secret", Name: "cluster-secrets"}},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &kustomizev1.Kustomization{}
+			k.SetNamespace("flux-system")
+			k.SetName("apps")
+			k.Spec.PostBuild = tt.postBuild
+
+			vars, err := PostBuildVariables(c, context.TODO(), k)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			assert.Equal(t, vars, tt.expectedVars)
+		})
+	}
+}
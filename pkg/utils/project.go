@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/akuity/mta/pkg/argo"
+	v1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TenantProjectName returns the name of the AppProject of the tenant that impersonates
+// serviceAccount in namespace: the namespace, or the namespace and the ServiceAccount if they're
+// named differently
+func TenantProjectName(namespace string, serviceAccount string) string {
+	if namespace == serviceAccount {
+		return SanitizeName(namespace)
+	}
+	return SanitizeName(namespace + "-" + serviceAccount)
+}
+
+// AssignTenantProject assigns what a Kustomization or HelmRelease is migrated to, app, to the
+// AppProject of its tenant and returns the AppProject. Flux objects that don't impersonate a
+// ServiceAccount aren't in a tenant, they stay in the default project and nil is returned.
+// clusterNames map kubeconfig Secrets to registered Argo CD clusters, like for ResolveDestination.
+func AssignTenantProject(c client.Client, ctx context.Context, ans string, obj client.Object, app client.Object, clusterNames map[string]string) (*v1alpha1.AppProject, error) {
+	serviceAccount := serviceAccountOf(obj)
+	if serviceAccount == "" {
+		return nil, nil
+	}
+
+	project, err := NewTenantProject(c, ctx, ans, obj.GetNamespace(), serviceAccount, clusterNames)
+	if err != nil {
+		return nil, err
+	}
+	argo.SetProject(app, project.Name)
+
+	// If we're here, it should have gone okay...
+	return project, nil
+}
+
+// NewTenantProject generates the AppProject of the tenant that impersonates serviceAccount in
+// namespace. It allows the repositories of the tenant's Kustomizations and HelmReleases, the
+// clusters and namespaces they deploy to, and the cluster-scoped kinds the ServiceAccount can
+// change.
+func NewTenantProject(c client.Client, ctx context.Context, ans string, namespace string, serviceAccount string, clusterNames map[string]string) (*v1alpha1.AppProject, error) {
+	repos := map[string]bool{}
+	destinations := map[v1alpha1.ApplicationDestination]bool{}
+	addDestination := func(obj client.Object, targetNamespace string) {
+		dest, err := ResolveDestination(c, ctx, ans, obj, clusterNames)
+		if err != nil {
+			LoggerFrom(ctx).Warn("The cluster of " + kindOf(c, obj) + " \"" + obj.GetName() + "\" can't be resolved, it's not in the destinations of project \"" + TenantProjectName(namespace, serviceAccount) + "\": " + err.Error())
+			return
+		}
+		destinations[v1alpha1.ApplicationDestination{Server: dest.Server, Name: dest.Name, Namespace: getNamespace(targetNamespace, namespace)}] = true
+	}
+
+	// Everything the tenant deploys with its Kustomizations
+	kl := &kustomizev1.KustomizationList{}
+	if err := c.List(ctx, kl, client.InNamespace(namespace)); err != nil && !isMissingKind(err) {
+		return nil, err
+	}
+	for i := range kl.Items {
+		k := &kl.Items[i]
+		if k.Spec.ServiceAccountName != serviceAccount {
+			continue
+		}
+		addDestination(k, k.Spec.TargetNamespace)
+
+		gitSource := &sourcev1.GitRepository{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: getNamespace(k.Spec.SourceRef.Namespace, k.Namespace), Name: k.Spec.SourceRef.Name}, gitSource)
+		if apierrors.IsNotFound(err) {
+			LoggerFrom(ctx).Warn("The GitRepository of Kustomization \"" + k.Name + "\" was not found, it's not in the sourceRepos of project \"" + TenantProjectName(namespace, serviceAccount) + "\"")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		repos[gitSource.Spec.URL] = true
+	}
+
+	// And with its HelmReleases
+	hl := &helmv2.HelmReleaseList{}
+	if err := c.List(ctx, hl, client.InNamespace(namespace)); err != nil && !isMissingKind(err) {
+		return nil, err
+	}
+	for i := range hl.Items {
+		h := &hl.Items[i]
+		if h.Spec.ServiceAccountName != serviceAccount {
+			continue
+		}
+		addDestination(h, h.Spec.TargetNamespace)
+
+		source, err := GetHelmChartSource(c, ctx, h)
+		if err != nil {
+			LoggerFrom(ctx).Warn("The chart source of HelmRelease \"" + h.Name + "\" can't be used, it's not in the sourceRepos of project \"" + TenantProjectName(namespace, serviceAccount) + "\": " + err.Error())
+			continue
+		}
+		switch s := source.(type) {
+		case *sourcev1.GitRepository:
+			repos[s.Spec.URL] = true
+		case *sourcev1beta2.HelmRepository:
+			repos[argo.TrimOCIScheme(s.Spec.URL)] = true
+		}
+	}
+
+	clusterResources, err := ClusterResources(c, ctx, namespace, serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	return argo.GenAppProject(argo.AppProject{
+		Name:             TenantProjectName(namespace, serviceAccount),
+		Namespace:        ans,
+		Description:      "Migrated from the Flux tenant " + namespace + "/" + serviceAccount,
+		SourceRepos:      sortedKeys(repos),
+		Destinations:     sortedDestinations(destinations),
+		ClusterResources: clusterResources,
+	}), nil
+}
+
+// ClusterResources returns the cluster-scoped kinds a ServiceAccount can create or change, from the
+// ClusterRoles bound to it with ClusterRoleBindings. RoleBindings only grant namespaced access.
+func ClusterResources(c client.Client, ctx context.Context, namespace string, serviceAccount string) ([]metav1.GroupKind, error) {
+	crbs := &rbacv1.ClusterRoleBindingList{}
+	err := c.List(ctx, crbs)
+	if isMissingKind(err) {
+		return nil, nil
+	}
+	if apierrors.IsForbidden(err) {
+		LoggerFrom(ctx).Warn("The ClusterRoleBindings can't be read, project of ServiceAccount " + namespace + "/" + serviceAccount + " won't allow any cluster-scoped resources: " + err.Error())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := map[metav1.GroupKind]bool{}
+	for _, crb := range crbs.Items {
+		if crb.RoleRef.Kind != "ClusterRole" || !boundTo(crb.Subjects, namespace, serviceAccount) {
+			continue
+		}
+
+		cr := &rbacv1.ClusterRole{}
+		err := c.Get(ctx, types.NamespacedName{Name: crb.RoleRef.Name}, cr)
+		if apierrors.IsNotFound(err) {
+			LoggerFrom(ctx).Warn("ClusterRole \"" + crb.RoleRef.Name + "\" of ClusterRoleBinding \"" + crb.Name + "\" was not found")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range cr.Rules {
+			if !changes(rule.Verbs) {
+				continue
+			}
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					for _, gk := range ruleClusterKinds(c.RESTMapper(), group, resource) {
+						kinds[gk] = true
+					}
+				}
+			}
+		}
+	}
+
+	// Keep the order stable
+	gks := make([]metav1.GroupKind, 0, len(kinds))
+	for gk := range kinds {
+		gks = append(gks, gk)
+	}
+	sort.Slice(gks, func(i, j int) bool {
+		if gks[i].Group != gks[j].Group {
+			return gks[i].Group < gks[j].Group
+		}
+		return gks[i].Kind < gks[j].Kind
+	})
+	return gks, nil
+}
+
+// ruleClusterKinds returns the cluster-scoped kinds of the resources an RBAC rule names. All
+// resources of a group are allowed with the "*" kind, Argo CD matches kinds like globs.
+func ruleClusterKinds(mapper meta.RESTMapper, group string, resource string) []metav1.GroupKind {
+	// Subresources don't change what can be deployed
+	if strings.Contains(resource, "/") {
+		return nil
+	}
+	if resource == rbacv1.ResourceAll {
+		return []metav1.GroupKind{{Group: group, Kind: "*"}}
+	}
+
+	// The mapper takes an empty group as any group
+	gvr := schema.GroupVersionResource{Group: group, Resource: resource}
+	if group == rbacv1.APIGroupAll {
+		gvr.Group = ""
+	}
+	gvks, err := mapper.KindsFor(gvr)
+	if err != nil {
+		return nil
+	}
+
+	kinds := []metav1.GroupKind{}
+	for _, gvk := range gvks {
+		if group != rbacv1.APIGroupAll && gvk.Group != group {
+			continue
+		}
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil || mapping.Scope.Name() != meta.RESTScopeNameRoot {
+			continue
+		}
+		kinds = append(kinds, metav1.GroupKind{Group: gvk.Group, Kind: gvk.Kind})
+	}
+	return kinds
+}
+
+// boundTo tells if the subjects of a binding include a ServiceAccount
+func boundTo(subjects []rbacv1.Subject, namespace string, serviceAccount string) bool {
+	for _, s := range subjects {
+		if s.Kind == rbacv1.ServiceAccountKind && s.Namespace == namespace && s.Name == serviceAccount {
+			return true
+		}
+	}
+	return false
+}
+
+// changes tells if the verbs of an RBAC rule can create or change objects
+func changes(verbs []string) bool {
+	for _, v := range verbs {
+		switch v {
+		case rbacv1.VerbAll, "create", "update", "patch":
+			return true
+		}
+	}
+	return false
+}
+
+// serviceAccountOf returns the ServiceAccount a Kustomization or HelmRelease impersonates, if any
+func serviceAccountOf(obj client.Object) string {
+	switch o := obj.(type) {
+	case *kustomizev1.Kustomization:
+		return o.Spec.ServiceAccountName
+	case *helmv2.HelmRelease:
+		return o.Spec.ServiceAccountName
+	}
+	return ""
+}
+
+// sortedDestinations returns the destinations of a set in order
+func sortedDestinations(set map[v1alpha1.ApplicationDestination]bool) []v1alpha1.ApplicationDestination {
+	ds := make([]v1alpha1.ApplicationDestination, 0, len(set))
+	for d := range set {
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		if ds[i].Server+ds[i].Name != ds[j].Server+ds[j].Name {
+			return ds[i].Server+ds[i].Name < ds[j].Server+ds[j].Name
+		}
+		return ds[i].Namespace < ds[j].Namespace
+	})
+	return ds
+}
+
+// getNamespace returns ns, or the default namespace if it's empty
+func getNamespace(ns string, defaultNamespace string) string {
+	if ns == "" {
+		return defaultNamespace
+	}
+	return ns
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/magiconair/properties/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// tenantScheme knows about the Flux objects, RBAC and the cluster-scoped kinds a tenant may deploy
+func tenantScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	kustomizev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+	sourcev1beta2.AddToScheme(scheme)
+	corev1.AddToScheme(scheme)
+	rbacv1.AddToScheme(scheme)
+	apiextensionsv1.AddToScheme(scheme)
+	return scheme
+}
+
+// clusterRole returns a ClusterRole and a ClusterRoleBinding that binds it to the ServiceAccount
+// team-a/team-a
+func clusterRole(name string, rules ...map[string]interface{}) []*unstructured.Unstructured {
+	cr := &unstructured.Unstructured{}
+	cr.SetGroupVersionKind(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"))
+	cr.SetName(name)
+	ruleList := []interface{}{}
+	for _, r := range rules {
+		ruleList = append(ruleList, r)
+	}
+	unstructured.SetNestedSlice(cr.Object, ruleList, "rules")
+
+	crb := &unstructured.Unstructured{}
+	crb.SetGroupVersionKind(rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding"))
+	crb.SetName(name)
+	unstructured.SetNestedMap(crb.Object, map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": name}, "roleRef")
+	unstructured.SetNestedSlice(crb.Object, []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "team-a", "namespace": "team-a"}}, "subjects")
+
+	return []*unstructured.Unstructured{cr, crb}
+}
+
+// rule returns an RBAC rule
+func rule(groups []interface{}, resources []interface{}, verbs ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"apiGroups": groups, "resources": resources, "verbs": verbs}
+}
+
+func TestClusterResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		objs          []*unstructured.Unstructured
+		expectedKinds []metav1.GroupKind
+	}{
+		{
+			name:          "when the ServiceAccount has no ClusterRoleBinding",
+			expectedKinds: []metav1.GroupKind{},
+		},
+		{
+			name: "when the ClusterRole names resources",
+			objs: clusterRole("tenant",
+				rule([]interface{}{""}, []interface{}{"namespaces", "pods", "namespaces/status"}, "create", "get"),
+				rule([]interface{}{"apiextensions.k8s.io"}, []interface{}{"customresourcedefinitions"}, "*"),
+				rule([]interface{}{"rbac.authorization.k8s.io"}, []interface{}{"clusterroles"}, "get", "list"),
+			),
+			expectedKinds: []metav1.GroupKind{
+				{Group: "", Kind: "Namespace"},
+				{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"},
+			},
+		},
+		{
+			name:          "when the ClusterRole has every resource of a group",
+			objs:          clusterRole("tenant", rule([]interface{}{"rbac.authorization.k8s.io"}, []interface{}{"*"}, "patch")),
+			expectedKinds: []metav1.GroupKind{{Group: "rbac.authorization.k8s.io", Kind: "*"}},
+		},
+		{
+			name:          "when the ClusterRole is cluster-admin",
+			objs:          clusterRole("cluster-admin", rule([]interface{}{"*"}, []interface{}{"*"}, "*")),
+			expectedKinds: []metav1.GroupKind{{Group: "*", Kind: "*"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewOfflineClient(tenantScheme(), tt.objs...)
+			kinds, err := ClusterResources(c, context.TODO(), "team-a", "team-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, kinds, tt.expectedKinds)
+		})
+	}
+}
+
+func TestNewTenantProject(t *testing.T) {
+	objs, err := DecodeManifests(strings.NewReader(`
+apiVersion: source.toolkit.fluxcd.io/v1
+kind: GitRepository
+metadata: {name: team-a, namespace: team-a}
+spec: {url: https://github.com/example/team-a}
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata: {name: apps, namespace: team-a}
+spec: {serviceAccountName: team-a, targetNamespace: team-a-apps, sourceRef: {kind: GitRepository, name: team-a}}
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata: {name: other, namespace: team-a}
+spec: {serviceAccountName: other, targetNamespace: other, sourceRef: {kind: GitRepository, name: team-a}}
+---
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata: {name: charts, namespace: team-a}
+spec: {type: oci, url: oci://ghcr.io/example/charts}
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata: {name: podinfo, namespace: team-a}
+spec: {serviceAccountName: team-a, chart: {spec: {chart: podinfo, sourceRef: {kind: HelmRepository, name: charts}}}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs = append(objs, clusterRole("tenant", rule([]interface{}{""}, []interface{}{"namespaces"}, "create"))...)
+	c := NewOfflineClient(tenantScheme(), objs...)
+
+	p, err := NewTenantProject(c, context.TODO(), "argocd", "team-a", "team-a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, p.Name, "team-a")
+	assert.Equal(t, p.Namespace, "argocd")
+	assert.Equal(t, p.Spec.SourceRepos, []string{"ghcr.io/example/charts", "https://github.com/example/team-a"})
+	destinations := []string{}
+	for _, d := range p.Spec.Destinations {
+		destinations = append(destinations, d.Namespace)
+	}
+	assert.Equal(t, destinations, []string{"team-a", "team-a-apps"})
+	assert.Equal(t, p.Spec.ClusterResourceWhitelist, []metav1.GroupKind{{Group: "", Kind: "Namespace"}})
+
+	assert.Equal(t, TenantProjectName("team-a", "flux"), "team-a-flux")
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// How ready an object is to be migrated, from its blockers
+const (
+	// ReadinessSafe is for objects auto-migrate migrates as they are
+	ReadinessSafe = "safe"
+	// ReadinessManual is for objects that migrate, but need something done by hand before or after
+	ReadinessManual = "manual"
+	// ReadinessUnsupported is for objects Argo CD can't take over
+	ReadinessUnsupported = "unsupported"
+)
+
+// ReadinessGroups are the readiness groups in the order they're shown, with their titles
+var ReadinessGroups = []struct{ Readiness, Title string }{
+	{ReadinessSafe, "Safe to auto-migrate"},
+	{ReadinessManual, "Needs manual work"},
+	{ReadinessUnsupported, "Unsupported"},
+}
+
+// How much each blocker takes off the readiness score of 100
+const (
+	penaltyUnsupported  = 100
+	penaltyNotGenerated = 40
+	penaltyNotReady     = 30
+	penaltyPostRenderer = 30
+	penaltySOPS         = 25
+	penaltyValuesSecret = 25
+	penaltyRemote       = 20
+	penaltyImpersonate  = 20
+	penaltyUnmapped     = 20
+	penaltySuspended    = 20
+	penaltyOCIProvider  = 20
+	penaltyPostBuild    = 15
+	penaltyValuesFrom   = 10
+)
+
+// block adds a blocker to an item, taking penalty off its readiness score. Any blocker makes it
+// need manual work, and one with penaltyUnsupported makes it unsupported.
+func (i *ScanItem) block(message string, penalty int) {
+	i.Blockers = append(i.Blockers, message)
+	i.Score -= penalty
+	if i.Score < 0 {
+		i.Score = 0
+	}
+	switch {
+	case penalty >= penaltyUnsupported:
+		i.Readiness = ReadinessUnsupported
+	case i.Readiness != ReadinessUnsupported:
+		i.Readiness = ReadinessManual
+	}
+}
+
+// blockStatus adds the blockers of the state from FluxStatus and of suspension, which
+// Kustomizations and HelmReleases share. Only Ready is safe: an object Flux hasn't reconciled is
+// Unknown and could be in any state once it is. Objects read from manifests are always Unknown, so
+// it's left to the migration against the cluster when working offline or planning a dry-run.
+func (i *ScanItem) blockStatus(opts MigrationOptions) {
+	if i.Suspended {
+		// It's Suspended whatever its state, the Ready condition says how Flux left it
+		if i.Ready != "" && i.Ready != string(metav1.ConditionTrue) {
+			i.block(fmt.Sprintf("isn't Ready (%s), migrate it once Flux reconciles it", firstNonEmpty(i.ReadyReason, i.Ready)), penaltyNotReady)
+		}
+		i.block("is suspended, Argo CD would sync what Flux is holding back", penaltySuspended)
+		return
+	}
+	if i.Status == StatusUnknown && (opts.Offline || opts.DryRun) {
+		return
+	}
+	if i.Status != StatusReady {
+		i.block(fmt.Sprintf("isn't Ready (%s), migrate it once Flux reconciles it", firstNonEmpty(i.ReadyReason, i.Status)), penaltyNotReady)
+	}
+}
+
+// blockKustomization adds the blockers of the parts of a Kustomization that are migrated, but not
+// as they are
+func (i *ScanItem) blockKustomization(k *kustomizev1.Kustomization) {
+	// The SOPS plugin substitutes the variables too
+	if k.Spec.PostBuild != nil && k.Spec.Decryption == nil {
+		i.block("has postBuild substitutions, the repo server needs the "+EnvsubstPluginName+" plugin sidecar", penaltyPostBuild)
+	}
+	if k.Spec.Decryption != nil {
+		i.block("decrypts with SOPS, the repo server needs the "+SOPSPluginName+" plugin sidecar with the keys", penaltySOPS)
+	}
+	if k.Spec.KubeConfig != nil {
+		i.block("deploys to a remote cluster with a kubeConfig, check the cluster Argo CD gets", penaltyRemote)
+	}
+	if k.Spec.ServiceAccountName != "" {
+		i.block("impersonates ServiceAccount "+k.Spec.ServiceAccountName+", check what its AppProject allows", penaltyImpersonate)
+	}
+}
+
+// blockHelmRelease adds the blockers of the parts of a HelmRelease that are migrated, but not as
+// they are, or not at all. Values from Secrets aren't one when opts drops them.
+func (i *ScanItem) blockHelmRelease(h *helmv2.HelmRelease, opts MigrationOptions) {
+	for _, ref := range h.Spec.ValuesFrom {
+		if ref.Kind == "Secret" {
+			if opts.DropSecretValues {
+				continue
+			}
+			i.block("gets values from Secret "+ref.Name+", they can only be left out of the Application, with --drop-secret-values", penaltyValuesSecret)
+			continue
+		}
+		i.block("gets values from "+ref.Kind+" "+ref.Name+", they're copied into the Application and don't follow its changes", penaltyValuesFrom)
+	}
+	if len(h.Spec.PostRenderers) > 0 {
+		i.block(fmt.Sprintf("has %d postRenderers, Argo CD doesn't run them", len(h.Spec.PostRenderers)), penaltyPostRenderer)
+	}
+	if h.Spec.KubeConfig != nil {
+		i.block("deploys to a remote cluster with a kubeConfig, check the cluster Argo CD gets", penaltyRemote)
+	}
+	if h.Spec.ServiceAccountName != "" {
+		i.block("impersonates ServiceAccount "+h.Spec.ServiceAccountName+", check what its AppProject allows", penaltyImpersonate)
+	}
+}
+
+// SortScanItems orders the items by their readiness group, keeping the order within each group
+func SortScanItems(items []ScanItem) {
+	rank := map[string]int{}
+	for n, g := range ReadinessGroups {
+		rank[g.Readiness] = n
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		return rank[items[a].Readiness] < rank[items[b].Readiness]
+	})
+}
+
+// ReadinessTitle returns the title of a readiness group
+func ReadinessTitle(readiness string) string {
+	for _, g := range ReadinessGroups {
+		if g.Readiness == readiness {
+			return g.Title
+		}
+	}
+	return readiness
+}
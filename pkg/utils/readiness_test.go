@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBlockHelmRelease(t *testing.T) {
+	tests := []struct {
+		name              string
+		spec              helmv2.HelmReleaseSpec
+		opts              MigrationOptions
+		expectedReadiness string
+		expectedScore     int
+	}{
+		{
+			name:              "when it migrates as it is",
+			expectedReadiness: ReadinessSafe,
+			expectedScore:     100,
+		},
+		{
+			name:              "when it gets values from a ConfigMap and a Secret",
+			spec:              helmv2.HelmReleaseSpec{ValuesFrom: []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "values"}, {Kind: "Secret", Name: "credentials"}}},
+			expectedReadiness: ReadinessManual,
+			expectedScore:     100 - penaltyValuesFrom - penaltyValuesSecret,
+		},
+		{
+			name:              "when the values from Secrets are dropped",
+			spec:              helmv2.HelmReleaseSpec{ValuesFrom: []helmv2.ValuesReference{{Kind: "Secret", Name: "credentials"}}},
+			opts:              MigrationOptions{DropSecretValues: true},
+			expectedReadiness: ReadinessSafe,
+			expectedScore:     100,
+		},
+		{
+			name: "when it has everything",
+			spec: helmv2.HelmReleaseSpec{
+				PostRenderers:      []helmv2.PostRenderer{{}},
+				KubeConfig:         &meta.KubeConfigReference{},
+				ServiceAccountName: "deployer",
+				ValuesFrom:         []helmv2.ValuesReference{{Kind: "Secret", Name: "credentials"}},
+			},
+			expectedReadiness: ReadinessManual,
+			expectedScore:     5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := ScanItem{Readiness: ReadinessSafe, Score: 100}
+			item.blockHelmRelease(&helmv2.HelmRelease{Spec: tt.spec}, tt.opts)
+			assert.Equal(t, item.Readiness, tt.expectedReadiness)
+			assert.Equal(t, item.Score, tt.expectedScore)
+		})
+	}
+}
+
+func TestBlockStatus(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            string
+		opts              MigrationOptions
+		expectedReadiness string
+	}{
+		{
+			name:              "when it's Ready",
+			status:            StatusReady,
+			expectedReadiness: ReadinessSafe,
+		},
+		{
+			name:              "when Flux hasn't reconciled it",
+			status:            StatusUnknown,
+			expectedReadiness: ReadinessManual,
+		},
+		{
+			name:              "when it's read from manifests",
+			status:            StatusUnknown,
+			opts:              MigrationOptions{Offline: true},
+			expectedReadiness: ReadinessSafe,
+		},
+		{
+			name:              "when it's planned in a dry-run",
+			status:            StatusUnknown,
+			opts:              MigrationOptions{DryRun: true},
+			expectedReadiness: ReadinessSafe,
+		},
+		{
+			name:              "when it's Stalled in a dry-run",
+			status:            StatusStalled,
+			opts:              MigrationOptions{DryRun: true},
+			expectedReadiness: ReadinessManual,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := ScanItem{Status: tt.status, Readiness: ReadinessSafe, Score: 100}
+			item.blockStatus(tt.opts)
+			assert.Equal(t, item.Readiness, tt.expectedReadiness)
+		})
+	}
+}
+
+func TestSortScanItems(t *testing.T) {
+	items := []ScanItem{
+		{Name: "oci", Readiness: ReadinessUnsupported},
+		{Name: "vars", Readiness: ReadinessManual},
+		{Name: "apps", Readiness: ReadinessSafe},
+		{Name: "infra", Readiness: ReadinessSafe},
+	}
+	SortScanItems(items)
+
+	names := []string{}
+	for _, i := range items {
+		names = append(names, i.Name)
+	}
+	assert.Equal(t, names, []string{"apps", "infra", "vars", "oci"})
+}
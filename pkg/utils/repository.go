@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"github.com/akuity/mta/pkg/argo"
+	log "github.com/sirupsen/logrus"
+)
+
+// repositoryConfigMapMerge is how what a repository needs is added to each of Argo CD's ConfigMaps
+var repositoryConfigMapMerge = map[string]ConfigMapMergeFunc{
+	argo.TLSCertsConfigMap:      AppendIfMissing,
+	argo.SSHKnownHostsConfigMap: AppendMissingLines,
+}
+
+// AddRepositoryConfig adds the CA certificate and SSH known hosts of a repository to Argo CD's
+// ConfigMaps in the ans namespace, keeping what's already there
+func (t *MigrationTransaction) AddRepositoryConfig(ans string, repoURL string, creds argo.RepositoryCredentials) error {
+	for name, data := range creds.ConfigMapData(repoURL) {
+		if err := t.MergeConfigMap(ans, name, data, repositoryConfigMapMerge[name]); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// WarnRepositoryConfig tells the user what to add to Argo CD's ConfigMaps for a repository by
+// hand. Printing the ConfigMaps to be applied would replace what's in them.
+func WarnRepositoryConfig(repoURL string, creds argo.RepositoryCredentials) {
+	for name, data := range creds.ConfigMapData(repoURL) {
+		for k, v := range data {
+			log.Warn("Add this to the \"", k, "\" key of the ", name, " ConfigMap for ", repoURL, ":\n", v)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akuity/mta/pkg/argo"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SOPSPluginName is the config management plugin that decrypts SOPS encrypted manifests like
+	// Flux's decryption does
+	SOPSPluginName = "flux-sops"
+	// SOPSKeysSecret has the keys of every migrated Kustomization that decrypts, it's mounted in
+	// the plugin's sidecar
+	SOPSKeysSecret = "mta-sops-keys"
+	// sopsKeysPath is where the keys are mounted in the sidecar
+	sopsKeysPath = "/home/argocd/sops"
+	// sopsProvider is the only decryption provider Flux has
+	sopsProvider = "sops"
+)
+
+// sopsInitScript puts the age keys in the one file sops reads them from, and imports the PGP keys
+const sopsInitScript = `set -e
+mkdir -p "$GNUPGHOME" && chmod 700 "$GNUPGHOME"
+cat /dev/null ` + sopsKeysPath + `/*.agekey > "$SOPS_AGE_KEY_FILE" 2>/dev/null || true
+for k in ` + sopsKeysPath + `/*.asc; do [ -f "$k" ] && gpg --batch --quiet --import "$k"; done
+true
+`
+
+// sopsScript decrypts the encrypted manifests and env files in place, in Argo CD's copy of the
+// repo, then builds the directory and substitutes the variables if the Application has any. A file
+// that can't be decrypted fails it, so the ciphertext is never applied.
+const sopsScript = renderScript + `find . -type f \( -name '*.yaml' -o -name '*.yml' -o -name '*.json' -o -name '*.env' \) > "$dir/encrypted"
+while read -r f; do
+  if grep -q -e '^sops:' -e '"sops":' -e '^sops_mac=' "$f"; then
+    sops --decrypt --in-place "$f"
+  fi
+done < "$dir/encrypted"
+` + buildScript + `if ! env | grep -q '^ARGOCD_ENV_'; then
+  cat "$dir/build.yaml"
+  exit 0
+fi
+` + substituteScript
+
+// sopsKeySuffixes are the keys of a decryption Secret that hold keys the plugin can use, age and
+// PGP private keys
+var sopsKeySuffixes = []string{".agekey", ".asc"}
+
+// SOPSDecryption is how a Kustomization's manifests are decrypted in Argo CD
+type SOPSDecryption struct {
+	// Keys are the age and PGP keys of the decryption Secret, for the SOPSKeysSecret. Their names
+	// are prefixed with the Secret's namespace and name.
+	Keys map[string]string
+	// Secret is the decryption Secret the keys come from, empty if there's none
+	Secret string
+}
+
+// NewSOPSPlugin returns the config management plugin that decrypts with sops before building,
+// running in image. The image needs sh, sops, gpg, kubectl and the flux CLI, there's no default
+// one with all of them.
+func NewSOPSPlugin(ans string, image string) argo.ConfigManagementPlugin {
+	optional := true
+	return argo.ConfigManagementPlugin{
+		Name:      SOPSPluginName,
+		Namespace: ans,
+		Image:     image,
+		Init:      sopsInitScript,
+		Generate:  sopsScript,
+		Env: []apiv1.EnvVar{
+			{Name: "SOPS_AGE_KEY_FILE", Value: "/tmp/sops/age-keys.txt"},
+			{Name: "GNUPGHOME", Value: "/tmp/sops/gnupg"},
+		},
+		// The Secret is only there once a Kustomization with keys is migrated
+		Volumes: []apiv1.Volume{{
+			Name:         SOPSKeysSecret,
+			VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: SOPSKeysSecret, Optional: &optional}},
+		}},
+		VolumeMounts: []apiv1.VolumeMount{{Name: SOPSKeysSecret, MountPath: sopsKeysPath, ReadOnly: true}},
+	}
+}
+
+// GetSOPSDecryption returns how a Kustomization's manifests are decrypted, nil if Flux doesn't
+// decrypt them. Keys Flux gets from its own identity, like cloud KMS keys, and the other keys of
+// the decryption Secret aren't carried over, the plugin's sidecar has to be given them.
+func GetSOPSDecryption(c client.Client, ctx context.Context, k *kustomizev1.Kustomization) (*SOPSDecryption, error) {
+	if k.Spec.Decryption == nil {
+		return nil, nil
+	}
+	if k.Spec.Decryption.Provider != sopsProvider {
+		return nil, fmt.Errorf("Kustomization %s/%s decrypts with an unsupported provider %q", k.Namespace, k.Name, k.Spec.Decryption.Provider)
+	}
+
+	d := &SOPSDecryption{Keys: map[string]string{}}
+	if k.Spec.Decryption.SecretRef == nil {
+		LoggerFrom(ctx).Warn("Kustomization " + k.Namespace + "/" + k.Name + " decrypts with the identity of the kustomize-controller, give the repo server's " + SOPSPluginName + " sidecar access to the same KMS keys")
+		return d, nil
+	}
+
+	// Get the Secret the keys are in
+	secret := &apiv1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: k.Namespace, Name: k.Spec.Decryption.SecretRef.Name}, secret); err != nil {
+		return nil, fmt.Errorf("getting the decryption keys of Kustomization %s/%s: %w", k.Namespace, k.Name, err)
+	}
+	d.Secret = secret.Namespace + "/" + secret.Name
+
+	// Keys from different Secrets can have the same name
+	prefix := SanitizeName(secret.Namespace + "-" + secret.Name)
+	for key, v := range secretData(secret) {
+		if !hasSOPSKeySuffix(key) {
+			LoggerFrom(ctx).Warn("Key \"" + key + "\" of decryption Secret " + d.Secret + " isn't carried over, give the repo server's " + SOPSPluginName + " sidecar what it has")
+			continue
+		}
+		d.Keys[prefix+"-"+key] = v
+	}
+
+	// If we're here, it should have gone okay...
+	return d, nil
+}
+
+// GenSOPSKeysSecret generates the SOPSKeysSecret with keys, to be applied next to the ones already
+// in it
+func GenSOPSKeysSecret(ans string, keys map[string]string) *apiv1.Secret {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: SOPSKeysSecret, Namespace: ans},
+		Type:       apiv1.SecretTypeOpaque,
+		StringData: keys,
+	}
+	secret.SetGroupVersionKind(apiv1.SchemeGroupVersion.WithKind("Secret"))
+	return secret
+}
+
+// AddSOPSPlugin adds the keys a migration decrypts with to the SOPSKeysSecret and the plugin that
+// decrypts to the repo server. Rollback takes the keys and the sidecar out again.
+func (t *MigrationTransaction) AddSOPSPlugin(d *SOPSDecryption, p argo.ConfigManagementPlugin) error {
+	if d == nil {
+		return nil
+	}
+	if len(d.Keys) > 0 {
+		if err := t.MergeSecret(p.Namespace, SOPSKeysSecret, d.Keys); err != nil {
+			return err
+		}
+	}
+	return t.AddPlugin(p)
+}
+
+// secretData returns the data of a Secret, with its stringData when it's read from manifests
+func secretData(secret *apiv1.Secret) map[string]string {
+	data := map[string]string{}
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	for k, v := range secret.StringData {
+		data[k] = v
+	}
+	return data
+}
+
+// hasSOPSKeySuffix tells if a key of a decryption Secret is an age or PGP key
+func hasSOPSKeySuffix(key string) bool {
+	for _, s := range sopsKeySuffixes {
+		if strings.HasSuffix(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestGetSOPSDecryption(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace("flux-system")
+	secret.SetName("sops-keys")
+	unstructured.SetNestedStringMap(secret.Object, map[string]string{"age.agekey": "AGE-SECRET-KEY-1", "pgp.asc": "PGP", "sops.vault-token": "token"}, "stringData")
+	c := NewOfflineClient(scheme, secret)
+
+	tests := []struct {
+		name           string
+		decryption     *kustomizev1.Decryption
+		expectedNil    bool
+		expectedKeys   map[string]string
+		expectedSecret string
+		expectedErr    bool
+	}{
+		{
+			name:        "when there is no decryption",
+			expectedNil: true,
+		},
+		{
+			name:           "when the keys are in a Secret",
+			decryption:     &kustomizev1.Decryption{Provider: "sops", SecretRef: &meta.LocalObjectReference{Name: "sops-keys"}},
+			expectedKeys:   map[string]string{"flux-system-sops-keys-age.agekey": "AGE-SECRET-KEY-1", "flux-system-sops-keys-pgp.asc": "PGP"},
+			expectedSecret: "flux-system/sops-keys",
+		},
+		{
+			name:         "when the controller decrypts with its identity",
+			decryption:   &kustomizev1.Decryption{Provider: "sops"},
+			expectedKeys: map[string]string{},
+		},
+		{
+			name:        "when the Secret is missing",
+			decryption:  &kustomizev1.Decryption{Provider: "sops", SecretRef: &meta.LocalObjectReference{Name: "missing"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &kustomizev1.Kustomization{
+				ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "flux-system"},
+				Spec:       kustomizev1.KustomizationSpec{Decryption: tt.decryption},
+			}
+			d, err := GetSOPSDecryption(c, context.TODO(), k)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, d == nil, tt.expectedNil)
+			if d == nil {
+				return
+			}
+			assert.Equal(t, d.Keys, tt.expectedKeys)
+			assert.Equal(t, d.Secret, tt.expectedSecret)
+		})
+	}
+}
+
+func TestNewSOPSPlugin(t *testing.T) {
+	p := NewSOPSPlugin("argocd", "ghcr.io/example/sops:v1")
+	assert.Equal(t, p.Name, SOPSPluginName)
+	assert.Equal(t, p.Image, "ghcr.io/example/sops:v1")
+
+	// The keys are mounted from the Secret every decrypting migration adds its keys to
+	assert.Equal(t, p.Volumes[0].Secret.SecretName, SOPSKeysSecret)
+	assert.Equal(t, p.VolumeMounts[0].MountPath, sopsKeysPath)
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fluxDefaultBranch is the branch Flux checks out when a GitRepository doesn't have a reference
+const fluxDefaultBranch = "master"
+
+// GitRepositoryRevision returns the revision Argo CD should use for a GitRepository, following
+// the Flux order of precedence: commit, name, semver, tag, then branch. Argo CD can't follow a
+// semver range, so it's pinned to the tag Flux resolved it to, from status.artifact.revision.
+func GitRepositoryRevision(ctx context.Context, gitSource *sourcev1.GitRepository) (string, error) {
+	ref := gitSource.Spec.Reference
+	if ref == nil {
+		return fluxDefaultBranch, nil
+	}
+
+	switch {
+	case ref.Commit != "":
+		return ref.Commit, nil
+	case ref.Name != "":
+		return ref.Name, nil
+	case ref.SemVer != "":
+		if gitSource.Status.Artifact == nil || gitSource.Status.Artifact.Revision == "" {
+			return "", fmt.Errorf("GitRepository %s/%s uses the semver range %q and has no artifact to resolve it with", gitSource.Namespace, gitSource.Name, ref.SemVer)
+		}
+		tag := artifactTag(gitSource.Status.Artifact.Revision)
+		LoggerFrom(ctx).Warn("GitRepository ", gitSource.Namespace, "/", gitSource.Name, " uses the semver range \"", ref.SemVer, "\", Argo CD will be pinned to ", tag, " which Flux resolved it to")
+		return tag, nil
+	case ref.Tag != "":
+		return ref.Tag, nil
+	case ref.Branch != "":
+		return ref.Branch, nil
+	}
+
+	// If we're here, nothing was set and Flux uses its default
+	return fluxDefaultBranch, nil
+}
+
+// artifactTag returns the tag of an artifact revision, '<tag>@sha1:<commit>' or, before Flux
+// v2.0, '<tag>/<commit>'
+func artifactTag(revision string) string {
+	if i := strings.Index(revision, "@"); i >= 0 {
+		return revision[:i]
+	}
+	if i := strings.LastIndex(revision, "/"); i >= 0 {
+		return revision[:i]
+	}
+	return revision
+}
+
+// SourceUsers returns the Flux objects, other than the ones being migrated, that still get their
+// manifests or charts from source. A source that's still used isn't suspended or deleted.
+func SourceUsers(c client.Client, ctx context.Context, source client.Object, migrating ...client.Object) ([]string, error) {
+	kind := kindOf(c, source)
+	skip := map[string]bool{}
+	for _, o := range migrating {
+		skip[describeObject(c, o)] = true
+	}
+
+	// References default to the namespace of the object they're in
+	refersTo := func(refKind string, refNamespace string, refName string, ns string) bool {
+		if refNamespace == "" {
+			refNamespace = ns
+		}
+		return refKind == kind && refNamespace == source.GetNamespace() && refName == source.GetName()
+	}
+
+	users := []string{}
+
+	kl := &kustomizev1.KustomizationList{}
+	if err := c.List(ctx, kl); err != nil && !isMissingKind(err) {
+		return nil, err
+	}
+	for i := range kl.Items {
+		k := &kl.Items[i]
+		ref := k.Spec.SourceRef
+		if refersTo(ref.Kind, ref.Namespace, ref.Name, k.Namespace) && !skip[describeObject(c, k)] {
+			users = append(users, describeObject(c, k))
+		}
+	}
+
+	hl := &helmv2.HelmReleaseList{}
+	if err := c.List(ctx, hl); err != nil && !isMissingKind(err) {
+		return nil, err
+	}
+	for i := range hl.Items {
+		h := &hl.Items[i]
+		ref := h.Spec.Chart.Spec.SourceRef
+		if refersTo(ref.Kind, ref.Namespace, ref.Name, h.Namespace) && !skip[describeObject(c, h)] {
+			users = append(users, describeObject(c, h))
+		}
+	}
+
+	return users, nil
+}
+
+// IsSourceShared tells if source is still used by Flux objects that aren't being migrated, and
+// says so
+func IsSourceShared(c client.Client, ctx context.Context, source client.Object, migrating ...client.Object) (bool, error) {
+	users, err := SourceUsers(c, ctx, source, migrating...)
+	if err != nil {
+		return false, err
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+
+	log.Info(describeObject(c, source) + " is still used by " + strings.Join(users, ", ") + ", it's kept and isn't suspended")
+	return true, nil
+}
+
+// isMissingKind tells if an error is because a kind isn't known, like when a Flux controller isn't
+// installed, so there can't be any of them
+func isMissingKind(err error) bool {
+	return meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err)
+}
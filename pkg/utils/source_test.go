@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akuity/mta/pkg/argo"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"github.com/magiconair/properties/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestGitRepositoryRevision(t *testing.T) {
+	tests := []struct {
+		name             string
+		ref              *sourcev1.GitRepositoryRef
+		artifact         *sourcev1.Artifact
+		expectedRevision string
+		expectedErr      bool
+	}{
+		{
+			name:             "when there is no reference",
+			expectedRevision: "master",
+		},
+		{
+			name:             "when the reference is a branch",
+			ref:              &sourcev1.GitRepositoryRef{Branch: "main"},
+			expectedRevision: "main",
+		},
+		{
+			name:             "when the reference is a tag",
+			ref:              &sourcev1.GitRepositoryRef{Branch: "main", Tag: "v1.0.0"},
+			expectedRevision: "v1.0.0",
+		},
+		{
+			name:             "when the reference is a semver range",
+			ref:              &sourcev1.GitRepositoryRef{Tag: "v1.0.0", SemVer: ">=1.0.0"},
+			artifact:         &sourcev1.Artifact{Revision: "v1.2.3@sha1:8fcf8bd2e2a4f1cd5f1e6c8a4d3a2b1c0f9e8d7c"},
+			expectedRevision: "v1.2.3",
+		},
+		{
+			name:             "when the reference is a semver range and the artifact revision is from Flux v0",
+			ref:              &sourcev1.GitRepositoryRef{SemVer: "1.x"},
+			artifact:         &sourcev1.Artifact{Revision: "v1.2.3/8fcf8bd2e2a4f1cd5f1e6c8a4d3a2b1c0f9e8d7c"},
+			expectedRevision: "v1.2.3",
+		},
+		{
+			name:        "when the reference is a semver range and there is no artifact",
+			ref:         &sourcev1.GitRepositoryRef{SemVer: "1.x"},
+			expectedErr: true,
+		},
+		{
+			name:             "when the reference is a name",
+			ref:              &sourcev1.GitRepositoryRef{Name: "refs/pull/420/head", SemVer: "1.x", Branch: "main"},
+			expectedRevision: "refs/pull/420/head",
+		},
+		{
+			name:             "when the reference is a commit",
+			ref:              &sourcev1.GitRepositoryRef{Commit: "8fcf8bd2e2a4f1cd5f1e6c8a4d3a2b1c0f9e8d7c", Name: "refs/heads/main", Branch: "main"},
+			expectedRevision: "8fcf8bd2e2a4f1cd5f1e6c8a4d3a2b1c0f9e8d7c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitSource := &sourcev1.GitRepository{}
+			gitSource.Spec.Reference = tt.ref
+			gitSource.Status.Artifact = tt.artifact
+
+			revision, err := GitRepositoryRevision(context.TODO(), gitSource)
+			assert.Equal(t, err != nil, tt.expectedErr)
+			assert.Equal(t, revision, tt.expectedRevision)
+		})
+	}
+}
+
+func TestNewGitDirApplicationSet(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		expectedInclude string
+		expectedExclude []string
+	}{
+		{
+			name:            "when the path is the root of the repo",
+			path:            "./",
+			expectedInclude: "*",
+			expectedExclude: []string{"extras", "flux-system"},
+		},
+		{
+			name:            "when the path is empty",
+			path:            "",
+			expectedInclude: "*",
+			expectedExclude: []string{"extras", "flux-system"},
+		},
+		{
+			name:            "when the path is a directory",
+			path:            "./clusters/production",
+			expectedInclude: "clusters/production/*",
+			expectedExclude: []string{"extras", "clusters/production/flux-system"},
+		},
+		{
+			name:            "when the path doesn't start with ./",
+			path:            "clusters/production/",
+			expectedInclude: "clusters/production/*",
+			expectedExclude: []string{"extras", "clusters/production/flux-system"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &kustomizev1.Kustomization{}
+			k.Spec.Path = tt.path
+			gitSource := &sourcev1.GitRepository{}
+			gitSource.Spec.URL = "https://github.com/example/fleet"
+			gitSource.Spec.Reference = &sourcev1.GitRepositoryRef{Tag: "v1.0.0"}
+
+			appSet, err := NewGitDirApplicationSet(context.TODO(), "argocd", k, gitSource, argo.RepositoryCredentials{}, []string{"extras"}, DefaultNaming())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, appSet.GitIncludeDir, tt.expectedInclude)
+			assert.Equal(t, appSet.GitExcludeDir, tt.expectedExclude)
+			assert.Equal(t, appSet.GitRepoRevision, "v1.0.0")
+			assert.Equal(t, appSet.AppTargetRevision, "v1.0.0")
+		})
+	}
+}
+
+func TestSourceUsers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	kustomizev1.AddToScheme(scheme)
+	helmv2.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+
+	kustomization := func(name string, source string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(kustomizev1.GroupVersion.WithKind(kustomizev1.KustomizationKind))
+		u.SetNamespace("flux-system")
+		u.SetName(name)
+		unstructured.SetNestedMap(u.Object, map[string]interface{}{"kind": "GitRepository", "name": source}, "spec", "sourceRef")
+		return u
+	}
+
+	// A HelmRelease with its chart in the same repo, in another namespace
+	hr := &unstructured.Unstructured{}
+	hr.SetGroupVersionKind(helmv2.GroupVersion.WithKind(helmv2.HelmReleaseKind))
+	hr.SetNamespace("apps")
+	hr.SetName("podinfo")
+	unstructured.SetNestedMap(hr.Object, map[string]interface{}{"kind": "GitRepository", "namespace": "flux-system", "name": "fleet"}, "spec", "chart", "spec", "sourceRef")
+
+	c := NewOfflineClient(scheme, kustomization("apps", "fleet"), kustomization("infra", "fleet"), kustomization("other", "other"), hr)
+	ctx := context.TODO()
+
+	gitSource := &sourcev1.GitRepository{}
+	gitSource.SetNamespace("flux-system")
+	gitSource.SetName("fleet")
+	migrating := &kustomizev1.Kustomization{}
+	migrating.SetNamespace("flux-system")
+	migrating.SetName("apps")
+
+	users, err := SourceUsers(c, ctx, gitSource, migrating)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, users, []string{"Kustomization/flux-system/infra", "HelmRelease/apps/podinfo"})
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The states of a Flux object scan shows
+const (
+	StatusReady       = "Ready"
+	StatusNotReady    = "NotReady"
+	StatusReconciling = "Reconciling"
+	StatusStalled     = "Stalled"
+	StatusSuspended   = "Suspended"
+	StatusUnknown     = "Unknown"
+)
+
+// FluxStatus returns the state of a Flux object from its conditions, and the message that goes
+// with it. Suspended wins over Stalled, which wins over Reconciling, which wins over the Ready
+// condition. An object that has never been reconciled has no conditions, and is Unknown.
+func FluxStatus(conditions []metav1.Condition, suspended bool) (string, string) {
+	ready := apimeta.FindStatusCondition(conditions, meta.ReadyCondition)
+	if suspended {
+		if ready != nil && ready.Message != "" {
+			return StatusSuspended, ready.Message
+		}
+		return StatusSuspended, "Reconciliation is suspended"
+	}
+	if stalled := apimeta.FindStatusCondition(conditions, meta.StalledCondition); stalled != nil && stalled.Status == metav1.ConditionTrue {
+		return StatusStalled, stalled.Message
+	}
+	if reconciling := apimeta.FindStatusCondition(conditions, meta.ReconcilingCondition); reconciling != nil && reconciling.Status == metav1.ConditionTrue {
+		return StatusReconciling, reconciling.Message
+	}
+	if ready == nil {
+		return StatusUnknown, "Not reconciled yet"
+	}
+
+	// Otherwise the Ready condition has the last word
+	switch ready.Status {
+	case metav1.ConditionTrue:
+		return StatusReady, ready.Message
+	case metav1.ConditionFalse:
+		return StatusNotReady, ready.Message
+	}
+	return StatusUnknown, ready.Message
+}
+
+// FluxStatusMsg returns the state and message of a Flux object truncated for a table
+func FluxStatusMsg(conditions []metav1.Condition, suspended bool) string {
+	status, msg := FluxStatus(conditions, suspended)
+	if msg == "" {
+		return status
+	}
+	return TruncMsg(status + ": " + msg)
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFluxStatus(t *testing.T) {
+	ready := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Message: "Applied revision: main@sha1:abc"}
+	notReady := metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Message: "kustomize build failed"}
+	reconciling := metav1.Condition{Type: meta.ReconcilingCondition, Status: metav1.ConditionTrue, Message: "Building manifests"}
+	stalled := metav1.Condition{Type: meta.StalledCondition, Status: metav1.ConditionTrue, Message: "Source not found"}
+
+	tests := []struct {
+		name            string
+		conditions      []metav1.Condition
+		suspended       bool
+		expectedStatus  string
+		expectedMessage string
+	}{
+		{
+			name:            "when it has never been reconciled",
+			expectedStatus:  StatusUnknown,
+			expectedMessage: "Not reconciled yet",
+		},
+		{
+			name:            "when Ready isn't the first condition",
+			conditions:      []metav1.Condition{{Type: "Healthy", Status: metav1.ConditionTrue, Message: "Health check passed"}, ready},
+			expectedStatus:  StatusReady,
+			expectedMessage: ready.Message,
+		},
+		{
+			name:            "when it isn't Ready",
+			conditions:      []metav1.Condition{notReady},
+			expectedStatus:  StatusNotReady,
+			expectedMessage: notReady.Message,
+		},
+		{
+			name:            "when it's reconciling",
+			conditions:      []metav1.Condition{reconciling, ready},
+			expectedStatus:  StatusReconciling,
+			expectedMessage: reconciling.Message,
+		},
+		{
+			name:            "when it's stalled",
+			conditions:      []metav1.Condition{reconciling, notReady, stalled},
+			expectedStatus:  StatusStalled,
+			expectedMessage: stalled.Message,
+		},
+		{
+			name:            "when it's suspended",
+			conditions:      []metav1.Condition{ready},
+			suspended:       true,
+			expectedStatus:  StatusSuspended,
+			expectedMessage: ready.Message,
+		},
+		{
+			name:            "when it's suspended before it has been reconciled",
+			suspended:       true,
+			expectedStatus:  StatusSuspended,
+			expectedMessage: "Reconciliation is suspended",
+		},
+		{
+			name:            "when Ready is unknown",
+			conditions:      []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionUnknown, Message: "Reconciliation in progress"}},
+			expectedStatus:  StatusUnknown,
+			expectedMessage: "Reconciliation in progress",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, msg := FluxStatus(tt.conditions, tt.suspended)
+			assert.Equal(t, status, tt.expectedStatus)
+			assert.Equal(t, msg, tt.expectedMessage)
+		})
+	}
+}
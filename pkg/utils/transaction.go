@@ -0,0 +1,363 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/printers"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How long a rollback waits for a deleted Flux object to go away (finalizers) before recreating it
+var rollbackDeleteTimeout = 2 * time.Minute
+
+// transactionStep is a change made by a MigrationTransaction and what's needed to undo it
+type transactionStep struct {
+	action string
+	obj    client.Object
+	// wasSuspended is set for "suspend" steps when the object was already suspended
+	wasSuspended bool
+	// undo is the patch that reverts a "patch" step, if it needs reverting
+	undo client.Patch
+}
+
+// MigrationTransaction runs the steps of a migration (suspend, create, delete) and records each
+// one so a failure part way through can be undone with Rollback. Deleted objects are kept so they
+// can be recreated. Every step is also written to the Journal, which is saved to the journal
+// stores after each step so a finished migration can be rolled back later.
+type MigrationTransaction struct {
+	Journal *Journal
+
+	client client.Client
+	ctx    context.Context
+	steps  []transactionStep
+	stores []JournalStore
+	saved  map[string]bool
+}
+
+// NewMigrationTransaction returns an empty MigrationTransaction using c
+func NewMigrationTransaction(c client.Client, ctx context.Context) *MigrationTransaction {
+	return &MigrationTransaction{
+		Journal: NewJournal(),
+		client:  c,
+		ctx:     ctx,
+		saved:   map[string]bool{},
+	}
+}
+
+// WithJournal sets the stores the journal is saved to
+func (t *MigrationTransaction) WithJournal(stores ...JournalStore) *MigrationTransaction {
+	t.stores = stores
+	return t
+}
+
+// saveJournal writes the journal to every store
+func (t *MigrationTransaction) saveJournal() error {
+	for _, s := range t.stores {
+		if err := s.Save(t.Journal); err != nil {
+			return fmt.Errorf("saving migration journal %s: %w", t.Journal.ID, err)
+		}
+	}
+	return nil
+}
+
+// record adds a step to the journal and saves it
+func (t *MigrationTransaction) record(action string, obj client.Object) error {
+	t.Journal.addAction(action, kindOf(t.client, obj), obj)
+	return t.saveJournal()
+}
+
+// keepOriginal adds the Flux object, as it is before we change it, to the journal
+func (t *MigrationTransaction) keepOriginal(obj client.Object) error {
+	key := describeObject(t.client, obj)
+	if t.saved[key] {
+		return nil
+	}
+
+	m, err := journalManifest(t.client, obj)
+	if err != nil {
+		return err
+	}
+	t.Journal.FluxObjects = append(t.Journal.FluxObjects, m)
+	t.saved[key] = true
+	return nil
+}
+
+// Suspend suspends Flux objects and records them so they can be resumed
+func (t *MigrationTransaction) Suspend(obj ...client.Object) error {
+	for _, o := range obj {
+		// Remember if the object was suspended before we got here, we shouldn't resume those
+		suspended, err := isSuspended(o)
+		if err != nil {
+			return err
+		}
+		if err := t.keepOriginal(o); err != nil {
+			return err
+		}
+
+		if err := SuspendFluxObject(t.client, t.ctx, o); err != nil {
+			return err
+		}
+		t.steps = append(t.steps, transactionStep{action: "suspend", obj: o, wasSuspended: suspended})
+		if err := t.record("suspend", o); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// Create creates objects and records them so they can be deleted
+func (t *MigrationTransaction) Create(obj ...client.Object) error {
+	for _, o := range obj {
+		if err := CreateK8SObjects(t.client, t.ctx, o); err != nil {
+			return err
+		}
+		t.steps = append(t.steps, transactionStep{action: "create", obj: o})
+
+		m, err := journalManifest(t.client, o)
+		if err != nil {
+			return err
+		}
+		t.Journal.ArgoObjects = append(t.Journal.ArgoObjects, m)
+		if err := t.record("create", o); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// CreateIfMissing creates the objects that aren't there yet, like repository Secrets shared by
+// migrations from the same repository. All of them are journaled as shared, the ones already there
+// are left alone and rollback only deletes the ones it created once nothing else uses them.
+func (t *MigrationTransaction) CreateIfMissing(obj ...client.Object) error {
+	for _, o := range obj {
+		existing := o.DeepCopyObject().(client.Object)
+		err := t.client.Get(t.ctx, client.ObjectKeyFromObject(o), existing)
+		if err == nil {
+			log.Info(describeObject(t.client, o) + " already exists, keeping it")
+			if err := t.share("use", o, false); err != nil {
+				return err
+			}
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		if err := CreateK8SObjects(t.client, t.ctx, o); err != nil {
+			return err
+		}
+		t.steps = append(t.steps, transactionStep{action: "create", obj: o})
+		if err := t.share("create", o, true); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// share adds an object other migrations may use too to the journal, created when this migration
+// created it
+func (t *MigrationTransaction) share(action string, obj client.Object, created bool) error {
+	t.Journal.SharedObjects = append(t.Journal.SharedObjects, newSharedObject(t.client, obj, created))
+	return t.record(action, obj)
+}
+
+// Patch patches obj and records the change in the journal as action. Rollback applies undo to
+// revert it, a nil undo leaves the change in place. undo is kept in the journal too, so a finished
+// migration can be reverted.
+func (t *MigrationTransaction) Patch(action string, obj client.Object, patch client.Patch, undo client.Patch) error {
+	if err := t.client.Patch(t.ctx, obj, patch); err != nil {
+		return err
+	}
+	t.steps = append(t.steps, transactionStep{action: "patch", obj: obj, undo: undo})
+
+	if undo != nil {
+		p, err := newJournalPatch(t.client, obj, undo)
+		if err != nil {
+			return err
+		}
+		t.Journal.Undo = append(t.Journal.Undo, p)
+	}
+
+	// If we're here, it should have gone okay...
+	return t.record(action, obj)
+}
+
+// Delete deletes objects, keeping a copy of each so it can be recreated
+func (t *MigrationTransaction) Delete(obj ...client.Object) error {
+	for _, o := range obj {
+		// Keep the object as it was, with its kind, before it's gone
+		saved := o.DeepCopyObject().(client.Object)
+		if gvk, err := t.client.GroupVersionKindFor(o); err == nil {
+			saved.GetObjectKind().SetGroupVersionKind(gvk)
+		}
+		if err := t.keepOriginal(o); err != nil {
+			return err
+		}
+
+		if err := DeleteK8SObjects(t.client, t.ctx, o); err != nil {
+			return err
+		}
+		t.steps = append(t.steps, transactionStep{action: "delete", obj: saved})
+		if err := t.record("delete", o); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// Rollback undoes the recorded steps in reverse order: deleted objects are recreated, created
+// objects are deleted and suspended objects are resumed. It keeps going when a step fails and
+// returns all the errors. Objects that couldn't be recreated are printed to stderr so they aren't lost.
+func (t *MigrationTransaction) Rollback() error {
+	var errs []error
+
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		s := t.steps[i]
+		name := describeObject(t.client, s.obj)
+
+		switch s.action {
+		case "delete":
+			log.Info("Rollback: recreating ", name)
+			if err := t.recreate(s.obj); err != nil {
+				errs = append(errs, fmt.Errorf("recreating %s: %w", name, err))
+				log.Error("Rollback: could not recreate ", name, ", this is what it looked like:")
+				printr := printers.NewTypeSetter(t.client.Scheme()).ToPrinter(&printers.YAMLPrinter{})
+				printr.PrintObj(s.obj, os.Stderr)
+				continue
+			}
+		case "create":
+			log.Info("Rollback: deleting ", name)
+			if err := t.client.Delete(t.ctx, s.obj); err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("deleting %s: %w", name, err))
+				continue
+			}
+		case "patch":
+			if s.undo == nil {
+				continue
+			}
+			log.Info("Rollback: reverting changes to ", name)
+			if err := t.client.Patch(t.ctx, s.obj, s.undo); err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("reverting %s: %w", name, err))
+				continue
+			}
+		case "suspend":
+			if s.wasSuspended {
+				continue
+			}
+			log.Info("Rollback: resuming ", name)
+			if err := ResumeFluxObject(t.client, t.ctx, s.obj); err != nil {
+				errs = append(errs, fmt.Errorf("resuming %s: %w", name, err))
+				continue
+			}
+		}
+		t.Journal.addAction("rollback-"+s.action, kindOf(t.client, s.obj), s.obj)
+	}
+
+	// The journal still has the originals, save it whatever happened. Once it's all undone, the
+	// shared objects aren't used by this migration anymore.
+	t.Journal.RolledBack = len(errs) == 0
+	if err := t.saveJournal(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 && len(t.stores) > 0 {
+		log.Error("Rollback incomplete, the original Flux objects are in migration journal ", t.Journal.ID)
+	}
+
+	t.steps = nil
+	return utilerrors.NewAggregate(errs)
+}
+
+// Abort rolls the transaction back after err and returns an error describing both
+func (t *MigrationTransaction) Abort(err error) error {
+	log.Error("Migration failed: ", err)
+	if rerr := t.Rollback(); rerr != nil {
+		return fmt.Errorf("%w; rollback failed: %s", err, rerr)
+	}
+	return fmt.Errorf("%w; the migration was rolled back", err)
+}
+
+// Stop ends the migration after err without rolling it back, so what's been done so far can be
+// looked at. The journal has everything needed to roll it back later.
+func (t *MigrationTransaction) Stop(err error) error {
+	log.Error("Migration stopped, the Flux objects are suspended and have not been deleted")
+	if len(t.stores) > 0 {
+		log.Error("Undo the migration with: mta rollback --journal ", t.Journal.ID)
+	}
+	return err
+}
+
+// recreate waits for a deleted object to be gone and creates it again
+func (t *MigrationTransaction) recreate(obj client.Object) error {
+	key := client.ObjectKeyFromObject(obj)
+
+	// Flux finalizers can keep the object around for a bit
+	err := wait.PollImmediate(time.Second, rollbackDeleteTimeout, func() (bool, error) {
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+		err := t.client.Get(t.ctx, key, existing)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Clear out what the API server sets so it can be created again
+	o := obj.DeepCopyObject().(client.Object)
+	o.SetResourceVersion("")
+	o.SetUID(types.UID(""))
+	o.SetCreationTimestamp(metav1.Time{})
+	o.SetDeletionTimestamp(nil)
+	o.SetDeletionGracePeriodSeconds(nil)
+	o.SetManagedFields(nil)
+
+	return t.client.Create(t.ctx, o)
+}
+
+// isSuspended reads spec.suspend from any Flux object
+func isSuspended(obj client.Object) (bool, error) {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+	suspended, _, err := unstructured.NestedBool(m, "spec", "suspend")
+	return suspended, err
+}
+
+// kindOf returns the Kind of obj, typed objects from the client don't always have it set
+func kindOf(c client.Client, obj client.Object) string {
+	if gvk, err := c.GroupVersionKindFor(obj); err == nil {
+		return gvk.Kind
+	}
+	return obj.GetObjectKind().GroupVersionKind().Kind
+}
+
+// describeObject returns Kind/namespace/name for log messages
+func describeObject(c client.Client, obj client.Object) string {
+	kind := kindOf(c, obj)
+	if obj.GetNamespace() == "" {
+		return kind + "/" + obj.GetName()
+	}
+	return kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
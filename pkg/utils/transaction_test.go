@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/magiconair/properties/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMigrationTransactionRollback(t *testing.T) {
+	scheme := runtime.NewScheme()
+	helmv2.AddToScheme(scheme)
+	sourcev1.AddToScheme(scheme)
+	argov1alpha1.AddToScheme(scheme)
+
+	// A HelmRelease and an already suspended HelmRepository
+	hr := &unstructured.Unstructured{}
+	hr.SetGroupVersionKind(helmv2.GroupVersion.WithKind("HelmRelease"))
+	hr.SetNamespace("flux-system")
+	hr.SetName("podinfo")
+
+	repo := &unstructured.Unstructured{}
+	repo.SetGroupVersionKind(sourcev1.GroupVersion.WithKind("HelmRepository"))
+	repo.SetNamespace("flux-system")
+	repo.SetName("podinfo")
+	unstructured.SetNestedField(repo.Object, true, "spec", "suspend")
+
+	// An Application that already exists, so creating it again fails
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(argov1alpha1.SchemeGroupVersion.WithKind("Application"))
+	existing.SetNamespace("argocd")
+	existing.SetName("taken")
+
+	c := NewOfflineClient(scheme, hr, repo, existing)
+	ctx := context.TODO()
+
+	helmRelease := &helmv2.HelmRelease{}
+	c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, helmRelease)
+	helmRepo := &sourcev1.HelmRepository{}
+	c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, helmRepo)
+
+	newApp := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: "podinfo"}}
+	takenApp := &argov1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: "taken"}}
+
+	tx := NewMigrationTransaction(c, ctx)
+	if err := tx.Suspend(helmRelease, helmRepo); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create(newApp); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(helmRelease); err != nil {
+		t.Fatal(err)
+	}
+
+	// This one fails, everything before it should be undone
+	err := tx.Create(takenApp)
+	assert.Equal(t, apierrors.IsAlreadyExists(err), true)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The HelmRelease is back and resumed
+	gotRelease := &helmv2.HelmRelease{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, gotRelease); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gotRelease.Spec.Suspend, false)
+
+	// The HelmRepository was suspended before, so it stays suspended
+	gotRepo := &sourcev1.HelmRepository{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "flux-system", Name: "podinfo"}, gotRepo); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gotRepo.Spec.Suspend, true)
+
+	// The Application we created is gone, the one that was there before isn't
+	err = c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "podinfo"}, &argov1alpha1.Application{})
+	assert.Equal(t, apierrors.IsNotFound(err), true)
+	err = c.Get(ctx, types.NamespacedName{Namespace: "argocd", Name: "taken"}, &argov1alpha1.Application{})
+	assert.Equal(t, err, nil)
+}
@@ -3,115 +3,256 @@ package utils
 import (
 	"context"
 	"os"
-	"strconv"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/akuity/mta/pkg/argo"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
 	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
-	yaml "sigs.k8s.io/yaml"
+	log "github.com/sirupsen/logrus"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/fluxcd/flux2/pkg/log"
+	fluxlog "github.com/fluxcd/flux2/pkg/log"
 	fluxuninstall "github.com/fluxcd/flux2/pkg/uninstall"
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1"
-	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// MigrateKustomizationToApplicationSet migrates a Kustomization to an Argo CD ApplicationSet
-func MigrateKustomizationToApplicationSet(c client.Client, ctx context.Context, ans string, k kustomizev1.Kustomization, exd []string) error {
-	// excludedDirs will be paths excluded by the gidir generator
-	excludedDirs := exd
+// MigrationOptions change how a migration is done
+type MigrationOptions struct {
+	// Stores are where the migration journal is saved
+	Stores []JournalStore
+	// Adopt hands the live resources over to Argo CD without Flux removing them when its objects
+	// are deleted
+	Adopt bool
+	// WaitTimeout is how long to wait for the Applications to be Synced and Healthy before the Flux
+	// objects are deleted, 0 doesn't wait
+	WaitTimeout time.Duration
+	// DryRun is set when the client only sends server-side dry-runs, so there's nothing to wait for
+	DryRun bool
+	// Offline is set when the Flux objects are read from manifests, which often don't have the
+	// Secrets next to them, so a missing source Secret is only warned about
+	Offline bool
+	// PluginImage is the image of the plugin that renders Kustomizations with postBuild
+	// substitutions, DefaultPluginImage if it's empty
+	PluginImage string
+	// SOPSPluginImage is the image of the plugin that decrypts Kustomizations, it needs sops. Those
+	// Kustomizations aren't migrated without it.
+	SOPSPluginImage string
+	// Naming has the templates the Argo CD objects are named with
+	Naming Naming
+	// OutputMode is what Kustomizations are migrated to, OutputModeApplicationSet if it's empty
+	OutputMode string
+	// SyncWaves are the sync waves of the Applications, from the dependsOn of the Flux objects
+	SyncWaves map[ObjectRef]int
+	// DropSecretValues migrates HelmReleases that get values from Secrets without those values,
+	// instead of refusing to
+	DropSecretValues bool
+	// ClusterNames map the kubeConfig Secrets of Flux objects, by "namespace/name" or "name", to the
+	// names of clusters already registered with Argo CD
+	ClusterNames map[string]string
+}
 
-	// Get the GitRepository from the Kustomization
-	// get the gitsource
-	gitSource := &sourcev1.GitRepository{}
-	err := c.Get(ctx, types.NamespacedName{Namespace: k.Namespace, Name: k.Name}, gitSource)
-	if err != nil {
-		return err
+// setSyncWave annotates what a Flux object is migrated to with its sync wave, if it has one
+func (o MigrationOptions) setSyncWave(ref ObjectRef, app client.Object) {
+	if wave, ok := o.SyncWaves[ref]; ok {
+		argo.SetSyncWave(app, wave)
 	}
+}
 
-	//Get the secret holding the info we need
-	//secret, err := _.CoreV1().Secrets(k.Namespace).Get(ctx, gitSource.Spec.SecretRef.Name, v1.GetOptions{})
-	secret := &apiv1.Secret{}
-	err = c.Get(ctx, types.NamespacedName{Namespace: k.Namespace, Name: gitSource.Spec.SecretRef.Name}, secret)
+// NewGitDirApplicationSet returns the ApplicationSet a Kustomization is migrated to, with an
+// Application for each directory under the Kustomization's path. exd are more directories to
+// exclude from the Git directory generator, besides flux-system. The ApplicationSet and its
+// repository Secret are named with naming.
+func NewGitDirApplicationSet(ctx context.Context, ans string, k *kustomizev1.Kustomization, gitSource *sourcev1.GitRepository, creds argo.RepositoryCredentials, exd []string, naming Naming) (argo.GitDirApplicationSet, error) {
+	// Argo CD ApplicationSet is sensitive about how you give it paths in the Git Dir generator,
+	// they're relative to the root of the repo without a leading "./" or "/"
+	sourcePath := `*`
+	sourcePathExclude := "flux-system"
+	if dir := strings.TrimPrefix(path.Clean("/"+k.Spec.Path), "/"); dir != "" {
+		sourcePath = dir + "/*"
+		sourcePathExclude = dir + "/flux-system"
+	}
+
+	// Add sourcePathExclude to the excluded dirs, without changing the caller's
+	excludedDirs := append(append([]string{}, exd...), sourcePathExclude)
+
+	// Pin Argo CD to what Flux checks out
+	revision, err := GitRepositoryRevision(ctx, gitSource)
 	if err != nil {
-		return err
+		return argo.GitDirApplicationSet{}, err
 	}
 
-	//Argo CD ApplicationSet is sensitive about how you give it paths in the Git Dir generator. We need to figure some things out
-	var sourcePath string
-	var sourcePathExclude string
-
-	spl := strings.SplitAfter(k.Spec.Path, "./")
-
-	if len(spl[1]) == 0 {
-		sourcePath = `*`
-		sourcePathExclude = "flux-system"
-	} else {
-		sourcePath = spl[1] + "/*"
-		sourcePathExclude = spl[1] + "/flux-system"
+	// Name the ApplicationSet after the Kustomization, and the Secret after the repo
+	d := kustomizationNameData(k, gitSource)
+	name, err := naming.KustomizationName(d)
+	if err != nil {
+		return argo.GitDirApplicationSet{}, err
+	}
+	secretName, err := naming.RepositorySecretName(d)
+	if err != nil {
+		return argo.GitDirApplicationSet{}, err
 	}
 
-	// Add sourcePathExclude to the excludedDirs
-	excludedDirs = append(excludedDirs, sourcePathExclude)
+	// The Applications of the bootstrap Kustomization keep the names of their directories, the
+	// others' start with the ApplicationSet's name so ApplicationSets don't make the same ones
+	prefix := ""
+	if k.Name != bootstrapKustomization {
+		prefix = name + "-"
+	}
 
-	// Generate the ApplicationSet manifest based on the struct
-	applicationSet := argo.GitDirApplicationSet{
+	return argo.GitDirApplicationSet{
+		Name:                    name,
 		Namespace:               ans,
 		GitRepoURL:              gitSource.Spec.URL,
-		GitRepoRevision:         gitSource.Spec.Reference.Branch,
+		GitRepoRevision:         revision,
 		GitIncludeDir:           sourcePath,
 		GitExcludeDir:           excludedDirs,
-		AppName:                 "{{path.basename}}",
+		AppName:                 sanitizeNameTemplate(prefix),
 		AppProject:              "default",
 		AppRepoURL:              gitSource.Spec.URL,
-		AppTargetRevision:       gitSource.Spec.Reference.Branch,
-		AppPath:                 "{{path}}",
-		AppDestinationServer:    "https://kubernetes.default.svc",
+		AppTargetRevision:       revision,
+		AppPath:                 "{{.path.path}}",
+		AppDestinationServer:    argo.InClusterServer,
 		AppDestinationNamespace: k.Spec.TargetNamespace,
-		SSHPrivateKey:           string(secret.Data["identity"]),
+		Credentials:             creds,
 		GitOpsRepo:              gitSource.Spec.URL,
+		SecretName:              secretName,
+	}, nil
+}
+
+// MigrateKustomizationToApplicationSet migrates a Kustomization to an Argo CD ApplicationSet, or an
+// Application with the application output mode
+func MigrateKustomizationToApplicationSet(c client.Client, ctx context.Context, ans string, k kustomizev1.Kustomization, exd []string, opts MigrationOptions) error {
+	// Generate the Application or ApplicationSet, with its repository Secret
+	m, err := GetKustomizationMigration(c, ctx, ans, &k, exd, opts)
+	if err != nil {
+		return err
 	}
+	gitSource := m.Source
+	opts.setSyncWave(ObjectRef{Kind: kustomizev1.KustomizationKind, Namespace: k.Namespace, Name: k.Name}, m.App)
 
-	appset, err := argo.GenGitDirAppSet(applicationSet)
+	// Other Kustomizations and HelmReleases may still need the GitRepository
+	shared, err := IsSourceShared(c, ctx, gitSource, &k)
 	if err != nil {
 		return err
 	}
 
-	// Generate the ApplicationSet Secret and set the GVK
-	appsetSecret := GenK8SSecret(applicationSet)
+	// Record every step so a failure can be rolled back
+	tx := NewMigrationTransaction(c, ctx).WithJournal(opts.Stores...)
 
 	// Suspend Kustomization reconcilation
-	if err := SuspendFluxObject(c, ctx, &k); err != nil {
-		return err
-
+	if err := tx.Suspend(&k); err != nil {
+		return tx.Abort(err)
 	}
 
 	// Suspend git repo reconcilation
-	if err := SuspendFluxObject(c, ctx, gitSource); err != nil {
-		return err
+	if !shared {
+		if err := tx.Suspend(gitSource); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
-	// Finally, create the Argo CD Application
-	if err := CreateK8SObjects(c, ctx, appsetSecret, appset); err != nil {
-		return err
+	// Make sure deleting the Kustomization won't prune what it deployed
+	if opts.Adopt {
+		if err := tx.DisablePrune(&k); err != nil {
+			return tx.Abort(err)
+		}
+
+		// A single Application deploys everything the Kustomization did, label it for the
+		// Application. The Applications of an ApplicationSet are only known once it's generated.
+		if app, ok := m.App.(*argov1alpha1.Application); ok {
+			if err := AdoptKustomization(tx, &k, app.Name); err != nil {
+				return tx.Abort(err)
+			}
+		}
+	}
+
+	// Trust the repository's CA and SSH host keys
+	if err := tx.AddRepositoryConfig(ans, m.RepoURL, m.Credentials); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Add the plugin the Applications are rendered with
+	if m.Plugin {
+		if err := tx.AddPlugin(NewEnvsubstPlugin(ans, opts.PluginImage)); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// Add the plugin and keys the Applications are decrypted with
+	if err := tx.AddSOPSPlugin(m.Decryption, NewSOPSPlugin(ans, opts.SOPSPluginImage)); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Commit the image updates like Flux did
+	if len(m.ImageUpdaterConfig) > 0 {
+		if err := tx.MergeConfigMap(ans, argo.ImageUpdaterConfigMap, m.ImageUpdaterConfig, ReplaceValue); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// Send the alerts Flux sent
+	if err := tx.AddNotifications(ans, m.Notifications); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Register the cluster it deploys to, other migrations may have already
+	if m.ClusterSecret != nil {
+		if err := tx.CreateIfMissing(m.ClusterSecret); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// The tenant's project may already be there from another migration
+	if m.Project != nil {
+		if err := tx.CreateIfMissing(m.Project); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// Finally, create the Argo CD Application or ApplicationSet, the repository Secret may already
+	// be there from another migration
+	if err := tx.CreateIfMissing(m.Secret); err != nil {
+		return tx.Abort(err)
+	}
+	if err := tx.Create(m.App); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Only let go of Flux once Argo CD has taken over, if it doesn't leave everything as it is
+	if err := waitForArgoCD(c, ctx, m.App, opts); err != nil {
+		return tx.Stop(err)
+	}
+	if opts.Adopt {
+		if err := tx.RemoveFinalizers(&k); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
 	// Delete the Kustomization
-	if err := DeleteK8SObjects(c, ctx, &k); err != nil {
-		return err
+	if err := tx.Delete(&k); err != nil {
+		return tx.Abort(err)
 	}
 
 	// Delete the GitRepository
-	if err := DeleteK8SObjects(c, ctx, gitSource); err != nil {
-		return err
+	if !shared {
+		if err := tx.Delete(gitSource); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	if len(opts.Stores) > 0 {
+		log.Info("Migration journal " + tx.Journal.ID + " saved, undo the migration with: mta rollback --journal " + tx.Journal.ID)
 	}
 
 	// If we're here, it should have gone okay...
@@ -119,85 +260,132 @@ func MigrateKustomizationToApplicationSet(c client.Client, ctx context.Context,
 }
 
 // MigrateHelmReleaseToApplication migrates a HelmRelease to an Argo CD Application
-func MigrateHelmReleaseToApplication(c client.Client, ctx context.Context, ans string, h helmv2.HelmRelease) error {
-	// Get the helmchart based on type, report if error
-	helmRepo := &sourcev1.HelmRepository{}
-	helmChart := &sourcev1.HelmChart{}
-	err := c.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: h.Spec.Chart.Spec.SourceRef.Name}, helmRepo)
+func MigrateHelmReleaseToApplication(c client.Client, ctx context.Context, ans string, h helmv2.HelmRelease, opts MigrationOptions) error {
+	// Generate the Application, with its repository Secret
+	m, err := GetHelmReleaseMigration(c, ctx, ans, &h, opts)
 	if err != nil {
 		return err
 	}
-	err = c.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: h.Namespace + "-" + h.Name}, helmChart)
-	if err != nil {
+	source, repoSecret, helmArgoCdApp := m.Source, m.Secret, m.App
+	opts.setSyncWave(ObjectRef{Kind: helmv2.HelmReleaseKind, Namespace: h.Namespace, Name: h.Name}, m.App)
+
+	// Flux generates the HelmChart next to the source, so there's nothing to suspend or delete if it isn't there
+	helmChart := &sourcev1beta2.HelmChart{}
+	err = c.Get(ctx, types.NamespacedName{Namespace: source.GetNamespace(), Name: h.GetHelmChartName()}, helmChart)
+	if apierrors.IsNotFound(err) {
+		helmChart = nil
+	} else if err != nil {
 		return err
 	}
 
-	// Get the Values from the HelmRelease
-	yaml, err := yaml.Marshal(h.Spec.Values)
+	// Other HelmReleases and Kustomizations may still need the source
+	shared, err := IsSourceShared(c, ctx, source, &h)
 	if err != nil {
 		return err
 	}
 
-	// Generate the Argo CD Helm Application
-	helmApp := argo.ArgoCdHelmApplication{
-		Name:                 h.Spec.TargetNamespace + "-" + h.Name,
-		Namespace:            ans,
-		DestinationNamespace: h.Spec.TargetNamespace,
-		DestinationServer:    "https://kubernetes.default.svc",
-		Project:              "default",
-		HelmChart:            h.Spec.Chart.Spec.Chart,
-		HelmRepo:             helmRepo.Spec.URL,
-		HelmTargetRevision:   h.Spec.Chart.Spec.Version,
-		HelmValues:           string(yaml),
-		HelmCreateNamespace:  strconv.FormatBool(h.Spec.Install.CreateNamespace),
+	// Record every step so a failure can be rolled back
+	tx := NewMigrationTransaction(c, ctx).WithJournal(opts.Stores...)
+
+	// Suspend helm reconcilation
+	if err := tx.Suspend(&h); err != nil {
+		return tx.Abort(err)
 	}
 
-	helmArgoCdApp, err := argo.GenArgoCdHelmApplication(helmApp)
-	if err != nil {
-		return err
+	// Suspend helm repo reconcilation
+	if !shared {
+		if err := tx.Suspend(source); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
-	// Suspend helm reconcilation
-	if err := SuspendFluxObject(c, ctx, &h); err != nil {
-		return err
+	// Suspend helm chart reconcilation
+	if helmChart != nil {
+		if err := tx.Suspend(helmChart); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
-	// Suspend helm repo reconcilation
-	if err := SuspendFluxObject(c, ctx, helmRepo); err != nil {
-		return err
+	// Label the live resources so the first sync only patches them
+	if opts.Adopt {
+		if err := AdoptHelmRelease(tx, c, ctx, &h, helmArgoCdApp.Name); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
-	// Suspend helm repo reconcilation
-	if err := SuspendFluxObject(c, ctx, helmChart); err != nil {
-		return err
+	// Create the repository Secret, trusting the repository's CA and SSH host keys
+	if repoSecret != nil {
+		if err := tx.AddRepositoryConfig(ans, m.RepoURL, m.Credentials); err != nil {
+			return tx.Abort(err)
+		}
+		if err := tx.CreateIfMissing(repoSecret); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// Send the alerts Flux sent
+	if err := tx.AddNotifications(ans, m.Notifications); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Register the cluster it deploys to, other migrations may have already
+	if m.ClusterSecret != nil {
+		if err := tx.CreateIfMissing(m.ClusterSecret); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	// The tenant's project may already be there from another migration
+	if m.Project != nil {
+		if err := tx.CreateIfMissing(m.Project); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
 	// Finally, create the Argo CD Application
-	if err := CreateK8SObjects(c, ctx, helmArgoCdApp); err != nil {
-		return err
+	if err := tx.Create(helmArgoCdApp); err != nil {
+		return tx.Abort(err)
+	}
+
+	// Only let go of Flux once Argo CD has taken over, if it doesn't leave everything as it is
+	if err := waitForArgoCD(c, ctx, helmArgoCdApp, opts); err != nil {
+		return tx.Stop(err)
+	}
+	if opts.Adopt {
+		if err := tx.RemoveFinalizers(&h); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
 	// Delete the HelmRelease
-	if err := DeleteK8SObjects(c, ctx, &h); err != nil {
-		return err
+	if err := tx.Delete(&h); err != nil {
+		return tx.Abort(err)
 	}
 
-	// Delete the HelmRepository
-	if err := DeleteK8SObjects(c, ctx, helmRepo); err != nil {
-		return err
+	// Delete the HelmRepository or GitRepository
+	if !shared {
+		if err := tx.Delete(source); err != nil {
+			return tx.Abort(err)
+		}
 	}
 
 	// Delete the HelmChart
-	if err := DeleteK8SObjects(c, ctx, helmChart); err != nil {
-		return err
+	if helmChart != nil {
+		if err := tx.Delete(helmChart); err != nil {
+			return tx.Abort(err)
+		}
+	}
+
+	if len(opts.Stores) > 0 {
+		log.Info("Migration journal " + tx.Journal.ID + " saved, undo the migration with: mta rollback --journal " + tx.Journal.ID)
 	}
 
 	// If we're here, it should have gone okay...
 	return nil
 }
 
-// FluxCleanUp cleans up flux resources
-func FluxCleanUp(k client.Client, ctx context.Context, log log.Logger, ns string) error {
+// FluxCleanUp cleans up flux resources. With dryRun set, the deletions are only sent as server-side dry-runs.
+func FluxCleanUp(k client.Client, ctx context.Context, logger fluxlog.Logger, ns string, dryRun bool) error {
 	// Set up the context with timeout
 	cwt, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
@@ -210,27 +398,27 @@ func FluxCleanUp(k client.Client, ctx context.Context, log log.Logger, ns string
 		silent        bool
 	}{
 		keepNamespace: false,
-		dryRun:        false,
+		dryRun:        dryRun,
 		silent:        false,
 	}
 
 	// Uninstall the components
-	if err := fluxuninstall.Components(cwt, log, k, ns, uninstallFlags.dryRun); err != nil {
+	if err := fluxuninstall.Components(cwt, logger, k, ns, uninstallFlags.dryRun); err != nil {
 		return err
 	}
 
 	// Uninstall the finalizers
-	if err := fluxuninstall.Finalizers(cwt, log, k, uninstallFlags.dryRun); err != nil {
+	if err := fluxuninstall.Finalizers(cwt, logger, k, uninstallFlags.dryRun); err != nil {
 		return err
 	}
 
 	// Uninstall CRDS
-	if err := fluxuninstall.CustomResourceDefinitions(cwt, log, k, uninstallFlags.dryRun); err != nil {
+	if err := fluxuninstall.CustomResourceDefinitions(cwt, logger, k, uninstallFlags.dryRun); err != nil {
 		return err
 	}
 
 	// Uninstall the namespace
-	if err := fluxuninstall.Namespace(cwt, log, k, ns, uninstallFlags.dryRun); err != nil {
+	if err := fluxuninstall.Namespace(cwt, logger, k, ns, uninstallFlags.dryRun); err != nil {
 		return err
 	}
 
@@ -251,6 +439,19 @@ func SuspendFluxObject(c client.Client, ctx context.Context, obj ...client.Objec
 	return nil
 }
 
+// ResumeFluxObject resumes suspended Flux specific objects based on the schema passed in the client.
+func ResumeFluxObject(c client.Client, ctx context.Context, obj ...client.Object) error {
+	// resume the objects
+	for _, o := range obj {
+		if err := c.Patch(ctx, o, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"suspend":false}}`))); err != nil {
+			return err
+		}
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
 // CreateK8SObjects Creates Kubernetes Objects on the Cluster based on the schema passed in the client.
 func CreateK8SObjects(c client.Client, ctx context.Context, obj ...client.Object) error {
 	// Migrate the objects
@@ -277,31 +478,26 @@ func DeleteK8SObjects(c client.Client, ctx context.Context, obj ...client.Object
 	return nil
 }
 
-// GenK8SSecret generates a kubernetes secret using a clientset
+// GenK8SSecret generates the Argo CD repository Secret for the Git repo of an ApplicationSet
 func GenK8SSecret(a argo.GitDirApplicationSet) *apiv1.Secret {
-	// Some Defaults
-	// TODO: Make these configurable
-	sData := map[string]string{}
-	sName := "mta-migration"
+	return GenRepositorySecret(a.Namespace, a.SecretName, "git", a.GitOpsRepo, a.Credentials)
+}
+
+// GenRepositorySecret generates an Argo CD repository Secret
+func GenRepositorySecret(ns string, name string, repoType string, repoURL string, creds argo.RepositoryCredentials) *apiv1.Secret {
 	sLabels := map[string]string{
 		"argocd.argoproj.io/secret-type": "repository",
 	}
 
-	sData = map[string]string{
-		"sshPrivateKey": a.SSHPrivateKey,
-		"type":          "git",
-		"url":           a.GitOpsRepo,
-	}
-
 	// Create the secret
 	s := &apiv1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      sName,
-			Namespace: a.Namespace,
+			Name:      name,
+			Namespace: ns,
 			Labels:    sLabels,
 		},
 		Type:       apiv1.SecretTypeOpaque,
-		StringData: sData,
+		StringData: creds.SecretData(repoType, repoURL),
 	}
 
 	// set the gvk for the secret
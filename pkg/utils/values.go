@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	yaml "sigs.k8s.io/yaml"
+)
+
+// HelmReleaseValues returns the values of a HelmRelease the way Flux merges them: every valuesFrom
+// reference in order, then spec.values on top. The values start with a comment saying where they
+// came from. Argo CD keeps the values of an Application in plain text, so values from Secrets are
+// refused, unless dropSecretValues is set: then they're left out and warned about.
+func HelmReleaseValues(c client.Client, ctx context.Context, h *helmv2.HelmRelease, dropSecretValues bool) (string, error) {
+	values := map[string]interface{}{}
+	provenance := []string{}
+
+	for _, ref := range h.Spec.ValuesFrom {
+		from := fmt.Sprintf("%s %s/%s key %s", ref.Kind, h.Namespace, ref.Name, ref.GetValuesKey())
+		if ref.TargetPath != "" {
+			from += " at " + ref.TargetPath
+		}
+
+		// Get the ConfigMap or Secret the values are in
+		var data map[string]string
+		switch ref.Kind {
+		case "ConfigMap":
+			cm := &apiv1.ConfigMap{}
+			err := c.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: ref.Name}, cm)
+			if apierrors.IsNotFound(err) && ref.Optional {
+				provenance = append(provenance, from+" (optional, not found)")
+				continue
+			}
+			if err != nil {
+				return "", fmt.Errorf("getting the values of HelmRelease %s/%s from %s: %w", h.Namespace, h.Name, from, err)
+			}
+			data = cm.Data
+		case "Secret":
+			if !dropSecretValues {
+				return "", fmt.Errorf("HelmRelease %s/%s gets values from %s, Argo CD would keep them in plain text in the Application. Move them somewhere Argo CD can get them, or migrate without them with --drop-secret-values", h.Namespace, h.Name, from)
+			}
+			LoggerFrom(ctx).Warn("HelmRelease \"" + h.Name + "\" gets values from " + from + ". They are NOT in the Argo CD Application, Argo CD keeps values in plain text. Add them to the Application in a way that keeps them secret before it syncs, or the release loses them")
+			provenance = append(provenance, from+" (left out, values from Secrets aren't copied)")
+			continue
+		default:
+			return "", fmt.Errorf("HelmRelease %s/%s gets values from an unsupported kind %q", h.Namespace, h.Name, ref.Kind)
+		}
+
+		v, ok := data[ref.GetValuesKey()]
+		if !ok {
+			if ref.Optional {
+				provenance = append(provenance, from+" (optional, key not found)")
+				continue
+			}
+			return "", fmt.Errorf("HelmRelease %s/%s gets values from %s, which doesn't have that key", h.Namespace, h.Name, from)
+		}
+
+		// A target path sets a single value, otherwise the key is a values file
+		if ref.TargetPath != "" {
+			if err := setValue(values, ref.TargetPath, v); err != nil {
+				return "", fmt.Errorf("HelmRelease %s/%s: %w", h.Namespace, h.Name, err)
+			}
+		} else {
+			file := map[string]interface{}{}
+			if err := yaml.Unmarshal([]byte(v), &file); err != nil {
+				return "", fmt.Errorf("parsing the values of HelmRelease %s/%s from %s: %w", h.Namespace, h.Name, from, err)
+			}
+			values = mergeValues(values, file)
+		}
+		provenance = append(provenance, from)
+	}
+
+	// spec.values always wins
+	if h.Spec.Values != nil {
+		values = mergeValues(values, h.GetValues())
+		provenance = append(provenance, "HelmRelease "+h.Namespace+"/"+h.Name+" spec.values")
+	}
+
+	if len(provenance) == 0 {
+		return "", nil
+	}
+
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	header := "# Values migrated from Flux, merged in this order:\n"
+	for _, p := range provenance {
+		header += "#   " + p + "\n"
+	}
+	return header + string(out), nil
+}
+
+// mergeValues merges src into dst, nested maps are merged and everything else is replaced
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// setValue sets the value at a dot separated path, like Flux does with targetPath. Dots in a key
+// are escaped with a backslash. true, false, null and integers are typed, like helm --set does.
+func setValue(values map[string]interface{}, targetPath string, value string) error {
+	if strings.ContainsAny(targetPath, "[]") {
+		return fmt.Errorf("the targetPath %q sets a list item, which isn't supported", targetPath)
+	}
+
+	keys := strings.Split(strings.ReplaceAll(targetPath, `\.`, "\x00"), ".")
+	m := values
+	for i, k := range keys {
+		k = strings.ReplaceAll(k, "\x00", ".")
+		if i == len(keys)-1 {
+			m[k] = typedValue(value)
+			break
+		}
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[k] = next
+		}
+		m = next
+	}
+
+	// If we're here, it should have gone okay...
+	return nil
+}
+
+// typedValue converts a value the way helm --set does
+func typedValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if value == "0" || !strings.HasPrefix(value, "0") {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return value
+}
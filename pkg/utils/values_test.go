@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2beta1"
+	"github.com/magiconair/properties/assert"
+	apiv1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHelmReleaseValues(t *testing.T) {
+	scheme := runtime.NewScheme()
+	apiv1.AddToScheme(scheme)
+
+	configMap := func(name string, data map[string]interface{}) *unstructured.Unstructured {
+		cm := &unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		cm.SetNamespace("flux-system")
+		cm.SetName(name)
+		unstructured.SetNestedField(cm.Object, data, "data")
+		return cm
+	}
+	c := NewOfflineClient(scheme,
+		configMap("base", map[string]interface{}{"values.yaml": "replicaCount: 1\nimage:\n  tag: 6.0.0\n  pullPolicy: Always\n"}),
+		configMap("overrides", map[string]interface{}{"custom.yaml": "image:\n  tag: 6.1.0\n", "replicas": "3", "host": "podinfo.example.com"}),
+	)
+
+	tests := []struct {
+		name             string
+		valuesFrom       []helmv2.ValuesReference
+		values           string
+		dropSecretValues bool
+		expectedValues   string
+		expectedErr      bool
+	}{
+		{
+			name:           "when there are no values",
+			expectedValues: "",
+		},
+		{
+			name:           "when there are only inline values",
+			values:         `{"replicaCount":2}`,
+			expectedValues: "replicaCount: 2\n",
+		},
+		{
+			name: "when values come from ConfigMaps and spec.values",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "base"},
+				{Kind: "ConfigMap", Name: "overrides", ValuesKey: "custom.yaml"},
+				{Kind: "ConfigMap", Name: "overrides", ValuesKey: "replicas", TargetPath: "replicaCount"},
+				{Kind: "ConfigMap", Name: "overrides", ValuesKey: "host", TargetPath: `ingress.annotations.external-dns\.alpha\.kubernetes\.io/hostname`},
+			},
+			values:         `{"image":{"pullPolicy":"IfNotPresent"}}`,
+			expectedValues: "image:\n  pullPolicy: IfNotPresent\n  tag: 6.1.0\ningress:\n  annotations:\n    external-dns.alpha.kubernetes.io/hostname: podinfo.example.com\nreplicaCount: 3\n",
+		},
+		{
+			name: "when an optional ConfigMap is missing",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "base"},
+				{Kind: "ConfigMap", Name: "missing", Optional: true},
+			},
+			expectedValues: "image:\n  pullPolicy: Always\n  tag: 6.0.0\nreplicaCount: 1\n",
+		},
+		{
+			name: "when a required ConfigMap is missing",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "missing"},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "when values come from a Secret",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "base"},
+				{Kind: "Secret", Name: "credentials"},
+			},
+			dropSecretValues: true,
+			expectedValues:   "image:\n  pullPolicy: Always\n  tag: 6.0.0\nreplicaCount: 1\n",
+		},
+		{
+			name: "when values come from a Secret and aren't dropped",
+			valuesFrom: []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "base"},
+				{Kind: "Secret", Name: "credentials"},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &helmv2.HelmRelease{}
+			h.SetNamespace("flux-system")
+			h.SetName("podinfo")
+			h.Spec.ValuesFrom = tt.valuesFrom
+			if tt.values != "" {
+				h.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(tt.values)}
+			}
+
+			values, err := HelmReleaseValues(c, context.TODO(), h, tt.dropSecretValues)
+			assert.Equal(t, err != nil, tt.expectedErr)
+
+			// Drop the comment saying where the values came from
+			lines := strings.Split(values, "\n")
+			for len(lines) > 0 && strings.HasPrefix(lines[0], "#") {
+				lines = lines[1:]
+			}
+			assert.Equal(t, strings.Join(lines, "\n"), tt.expectedValues)
+		})
+	}
+}
+
+func TestHelmReleaseValuesProvenance(t *testing.T) {
+	h := &helmv2.HelmRelease{}
+	h.SetNamespace("flux-system")
+	h.SetName("podinfo")
+	h.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "Secret", Name: "credentials", TargetPath: "auth.password"}}
+	h.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":2}`)}
+
+	values, err := HelmReleaseValues(NewOfflineClient(runtime.NewScheme()), context.TODO(), h, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, values, "# Values migrated from Flux, merged in this order:\n"+
+		"#   Secret flux-system/credentials key values.yaml at auth.password (left out, values from Secrets aren't copied)\n"+
+		"#   HelmRelease flux-system/podinfo spec.values\n"+
+		"replicaCount: 2\n")
+}
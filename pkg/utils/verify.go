@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/akuity/mta/pkg/argo"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WaitForArgoCD waits for an Application, or the Applications of an ApplicationSet, to be Synced
+// and Healthy. A timeout of 0 skips the wait.
+func WaitForArgoCD(c client.Client, ctx context.Context, obj client.Object, timeout time.Duration) error {
+	if timeout == 0 {
+		log.Warn("Not waiting for ", describeObject(c, obj), " to be Synced and Healthy")
+		return nil
+	}
+
+	log.Info("Waiting up to ", timeout, " for ", describeObject(c, obj), " to be Synced and Healthy")
+	if _, ok := obj.(*argov1alpha1.ApplicationSet); ok {
+		return argo.WaitForApplicationSet(c, ctx, obj.GetNamespace(), obj.GetName(), timeout)
+	}
+	return argo.WaitForApplication(c, ctx, obj.GetNamespace(), obj.GetName(), timeout)
+}
+
+// waitForArgoCD is WaitForArgoCD with the migration options, there's nothing to wait for in a dry-run
+func waitForArgoCD(c client.Client, ctx context.Context, obj client.Object, opts MigrationOptions) error {
+	if opts.DryRun {
+		log.Info("Dry-run: not waiting for ", describeObject(c, obj), " to be Synced and Healthy")
+		return nil
+	}
+	return WaitForArgoCD(c, ctx, obj, opts.WaitTimeout)
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akuity/mta/pkg/argo"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// generatedApp returns an Application owned by the mta-migration ApplicationSet
+func generatedApp(name string, sync string, health string) *unstructured.Unstructured {
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(argov1alpha1.SchemeGroupVersion.WithKind("Application"))
+	app.SetNamespace("argocd")
+	app.SetName(name)
+	app.SetOwnerReferences([]metav1.OwnerReference{{Kind: "ApplicationSet", Name: "mta-migration"}})
+	unstructured.SetNestedField(app.Object, sync, "status", "sync", "status")
+	unstructured.SetNestedField(app.Object, health, "status", "health", "status")
+	return app
+}
+
+func TestWaitForArgoCD(t *testing.T) {
+	scheme := runtime.NewScheme()
+	argov1alpha1.AddToScheme(scheme)
+
+	appset := &argov1alpha1.ApplicationSet{ObjectMeta: metav1.ObjectMeta{Namespace: "argocd", Name: "mta-migration"}}
+	ctx := context.TODO()
+
+	// The ApplicationSet controller is done with it
+	upToDate := appset.DeepCopy()
+	upToDate.Status.Conditions = []argov1alpha1.ApplicationSetCondition{{
+		Type:   argov1alpha1.ApplicationSetConditionResourcesUpToDate,
+		Status: argov1alpha1.ApplicationSetConditionStatusTrue,
+		Reason: argov1alpha1.ApplicationSetReasonApplicationSetUpToDate,
+	}}
+	failed := appset.DeepCopy()
+	failed.Status.Conditions = []argov1alpha1.ApplicationSetCondition{{
+		Type:    argov1alpha1.ApplicationSetConditionErrorOccurred,
+		Status:  argov1alpha1.ApplicationSetConditionStatusTrue,
+		Reason:  argov1alpha1.ApplicationSetReasonRenderTemplateParamsError,
+		Message: "application name is too long",
+	}}
+
+	tests := []struct {
+		name          string
+		appset        *argov1alpha1.ApplicationSet
+		apps          []*unstructured.Unstructured
+		timeout       time.Duration
+		pending       []string
+		expectedError string
+	}{
+		{"all ready", upToDate, []*unstructured.Unstructured{generatedApp("apps", "Synced", "Healthy"), generatedApp("infra", "Synced", "Healthy")}, time.Millisecond, nil, ""},
+		{"one degraded", upToDate, []*unstructured.Unstructured{generatedApp("apps", "Synced", "Healthy"), generatedApp("infra", "OutOfSync", "Degraded")}, time.Millisecond, []string{"infra"}, ""},
+		{"none generated yet", appset, nil, time.Millisecond, []string{}, "ApplicationSet argocd/mta-migration has no Applications after 1ms, check that the ApplicationSet controller is running"},
+		{"none to generate", upToDate, nil, time.Hour, []string{}, "ApplicationSet argocd/mta-migration generated no Applications: its generators matched nothing, check that the paths are in the repo"},
+		{"generation failed", failed, nil, time.Hour, []string{}, "ApplicationSet argocd/mta-migration generated no Applications: application name is too long"},
+	}
+
+	for _, tt := range tests {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tt.appset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := &unstructured.Unstructured{Object: u}
+		stored.SetGroupVersionKind(argov1alpha1.SchemeGroupVersion.WithKind("ApplicationSet"))
+		c := NewOfflineClient(scheme, append(tt.apps, stored)...)
+
+		// An ApplicationSet with nothing to generate doesn't wait for the timeout
+		err = WaitForArgoCD(c, ctx, appset, tt.timeout)
+		if tt.pending == nil {
+			assert.Equal(t, err, nil, tt.name)
+			continue
+		}
+		if tt.expectedError != "" {
+			assert.Equal(t, err.Error(), tt.expectedError, tt.name)
+		}
+
+		// Every Application that isn't ready is reported
+		var verr *argo.VerificationError
+		assert.Equal(t, errors.As(err, &verr), true, tt.name)
+		assert.Equal(t, len(verr.Pending), len(tt.pending), tt.name)
+		for i, p := range tt.pending {
+			assert.Equal(t, verr.Pending[i].Name, p, tt.name)
+			assert.Equal(t, strings.Contains(err.Error(), "Application argocd/"+p+" is OutOfSync and Degraded"), true, tt.name)
+		}
+	}
+
+	// A timeout of 0 doesn't wait at all
+	c := NewOfflineClient(scheme)
+	assert.Equal(t, WaitForArgoCD(c, ctx, appset, 0), nil)
+}